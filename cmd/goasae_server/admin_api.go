@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
@@ -20,9 +22,13 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/kdudkov/goasae/cmd/goasae_server/chats"
+	"github.com/kdudkov/goasae/cmd/goasae_server/missions"
 	"github.com/kdudkov/goasae/cmd/goasae_server/tak_ws"
 	"github.com/kdudkov/goasae/internal/client"
+	"github.com/kdudkov/goasae/internal/geofence"
 	"github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/internal/pm"
 	"github.com/kdudkov/goasae/internal/wshandler"
 	"github.com/kdudkov/goasae/pkg/cot"
 	"github.com/kdudkov/goasae/staticfiles"
@@ -66,11 +72,41 @@ func NewAdminAPI(app *App, addr string, webtakRoot string) *AdminAPI {
 
 	api.f.Get("/mp", getAllMissionPackagesHandler(app))
 	api.f.Get("/mp/:uid", getPackageHandler(app))
+	api.f.Get("/storage", getStorageHandler(app))
+	api.f.Post("/storage/gc", getStorageGCHandler(app))
+	api.f.Get("/storage/quarantine", getQuarantineHandler(app))
+	api.f.Delete("/storage/quarantine/:uid", getQuarantineDeleteHandler(app))
 
 	if app.missions != nil {
 		api.f.Get("/mission", getAllMissionHandler(app))
+		api.f.Get("/mission/:name/export", getMissionExportHandler(app))
+		api.f.Post("/mission/import", getMissionImportHandler(app))
+		api.f.Get("/mission/:name/snapshot", getMissionSnapshotsHandler(app))
+		api.f.Post("/mission/:name/snapshot", getMissionSnapshotPostHandler(app))
+		api.f.Get("/mission/:name/snapshot/:id", getMissionSnapshotHandler(app))
+		api.f.Delete("/mission/:name/snapshot/:id", getMissionSnapshotDeleteHandler(app))
+		api.f.Get("/mission/:name/snapshot/:id/diff/:other", getMissionSnapshotDiffHandler(app))
+		api.f.Post("/mission/:name/snapshot/:id/restore", getMissionSnapshotRestoreHandler(app))
 	}
 
+	api.f.Get("/geofence", getGeofencesHandler(app))
+	api.f.Post("/geofence", getGeofencePostHandler(app))
+	api.f.Delete("/geofence/:id", getGeofenceDeleteHandler(app))
+
+	api.f.Get("/feed", getFeedsHandler(app))
+	api.f.Post("/feed", getFeedPostHandler(app))
+	api.f.Get("/feed/:uid", getFeedHandler(app))
+	api.f.Delete("/feed/:uid", getFeedDeleteHandler(app))
+	api.f.Get("/relay", getRelayHandler(app))
+
+	api.f.Get("/transfer", getTransfersHandler(app))
+	api.f.Post("/transfer", getTransferPostHandler(app))
+	api.f.Get("/transfer/:id", getTransferHandler(app))
+	api.f.Delete("/transfer/:id", getTransferDeleteHandler(app))
+
+	api.f.Get("/emergency", getEmergenciesHandler(app))
+	api.f.Delete("/emergency/:uid", getEmergencyDeleteHandler(app))
+
 	api.f.All("/webtak", webTakPathHandler())
 	if webtakRoot != "" {
 		api.f.Static("/webtak", webtakRoot)
@@ -184,7 +220,16 @@ func getUnitsHandler(app *App) fiber.Handler {
 
 func getMessagesHandler(app *App) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
-		return ctx.JSON(app.messages)
+		q, err := getChatQuery(ctx)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		if scope := ctx.Query("scope"); scope != "" {
+			q.Scopes = []string{scope}
+		}
+
+		return ctx.JSON(chatsToModel(app.chats.GetHistory(q)))
 	}
 }
 
@@ -221,7 +266,7 @@ func deleteItemHandler(app *App) fiber.Handler {
 
 		r := make(map[string]any, 0)
 		r["units"] = getUnits(app)
-		r["messages"] = app.messages
+		r["messages"] = chatsToModel(app.chats.GetHistory(&chats.Query{Limit: 100}))
 
 		return ctx.JSON(r)
 	}
@@ -312,6 +357,254 @@ func getAllMissionHandler(app *App) fiber.Handler {
 	}
 }
 
+func getMissionExportHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		m := app.missions.GetMission(ctx.Query("scope"), ctx.Params("name"))
+
+		if m == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		app.audit.Info("mission_export", slog.String("name", m.Name), slog.String("scope", m.Scope), slog.String("by", "admin "+ctx.IP()))
+
+		ctx.Set(fiber.HeaderContentType, "application/zip")
+		ctx.Set(fiber.HeaderContentDisposition, "attachment; filename="+m.Name+".zip")
+
+		// package is streamed, error in the middle can only break the download
+		ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			if err := app.missions.Export(m, app.packageManager, w); err != nil {
+				app.logger.Error("mission export error", slog.Any("error", err))
+			}
+		})
+
+		return nil
+	}
+}
+
+func getMissionImportHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		b := ctx.Body()
+
+		if fh, err := ctx.FormFile("file"); err == nil {
+			f, err := fh.Open()
+			if err != nil {
+				return err
+			}
+
+			defer f.Close()
+
+			if b, err = io.ReadAll(f); err != nil {
+				return err
+			}
+		}
+
+		opts := missions.ImportOptions{Name: ctx.Query("name"), Conflict: ctx.Query("conflict")}
+
+		m, err := app.missions.Import(b, ctx.Query("scope"), opts, app.packageManager)
+		if err != nil {
+			app.logger.Error("mission import error", slog.Any("error", err))
+
+			if errors.Is(err, missions.ErrMissionExists) {
+				return ctx.Status(fiber.StatusConflict).SendString(err.Error())
+			}
+
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		app.audit.Info("mission_import", slog.String("name", m.Name), slog.String("scope", m.Scope), slog.String("by", "admin "+ctx.IP()))
+
+		return ctx.JSON(model.ToMissionDTOAdm(m, app.packageManager))
+	}
+}
+
+func getMissionSnapshotsHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		m := app.missions.GetMission(ctx.Query("scope"), ctx.Params("name"))
+
+		if m == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		snapshots := app.missions.GetSnapshots(m.ID)
+		result := make([]*model.SnapshotDTO, len(snapshots))
+
+		for i, s := range snapshots {
+			result[i] = model.ToSnapshotDTO(s, m.Name)
+		}
+
+		return ctx.JSON(result)
+	}
+}
+
+func getMissionSnapshotPostHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		m := app.missions.GetMission(ctx.Query("scope"), ctx.Params("name"))
+
+		if m == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		s, err := app.missions.CreateSnapshot(m, "admin", ctx.Query("comment"), false)
+		if err != nil {
+			app.logger.Error("snapshot error", slog.Any("error", err))
+
+			return err
+		}
+
+		app.audit.Info("mission_snapshot", slog.String("name", m.Name), slog.String("scope", m.Scope),
+			slog.Any("id", s.ID), slog.String("by", "admin "+ctx.IP()))
+
+		return ctx.Status(fiber.StatusCreated).JSON(model.ToSnapshotDTO(s, m.Name))
+	}
+}
+
+func getMissionSnapshotHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		m := app.missions.GetMission(ctx.Query("scope"), ctx.Params("name"))
+
+		if m == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		id, _ := ctx.ParamsInt("id")
+
+		s := app.missions.GetSnapshot(m.ID, uint(id))
+		if s == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		return ctx.JSON(model.ToSnapshotDTO(s, m.Name))
+	}
+}
+
+func getMissionSnapshotDeleteHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		m := app.missions.GetMission(ctx.Query("scope"), ctx.Params("name"))
+
+		if m == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		id, _ := ctx.ParamsInt("id")
+
+		if app.missions.GetSnapshot(m.ID, uint(id)) == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		app.missions.DeleteSnapshot(uint(id))
+
+		app.audit.Info("mission_snapshot_delete", slog.String("name", m.Name), slog.String("scope", m.Scope),
+			slog.Int("id", id), slog.String("by", "admin "+ctx.IP()))
+
+		return ctx.SendStatus(fiber.StatusOK)
+	}
+}
+
+func getMissionSnapshotDiffHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		m := app.missions.GetMission(ctx.Query("scope"), ctx.Params("name"))
+
+		if m == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		id, _ := ctx.ParamsInt("id")
+		other, _ := ctx.ParamsInt("other")
+
+		s1, s2 := app.missions.GetSnapshot(m.ID, uint(id)), app.missions.GetSnapshot(m.ID, uint(other))
+
+		if s1 == nil || s2 == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		return ctx.JSON(missions.DiffSnapshots(s1, s2))
+	}
+}
+
+func getMissionSnapshotRestoreHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		m := app.missions.GetMission(ctx.Query("scope"), ctx.Params("name"))
+
+		if m == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		id, _ := ctx.ParamsInt("id")
+
+		s := app.missions.GetSnapshot(m.ID, uint(id))
+		if s == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		changes, err := app.restoreMissionSnapshot(m, s, "")
+		if err != nil {
+			app.logger.Error("snapshot restore error", slog.Any("error", err))
+
+			return err
+		}
+
+		app.audit.Info("mission_restore", slog.String("name", m.Name), slog.String("scope", m.Scope),
+			slog.Any("id", s.ID), slog.Int("changes", len(changes)), slog.String("by", "admin "+ctx.IP()))
+
+		return ctx.JSON(model.ToMissionDTOAdm(app.missions.GetMissionById(m.ID), app.packageManager))
+	}
+}
+
+// getStorageHandler returns storage usage and what gc will remove
+func getStorageHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		usage, err := app.storageUsage()
+		if err != nil {
+			return err
+		}
+
+		report, err := app.collectGarbage(true)
+		if err != nil {
+			return err
+		}
+
+		return ctx.JSON(fiber.Map{"usage": usage, "gc": report})
+	}
+}
+
+func getStorageGCHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		report, err := app.collectGarbage(ctx.QueryBool("dry_run", false))
+		if err != nil {
+			app.logger.Error("storage gc error", slog.Any("error", err))
+
+			return err
+		}
+
+		if !report.DryRun {
+			app.audit.Info("storage_gc", slog.Int("expired", len(report.Expired)), slog.Int("files", len(report.Orphans)),
+				slog.Int64("freed", report.Freed), slog.String("by", "admin "+ctx.IP()))
+		}
+
+		return ctx.JSON(report)
+	}
+}
+
+func getQuarantineHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		return ctx.JSON(app.quarantine.List())
+	}
+}
+
+func getQuarantineDeleteHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		uid := ctx.Params("uid")
+
+		if !app.quarantine.Delete(uid) {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		app.audit.Info("quarantine_delete", slog.String("uid", uid), slog.String("by", "admin "+ctx.IP()))
+
+		return ctx.SendStatus(fiber.StatusNoContent)
+	}
+}
+
 func getAllMissionPackagesHandler(app *App) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		data := app.packageManager.GetList(nil)
@@ -365,11 +658,143 @@ func getWsHandler(app *App) fiber.Handler {
 		app.logger.Debug("ws listener connected")
 		app.changeCb.SubscribeNamed(name, h.SendItem)
 		app.deleteCb.SubscribeNamed(name, h.DeleteItem)
+		app.geofenceCb.SubscribeNamed(name, h.SendGeofenceEvent)
 		h.Listen()
 		app.logger.Debug("ws listener disconnected")
 	})
 }
 
+func getGeofencesHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		return ctx.JSON(app.geofences.GetList(nil))
+	}
+}
+
+func getGeofencePostHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		f := new(geofence.Fence)
+
+		if err := json.Unmarshal(ctx.Body(), f); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		if f.ID == "" {
+			f.ID = uuid.NewString()
+		}
+
+		f.Source = geofence.SourceAPI
+
+		if err := app.geofences.Put(f); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		return ctx.JSON(f)
+	}
+}
+
+func getGeofenceDeleteHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if !app.geofences.Remove(geofence.SourceAPI, ctx.Params("id")) {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		return nil
+	}
+}
+
+func getTransfersHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		return ctx.JSON(app.transfers.List(ctx.QueryInt("limit", 100)))
+	}
+}
+
+// getTransferPostHandler pushes stored file to contacts selected by callsign, uid, team or scope
+func getTransferPostHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		r := new(PushRequest)
+
+		if err := json.Unmarshal(ctx.Body(), r); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		if r.Hash == "" {
+			return ctx.Status(fiber.StatusBadRequest).SendString("no hash")
+		}
+
+		pi := app.packageManager.GetFirst(func(pi *pm.PackageInfo) bool {
+			return pi.Hash == r.Hash
+		})
+
+		if pi == nil {
+			return ctx.Status(fiber.StatusNotFound).SendString("file not found")
+		}
+
+		dest := app.pushRecipients(r)
+		if len(dest) == 0 {
+			return ctx.Status(fiber.StatusBadRequest).SendString("no recipients")
+		}
+
+		name := r.Name
+		if name == "" {
+			name = pi.Name
+		}
+
+		baseURL := r.BaseURL
+		if baseURL == "" {
+			baseURL = app.martiBaseURL(ctx.Hostname())
+		}
+
+		t, err := app.pushFile(pi, name, strings.TrimSuffix(baseURL, "/"), dest, "admin "+ctx.IP())
+		if err != nil {
+			app.logger.Error("push error", slog.Any("error", err))
+
+			return err
+		}
+
+		app.audit.Info("file_push", slog.String("id", t.ID), slog.String("hash", t.Hash), slog.String("name", t.Name),
+			slog.Int("recipients", len(t.Recipients)), slog.String("by", t.CreatedBy))
+
+		return ctx.Status(fiber.StatusCreated).JSON(t)
+	}
+}
+
+func getTransferHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		t := app.transfers.Get(ctx.Params("id"))
+		if t == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		return ctx.JSON(t)
+	}
+}
+
+func getTransferDeleteHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if !app.transfers.Delete(ctx.Params("id")) {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		return ctx.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+func getEmergenciesHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		return ctx.JSON(app.GetEmergencies())
+	}
+}
+
+func getEmergencyDeleteHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if !app.ClearEmergency(ctx.Params("uid"), "admin "+ctx.IP()) {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		return nil
+	}
+}
+
 // handler for WebTAK client - sends/receives protobuf COTs
 func getTakWsHandler(app *App) fiber.Handler {
 	return websocket.New(func(ws *websocket.Conn) {
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// newAuditLogger makes json logger that appends to audit.log in data dir
+func newAuditLogger(dataDir string) *slog.Logger {
+	if err := os.MkdirAll(dataDir, 0777); err != nil {
+		slog.Default().Error("can't create data dir", slog.Any("error", err))
+
+		return slog.Default().With("logger", "audit")
+	}
+
+	f, err := os.OpenFile(filepath.Join(dataDir, "audit.log"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		slog.Default().Error("can't open audit log", slog.Any("error", err))
+
+		return slog.Default().With("logger", "audit")
+	}
+
+	return slog.New(slog.NewJSONHandler(f, &slog.HandlerOptions{Level: slog.LevelInfo}))
+}
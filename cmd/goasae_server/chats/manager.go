@@ -0,0 +1,153 @@
+package chats
+
+import (
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/kdudkov/goasae/internal/model"
+)
+
+const maxLimit = 1000
+
+type ChatManager struct {
+	db     *gorm.DB
+	logger *slog.Logger
+}
+
+// Query is a filter for chat history. Empty fields are ignored, nil Scopes means any scope
+type Query struct {
+	Scopes          []string
+	Chatroom        string
+	Parent          string
+	UID             string
+	MissionID       uint
+	ExcludeMissions bool
+	// ReaderUIDs limits direct messages to ones sent from or to these uids, nil means any
+	ReaderUIDs []string
+	After           time.Time
+	Before          time.Time
+	Limit           int
+	Offset          int
+}
+
+func New(db *gorm.DB) *ChatManager {
+	return &ChatManager{
+		db:     db,
+		logger: slog.Default().With("logger", "ChatManager"),
+	}
+}
+
+// Add stores message, duplicates (by message id) are ignored
+func (cm *ChatManager) Add(c *model.ChatMessage) error {
+	if cm == nil || cm.db == nil {
+		return nil
+	}
+
+	return cm.db.Clauses(clause.OnConflict{DoNothing: true}).Create(c).Error
+}
+
+func (cm *ChatManager) Get(messageID string) *model.ChatMessage {
+	if cm == nil || cm.db == nil {
+		return nil
+	}
+
+	var c *model.ChatMessage
+
+	if err := cm.db.Where("message_id = ?", messageID).Take(&c).Error; err != nil {
+		return nil
+	}
+
+	return c
+}
+
+func (cm *ChatManager) SetDelivered(messageID string, t time.Time) bool {
+	return cm.setTime(messageID, "delivered", t)
+}
+
+// SetRead marks message as read, read message is delivered too
+func (cm *ChatManager) SetRead(messageID string, t time.Time) bool {
+	cm.setTime(messageID, "delivered", t)
+
+	return cm.setTime(messageID, "read", t)
+}
+
+func (cm *ChatManager) setTime(messageID string, field string, t time.Time) bool {
+	if cm == nil || cm.db == nil {
+		return false
+	}
+
+	res := cm.db.Model(&model.ChatMessage{}).
+		Where("message_id = ? AND "+field+" IS NULL", messageID).
+		Update(field, t)
+
+	if res.Error != nil {
+		cm.logger.Error("update error", slog.Any("error", res.Error))
+
+		return false
+	}
+
+	return res.RowsAffected > 0
+}
+
+// GetHistory returns messages matching the query, newest first
+func (cm *ChatManager) GetHistory(q *Query) []*model.ChatMessage {
+	if cm == nil || cm.db == nil {
+		return nil
+	}
+
+	tx := cm.db.Model(&model.ChatMessage{})
+
+	if q.Scopes != nil {
+		tx = tx.Where("scope IN ?", q.Scopes)
+	}
+
+	if q.Chatroom != "" {
+		tx = tx.Where("chatroom = ?", q.Chatroom)
+	}
+
+	if q.Parent != "" {
+		tx = tx.Where("parent = ?", q.Parent)
+	}
+
+	if q.UID != "" {
+		tx = tx.Where("from_uid = ? OR to_uid = ?", q.UID, q.UID)
+	}
+
+	if q.MissionID != 0 {
+		tx = tx.Where("mission_id = ?", q.MissionID)
+	}
+
+	if q.ReaderUIDs != nil {
+		if len(q.ReaderUIDs) == 0 {
+			tx = tx.Where("direct = ?", false)
+		} else {
+			tx = tx.Where("direct = ? OR from_uid IN ? OR to_uid IN ?", false, q.ReaderUIDs, q.ReaderUIDs)
+		}
+	}
+
+	if q.ExcludeMissions {
+		tx = tx.Where("mission_id = 0")
+	}
+
+	if !q.After.IsZero() {
+		tx = tx.Where("time > ?", q.After)
+	}
+
+	if !q.Before.IsZero() {
+		tx = tx.Where("time < ?", q.Before)
+	}
+
+	limit := q.Limit
+	if limit <= 0 || limit > maxLimit {
+		limit = maxLimit
+	}
+
+	var res []*model.ChatMessage
+
+	tx.Order("time desc").Limit(limit).Offset(q.Offset).Find(&res)
+
+	return res
+}
@@ -0,0 +1,71 @@
+package chats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/kdudkov/goasae/internal/database/dbtest"
+	"github.com/kdudkov/goasae/internal/model"
+)
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestHistory(t *testing.T) {
+	dbtest.ForEach(t, func(t *testing.T, db *gorm.DB) {
+		cm := New(db)
+		now := time.Now()
+
+		require.NoError(t, cm.Add(&model.ChatMessage{MessageID: "1", Scope: "s1", Time: now.Add(-time.Minute * 3), Chatroom: "All Chat Rooms", FromUID: "a"}))
+		require.NoError(t, cm.Add(&model.ChatMessage{MessageID: "2", Scope: "s1", Time: now.Add(-time.Minute * 2), Chatroom: "bbb", FromUID: "a", ToUID: "b", Direct: true}))
+		require.NoError(t, cm.Add(&model.ChatMessage{MessageID: "3", Scope: "s2", Time: now.Add(-time.Minute), Chatroom: "All Chat Rooms", FromUID: "c"}))
+
+		// duplicate is ignored
+		require.NoError(t, cm.Add(&model.ChatMessage{MessageID: "3", Scope: "s2", Time: now, Chatroom: "All Chat Rooms", FromUID: "c"}))
+
+		res := cm.GetHistory(&Query{})
+		require.Len(t, res, 3)
+		assert.Equal(t, "3", res[0].MessageID)
+
+		assert.Len(t, cm.GetHistory(&Query{Scopes: []string{"s1"}}), 2)
+		assert.Len(t, cm.GetHistory(&Query{Chatroom: "All Chat Rooms"}), 2)
+		assert.Len(t, cm.GetHistory(&Query{UID: "b"}), 1)
+		assert.Len(t, cm.GetHistory(&Query{ReaderUIDs: []string{}}), 2)
+		assert.Len(t, cm.GetHistory(&Query{ReaderUIDs: []string{"c"}}), 2)
+		assert.Len(t, cm.GetHistory(&Query{ReaderUIDs: []string{"b"}}), 3)
+		assert.Len(t, cm.GetHistory(&Query{After: now.Add(-time.Second * 150)}), 2)
+
+		res = cm.GetHistory(&Query{Limit: 1, Offset: 1})
+		require.Len(t, res, 1)
+		assert.Equal(t, "2", res[0].MessageID)
+	})
+}
+
+func TestReceipts(t *testing.T) {
+	dbtest.ForEach(t, func(t *testing.T, db *gorm.DB) {
+		cm := New(db)
+
+		require.NoError(t, cm.Add(&model.ChatMessage{MessageID: "1", Time: time.Now(), FromUID: "a", ToUID: "b", Direct: true}))
+		require.NoError(t, cm.Add(&model.ChatMessage{MessageID: "2", Time: time.Now(), FromUID: "a", ToUID: "b", Direct: true}))
+
+		assert.True(t, cm.SetDelivered("1", time.Now()))
+		assert.False(t, cm.SetDelivered("1", time.Now()))
+
+		c := cm.Get("1")
+		require.NotNil(t, c)
+		assert.NotNil(t, c.Delivered)
+		assert.Nil(t, c.Read)
+
+		assert.True(t, cm.SetRead("2", time.Now()))
+
+		c = cm.Get("2")
+		require.NotNil(t, c)
+		assert.NotNil(t, c.Delivered)
+		assert.NotNil(t, c.Read)
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+type content struct {
+	hash     string
+	size     int64
+	name     string
+	mimeType string
+	modified time.Time
+	// onSent is called when the last byte of the file is sent
+	onSent func()
+}
+
+func (c *content) etag() string {
+	return `"` + c.hash + `"`
+}
+
+// serveContent sends file with support of conditional and single range requests. File is closed after sending
+func serveContent(ctx *fiber.Ctx, f io.ReadSeekCloser, c *content) error {
+	ctx.Set(fiber.HeaderETag, c.etag())
+	ctx.Set(fiber.HeaderAcceptRanges, "bytes")
+
+	if !c.modified.IsZero() {
+		ctx.Set(fiber.HeaderLastModified, c.modified.UTC().Format(http.TimeFormat))
+	}
+
+	if c.mimeType != "" {
+		ctx.Set(fiber.HeaderContentType, c.mimeType)
+	} else {
+		ctx.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	}
+
+	if c.name != "" {
+		if cd := mime.FormatMediaType("attachment", map[string]string{"filename": c.name}); cd != "" {
+			ctx.Set(fiber.HeaderContentDisposition, cd)
+		}
+	}
+
+	if notModified(ctx, c) {
+		_ = f.Close()
+
+		return ctx.SendStatus(fiber.StatusNotModified)
+	}
+
+	start, length := int64(0), c.size
+
+	if r := ctx.Get(fiber.HeaderRange); r != "" && ifRange(ctx.Get(fiber.HeaderIfRange), c) {
+		var err error
+
+		start, length, err = parseRange(r, c.size)
+
+		switch {
+		case errors.Is(err, errRangeNotSatisfiable):
+			_ = f.Close()
+			ctx.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", c.size))
+
+			return ctx.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+		case err != nil:
+			// invalid or multiple ranges, whole file is sent
+			start, length = 0, c.size
+		default:
+			ctx.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, c.size))
+			ctx.Status(fiber.StatusPartialContent)
+		}
+	}
+
+	if start > 0 {
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			_ = f.Close()
+
+			return err
+		}
+	}
+
+	body := &limitedReadCloser{Reader: io.LimitReader(f, length), Closer: f, left: length}
+
+	if start+length == c.size {
+		body.onEnd = c.onSent
+	}
+
+	// body is read by fasthttp after the handler returns and closed after sending
+	ctx.Response().SetBodyStream(body, int(length))
+
+	return nil
+}
+
+// limitedReadCloser calls onEnd on close if all data is read
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+	left  int64
+	onEnd func()
+}
+
+func (r *limitedReadCloser) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.left -= int64(n)
+
+	return n, err
+}
+
+func (r *limitedReadCloser) Close() error {
+	if r.left == 0 && r.onEnd != nil {
+		r.onEnd()
+	}
+
+	return r.Closer.Close()
+}
+
+func notModified(ctx *fiber.Ctx, c *content) bool {
+	if inm := ctx.Get(fiber.HeaderIfNoneMatch); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+
+			if tag == "*" || tag == c.etag() {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if ims := ctx.Get(fiber.HeaderIfModifiedSince); ims != "" && !c.modified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil {
+			return !c.modified.Truncate(time.Second).After(t)
+		}
+	}
+
+	return false
+}
+
+// ifRange returns true if range should be used according to If-Range value
+func ifRange(v string, c *content) bool {
+	if v == "" {
+		return true
+	}
+
+	if strings.HasPrefix(v, `"`) {
+		return v == c.etag()
+	}
+
+	t, err := http.ParseTime(v)
+
+	return err == nil && !c.modified.IsZero() && c.modified.Truncate(time.Second).Equal(t)
+}
+
+// parseRange parses single range header like bytes=0-99, bytes=100- or bytes=-100. Returns start and length
+func parseRange(s string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(s, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("unsupported range %s", s)
+	}
+
+	from, to, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %s", s)
+	}
+
+	if from == "" {
+		n, err := strconv.ParseInt(to, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid range %s", s)
+		}
+
+		if n == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+
+		n = min(n, size)
+
+		return size - n, n, nil
+	}
+
+	start, err := strconv.ParseInt(from, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range %s", s)
+	}
+
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+
+	end := size - 1
+
+	if to != "" {
+		e, err := strconv.ParseInt(to, 10, 64)
+		if err != nil || e < start {
+			return 0, 0, fmt.Errorf("invalid range %s", s)
+		}
+
+		end = min(e, size-1)
+	}
+
+	return start, end - start + 1, nil
+}
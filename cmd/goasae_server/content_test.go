@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRange(t *testing.T) {
+	for _, tc := range []struct {
+		s      string
+		start  int64
+		length int64
+		err    bool
+	}{
+		{s: "bytes=0-9", start: 0, length: 10},
+		{s: "bytes=90-", start: 90, length: 10},
+		{s: "bytes=-5", start: 95, length: 5},
+		{s: "bytes=-500", start: 0, length: 100},
+		{s: "bytes=50-500", start: 50, length: 50},
+		{s: "bytes=100-", err: true},
+		{s: "bytes=0-1,5-6", err: true},
+		{s: "bytes=5-1", err: true},
+		{s: "items=0-1", err: true},
+	} {
+		t.Run(tc.s, func(t *testing.T) {
+			start, length, err := parseRange(tc.s, 100)
+
+			if tc.err {
+				assert.Error(t, err)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.start, start)
+			assert.Equal(t, tc.length, length)
+		})
+	}
+}
+
+func TestServeContent(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.WriteFile(name, []byte("0123456789"), 0600))
+
+	modified := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	var sent atomic.Int32
+
+	c := &content{hash: "abc", size: 10, name: "файл 1.zip", mimeType: "application/zip", modified: modified,
+		onSent: func() { sent.Add(1) }}
+
+	f := fiber.New()
+	f.Get("/", func(ctx *fiber.Ctx) error {
+		fh, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+
+		return serveContent(ctx, fh, c)
+	})
+
+	get := func(headers map[string]string) (*http.Response, string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		res, err := f.Test(req)
+		require.NoError(t, err)
+
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+
+		return res, string(b)
+	}
+
+	res, body := get(nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "0123456789", body)
+	assert.Equal(t, `"abc"`, res.Header.Get("ETag"))
+	assert.Equal(t, "attachment; filename*=utf-8''%D1%84%D0%B0%D0%B9%D0%BB%201.zip", res.Header.Get("Content-Disposition"))
+	assert.Equal(t, int32(1), sent.Load())
+
+	res, body = get(map[string]string{"Range": "bytes=2-4"})
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, "234", body)
+	assert.Equal(t, "bytes 2-4/10", res.Header.Get("Content-Range"))
+	assert.Equal(t, int32(1), sent.Load())
+
+	res, body = get(map[string]string{"Range": "bytes=2-4", "If-Range": `"other"`})
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "0123456789", body)
+
+	res, body = get(map[string]string{"Range": "bytes=5-", "If-Range": modified.Format(http.TimeFormat)})
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, "56789", body)
+	assert.Equal(t, int32(3), sent.Load())
+
+	res, _ = get(map[string]string{"Range": "bytes=20-"})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, res.StatusCode)
+	assert.Equal(t, "bytes */10", res.Header.Get("Content-Range"))
+
+	res, _ = get(map[string]string{"If-None-Match": `"x", "abc"`})
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+
+	res, _ = get(map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)})
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+}
+
+func TestLimitedReadCloser(t *testing.T) {
+	called := false
+
+	r := &limitedReadCloser{Reader: strings.NewReader("0123456789"), Closer: io.NopCloser(nil), left: 10, onEnd: func() { called = true }}
+
+	// client gone before the end
+	_, _ = r.Read(make([]byte, 4))
+	require.NoError(t, r.Close())
+	assert.False(t, called)
+
+	_, _ = io.ReadAll(r)
+	require.NoError(t, r.Close())
+	assert.True(t, called)
+}
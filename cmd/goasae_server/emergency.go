@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/kdudkov/goasae/internal/client"
+	"github.com/kdudkov/goasae/pkg/cot"
+)
+
+type Emergency struct {
+	UID       string    `json:"uid"`
+	SenderUID string    `json:"sender_uid"`
+	Callsign  string    `json:"callsign"`
+	Type      string    `json:"type"`
+	Scope     string    `json:"scope"`
+	Time      time.Time `json:"time"`
+	Lat       float64   `json:"lat"`
+	Lon       float64   `json:"lon"`
+
+	msg *cot.CotMessage
+}
+
+func isEmergencyCancel(msg *cot.CotMessage) bool {
+	return msg.GetType() == "b-a-o-can" || msg.GetDetail().GetFirst("emergency").GetAttr("cancel") == "true"
+}
+
+// emergencyProcessor tracks active emergencies (b-a-o-*) and forces their delivery to supervisors
+func (app *App) emergencyProcessor(msg *cot.CotMessage) bool {
+	if isEmergencyCancel(msg) {
+		if v, ok := app.emergencies.LoadAndDelete(msg.GetUID()); ok {
+			em := v.(*Emergency)
+			app.logger.Info(fmt.Sprintf("emergency %s from %s is cancelled", em.Type, em.Callsign))
+			app.audit.Info("emergency_cancel", slog.String("uid", em.UID), slog.String("sender_uid", em.SenderUID),
+				slog.String("callsign", em.Callsign), slog.String("scope", msg.Scope), slog.String("from", msg.From))
+		}
+
+		app.sendToSupervisors(msg)
+
+		return true
+	}
+
+	sender, callsign := msg.GetParent()
+	if callsign == "" {
+		callsign = msg.GetDetail().GetFirst("emergency").GetText()
+	}
+
+	em := &Emergency{
+		UID:       msg.GetUID(),
+		SenderUID: sender,
+		Callsign:  callsign,
+		Type:      msg.GetDetail().GetFirst("emergency").GetAttr("type"),
+		Scope:     msg.Scope,
+		Time:      time.Now(),
+		Lat:       msg.GetLat(),
+		Lon:       msg.GetLon(),
+		msg:       msg,
+	}
+
+	if em.Type == "" {
+		em.Type = cot.GetMsgType(msg.GetType())
+	}
+
+	if _, exists := app.emergencies.Swap(em.UID, em); !exists {
+		app.logger.Warn(fmt.Sprintf("emergency %s from %s", em.Type, em.Callsign))
+		app.audit.Info("emergency", slog.String("uid", em.UID), slog.String("sender_uid", em.SenderUID),
+			slog.String("callsign", em.Callsign), slog.String("type", em.Type), slog.String("scope", em.Scope),
+			slog.Float64("lat", em.Lat), slog.Float64("lon", em.Lon), slog.String("from", msg.From))
+	}
+
+	app.sendToSupervisors(msg)
+
+	return true
+}
+
+func isSupervisor(ch client.ClientHandler) bool {
+	login := ch.GetUser().GetLogin()
+	if login == "" {
+		return false
+	}
+
+	for _, s := range viper.GetStringSlice("emergency.supervisors") {
+		if s == login {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sendToSupervisors delivers message to supervisor users that can't see message scope
+func (app *App) sendToSupervisors(msg *cot.CotMessage) {
+	app.ForAllClients(func(ch client.ClientHandler) bool {
+		if ch.GetName() == msg.From || ch.CanSeeScope(msg.Scope) || !isSupervisor(ch) {
+			return true
+		}
+
+		if err := ch.SendMsg(crossScope(msg)); err != nil {
+			app.logger.Error(fmt.Sprintf("error sending emergency to %s", ch.GetName()), slog.Any("error", err))
+		}
+
+		return true
+	})
+}
+
+// resendEmergencies sends all active emergencies to newly connected contact
+func (app *App) resendEmergencies(uid string) {
+	app.ForAllClients(func(ch client.ClientHandler) bool {
+		if !ch.HasUID(uid) {
+			return true
+		}
+
+		for _, em := range app.GetEmergencies() {
+			if em.SenderUID == uid {
+				continue
+			}
+
+			if ch.CanSeeScope(em.Scope) {
+				_ = ch.SendMsg(em.msg)
+			} else if isSupervisor(ch) {
+				_ = ch.SendMsg(crossScope(em.msg))
+			}
+		}
+
+		return false
+	})
+}
+
+func (app *App) GetEmergencies() []*Emergency {
+	res := make([]*Emergency, 0)
+
+	app.emergencies.Range(func(_, value any) bool {
+		res = append(res, value.(*Emergency))
+
+		return true
+	})
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Time.Before(res[j].Time)
+	})
+
+	return res
+}
+
+// ClearEmergency removes emergency without cancel message (e.g. by admin)
+func (app *App) ClearEmergency(uid string, by string) bool {
+	v, ok := app.emergencies.LoadAndDelete(uid)
+	if !ok {
+		return false
+	}
+
+	em := v.(*Emergency)
+	app.audit.Info("emergency_clear", slog.String("uid", em.UID), slog.String("callsign", em.Callsign),
+		slog.String("scope", em.Scope), slog.String("by", by))
+
+	return true
+}
+
+// crossScope makes copy of message that is delivered regardless of client scope
+func crossScope(msg *cot.CotMessage) *cot.CotMessage {
+	return &cot.CotMessage{
+		From:       msg.From,
+		Scope:      cot.LocalScope,
+		TakMessage: msg.GetTakMessage(),
+		Detail:     msg.GetDetail(),
+	}
+}
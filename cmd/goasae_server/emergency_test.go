@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/cotproto"
+)
+
+func newEmergencyMessage(typ, uid string, detail string) *cot.CotMessage {
+	tak := cot.BasicMsg(typ, uid, time.Minute)
+	tak.CotEvent.Detail = &cotproto.Detail{XmlDetail: detail}
+
+	det, _ := cot.DetailsFromString(detail)
+
+	return &cot.CotMessage{TakMessage: tak, Detail: det, Scope: "s1"}
+}
+
+func TestEmergencyRaiseCancel(t *testing.T) {
+	app := &App{logger: slog.Default(), audit: slog.Default()}
+
+	msg := newEmergencyMessage("b-a-o-tbl", "ANDROID-1-9-1-1",
+		"<link uid=\"ANDROID-1\" type=\"a-f-G-U-C\" relation=\"p-p\"/><emergency type=\"911 Alert\">Alpha</emergency>")
+
+	assert.True(t, app.emergencyProcessor(msg))
+
+	list := app.GetEmergencies()
+	require.Len(t, list, 1)
+	assert.Equal(t, "ANDROID-1-9-1-1", list[0].UID)
+	assert.Equal(t, "ANDROID-1", list[0].SenderUID)
+	assert.Equal(t, "Alpha", list[0].Callsign)
+	assert.Equal(t, "911 Alert", list[0].Type)
+	assert.Equal(t, "s1", list[0].Scope)
+
+	// repeated alert does not make new emergency
+	assert.True(t, app.emergencyProcessor(msg))
+	assert.Len(t, app.GetEmergencies(), 1)
+
+	cancel := newEmergencyMessage("b-a-o-can", "ANDROID-1-9-1-1",
+		"<link uid=\"ANDROID-1\" type=\"a-f-G-U-C\" relation=\"p-p\"/><emergency cancel=\"true\">Alpha</emergency>")
+
+	assert.True(t, app.emergencyProcessor(cancel))
+	assert.Empty(t, app.GetEmergencies())
+}
+
+func TestEmergencyClear(t *testing.T) {
+	app := &App{logger: slog.Default(), audit: slog.Default()}
+
+	app.emergencyProcessor(newEmergencyMessage("b-a-o-opn", "uid1", "<emergency type=\"In Contact\">Bravo</emergency>"))
+
+	assert.False(t, app.ClearEmergency("uid2", "test"))
+	assert.True(t, app.ClearEmergency("uid1", "test"))
+	assert.Empty(t, app.GetEmergencies())
+}
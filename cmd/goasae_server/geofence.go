@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+
+	"github.com/kdudkov/goasae/internal/geofence"
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+const GEOFENCE_MESSAGE_FROM_UID = "GEOFENCE_UID"
+
+// checkGeofences is called on every contact or unit position update
+func (app *App) checkGeofences(item *model.Item) {
+	if app.geofences == nil {
+		return
+	}
+
+	lat, lon := item.GetLanLon()
+
+	for _, ev := range app.geofences.Check(item.GetUID(), item.GetCallsign(), item.GetScope(), lat, lon, time.Now()) {
+		app.logger.Info("geofence: " + ev.String())
+		app.geofenceCb.AddMessage(ev)
+		app.notifyGeofenceEvent(ev)
+	}
+}
+
+// updateDrawingFence makes fence from u-d-f/u-d-c-c drawing if enabled in config
+func (app *App) updateDrawingFence(msg *cot.CotMessage) {
+	if app.geofences == nil || !viper.GetBool("geofence.drawings") || !geofence.IsDrawing(msg) {
+		return
+	}
+
+	if f := geofence.FromDrawing(msg); f != nil {
+		if err := app.geofences.Put(f); err != nil {
+			app.logger.Warn("invalid drawing fence " + msg.GetUID())
+		}
+	}
+}
+
+func (app *App) removeDrawingFence(uid string) {
+	if app.geofences == nil {
+		return
+	}
+
+	app.geofences.Remove(geofence.SourceDrawing, uid)
+}
+
+func (app *App) notifyGeofenceEvent(ev *geofence.Event) {
+	callsigns := ev.Notify
+	if len(callsigns) == 0 {
+		callsigns = viper.GetStringSlice("geofence.notify")
+	}
+
+	for _, cs := range callsigns {
+		dest := app.items.GetByCallsign(cs)
+		if dest == nil || dest.GetClass() != model.CONTACT {
+			continue
+		}
+
+		chat := &model.ChatMessage{
+			ID:       uuid.NewString(),
+			Time:     ev.Time,
+			Parent:   "RootContactGroup",
+			Chatroom: cs,
+			From:     "Geofence",
+			FromUID:  GEOFENCE_MESSAGE_FROM_UID,
+			ToUID:    dest.GetUID(),
+			Direct:   true,
+			Text:     fmt.Sprintf("%s %s %s", ev.Callsign, ev.Type, ev.FenceName),
+		}
+
+		app.NewCotMessage(cot.LocalCotMessage(model.MakeChatMessage(chat)))
+	}
+}
@@ -18,17 +18,22 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/glebarez/sqlite"
 	"github.com/google/uuid"
 	"github.com/kdudkov/goutils/callback"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/viper"
-	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 	"software.sslmate.com/src/go-pkcs12"
 
+	"github.com/kdudkov/goasae/cmd/goasae_server/chats"
 	"github.com/kdudkov/goasae/cmd/goasae_server/missions"
+	"github.com/kdudkov/goasae/cmd/goasae_server/outbox"
+	"github.com/kdudkov/goasae/cmd/goasae_server/packages"
+	"github.com/kdudkov/goasae/cmd/goasae_server/rules"
+	"github.com/kdudkov/goasae/cmd/goasae_server/transfers"
+	"github.com/kdudkov/goasae/cmd/goasae_server/video"
 	"github.com/kdudkov/goasae/internal/client"
+	"github.com/kdudkov/goasae/internal/database"
+	"github.com/kdudkov/goasae/internal/geofence"
 	im "github.com/kdudkov/goasae/internal/model"
 	"github.com/kdudkov/goasae/internal/pm"
 	"github.com/kdudkov/goasae/internal/repository"
@@ -37,10 +42,6 @@ import (
 	"github.com/kdudkov/goasae/pkg/tlsutil"
 )
 
-const (
-	dbName = "db.sqlite"
-)
-
 var (
 	lastSeenOfflineTimeout = time.Minute * 5
 )
@@ -86,11 +87,49 @@ type AppConfig struct {
 	connections []string
 
 	serials []string
+
+	rules     []*rules.Rule
+	rulesFile string
+
+	outboxTTL   time.Duration
+	outboxSize  int
+	outboxTypes []string
+
+	changesTTL       time.Duration
+	snapshotInterval time.Duration
+	snapshotKeep     int
+
+	quota      pm.Quota
+	retention  []*pm.RetentionRule
+	gcInterval time.Duration
+	uploadTTL  time.Duration
+
+	// clamd is the scanner address, scanning is off when empty
+	clamd        string
+	scanTimeout  time.Duration
+	scanFailOpen bool
+
+	// feed sources are checked every probeInterval, 0 disables checks
+	probeInterval time.Duration
+	probeTimeout  time.Duration
+	// relayAddr is rtsp relay listen address, relay is off when empty
+	relayAddr string
+	// relayKey signs relay urls, random key is used when empty
+	relayKey string
+
+	// s3 is set when files are kept in object storage
+	s3 *pm.S3Config
+
+	db database.Config
 }
 
 type App struct {
 	logger         *slog.Logger
 	packageManager pm.PackageManager
+	packageIndex   *packages.Index
+	uploads        *pm.UploadManager
+	scanner        pm.Scanner
+	quarantine     *pm.Quarantine
 	config         *AppConfig
 	lat            float64
 	lon            float64
@@ -98,15 +137,25 @@ type App struct {
 
 	handlers sync.Map
 
-	changeCb *callback.Callback[*model.Item]
-	deleteCb *callback.Callback[string]
+	changeCb   *callback.Callback[*model.Item]
+	deleteCb   *callback.Callback[string]
+	geofenceCb *callback.Callback[*geofence.Event]
+
+	items     repository.ItemsRepository
+	chats     *chats.ChatManager
+	outbox    *outbox.Outbox
+	transfers *transfers.TransferManager
+	feeds     repository.FeedsRepository
+	prober    *video.Prober
+	relay     *video.Relay
+	missions  *missions.MissionManager
 
-	items    repository.ItemsRepository
-	messages []*model.ChatMessage
-	feeds    repository.FeedsRepository
-	missions *missions.MissionManager
+	geofences   *geofence.Manager
+	emergencies sync.Map
 
 	users repository.UserRepository
+	rules *rules.Engine
+	audit *slog.Logger
 
 	uid             string
 	ch              chan *cot.CotMessage
@@ -117,29 +166,62 @@ func NewApp(config *AppConfig) *App {
 	app := &App{
 		logger:          slog.Default(),
 		config:          config,
-		packageManager:  pm.NewPackageManager(filepath.Join(config.dataDir, "mp")),
+		uploads:         pm.NewUploadManager(filepath.Join(config.dataDir, "mp", "uploads"), config.uploadTTL),
+		quarantine:      pm.NewQuarantine(filepath.Join(config.dataDir, "mp", "quarantine")),
 		users:           repository.NewFileUserRepo(config.usersFile),
 		ch:              make(chan *cot.CotMessage, 100),
 		handlers:        sync.Map{},
 		changeCb:        callback.New[*model.Item](),
 		deleteCb:        callback.New[string](),
+		geofenceCb:      callback.New[*geofence.Event](),
 		items:           repository.NewItemsMemoryRepo(),
 		feeds:           repository.NewFeedsFileRepo(filepath.Join(config.dataDir, "feeds")),
+		prober:          video.NewProber(config.probeTimeout),
+		geofences:       geofence.New(filepath.Join(config.dataDir, "geofence")),
+		audit:           newAuditLogger(config.dataDir),
 		uid:             uuid.NewString(),
 		eventProcessors: make([]*EventProcessor, 0),
 	}
 
-	if app.config.dataSync {
-		db, err := getDatabase()
+	if config.clamd != "" {
+		app.scanner = pm.NewClamdScanner(config.clamd, config.scanTimeout)
+	}
 
+	if config.relayAddr != "" {
+		key := config.relayKey
+		if key == "" {
+			key = uuid.NewString()
+		}
+
+		app.relay = video.NewRelay(config.relayAddr, []byte(key), app.relayFeed)
+	}
+
+	if len(config.rules) > 0 || config.rulesFile != "" {
+		engine, err := rules.New(config.rules, config.rulesFile)
 		if err != nil {
 			panic(err)
 		}
 
+		app.rules = engine
+	}
+
+	db, err := database.Open(config.db, config.dataDir)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := database.Migrate(db); err != nil {
+		panic(err)
+	}
+
+	app.packageIndex = packages.New(db)
+	app.packageManager = newPackageManager(config, app.packageIndex)
+	app.chats = chats.New(db)
+	app.outbox = outbox.New(db, config.outboxTTL, config.outboxSize, config.outboxTypes)
+	app.transfers = transfers.New(db)
+
+	if app.config.dataSync {
 		app.missions = missions.New(db)
-		if err := app.missions.Migrate(); err != nil {
-			panic(err)
-		}
 	}
 
 	return app
@@ -164,6 +246,22 @@ func (app *App) Run() {
 		log.Fatal(err)
 	}
 
+	if err := app.uploads.Start(); err != nil {
+		log.Fatal(err)
+	}
+
+	if app.geofences != nil {
+		if err := app.geofences.Start(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if app.rules != nil {
+		if err := app.rules.Start(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	if app.config.udpAddr != "" {
@@ -220,6 +318,16 @@ func (app *App) Run() {
 
 	go app.messageProcessLoop()
 	go app.cleaner()
+	go app.storageGC()
+	go app.probeFeeds(ctx)
+
+	if app.relay != nil {
+		if err := app.relay.Start(); err != nil {
+			app.logger.Error("video relay start error", slog.Any("error", err))
+		} else {
+			defer app.relay.Stop()
+		}
+	}
 
 	for _, c := range app.config.connections {
 		app.logger.Info("start external connection to " + c)
@@ -292,6 +400,8 @@ func (app *App) RemoveHandlerCb(cl client.ClientHandler) {
 
 func (app *App) NewContactCb(uid, callsign string) {
 	app.logger.Info(fmt.Sprintf("new contact: %s %s", uid, callsign))
+	app.resendEmergencies(uid)
+	app.deliverOutbox(uid)
 }
 
 func (app *App) ConnectTo(ctx context.Context, addr string) {
@@ -347,7 +457,7 @@ func (app *App) connect(connectStr string) (net.Conn, error) {
 		return nil, fmt.Errorf("invalid connect string: %s", connectStr)
 	}
 
-	addr := fmt.Sprintf("%s:%s", parts[0], parts[1])
+	addr := net.JoinHostPort(parts[0], parts[1])
 
 	if tlsConn {
 		app.logger.Info(fmt.Sprintf("connecting with SSL to %s...", connectStr))
@@ -421,6 +531,14 @@ func (app *App) route(msg *cot.CotMessage) bool {
 		return true
 	}
 
+	if dest := msg.GetDetail().GetDestUID(); len(dest) > 0 {
+		for _, uid := range dest {
+			app.sendToUID(uid, msg)
+		}
+
+		return true
+	}
+
 	if dest := msg.GetDetail().GetDestCallsign(); len(dest) > 0 {
 		for _, s := range dest {
 			app.sendToCallsign(s, msg)
@@ -435,12 +553,22 @@ func (app *App) route(msg *cot.CotMessage) bool {
 }
 
 func (app *App) processMissionPoint(missionName string, msg *cot.CotMessage) {
+	if app.missions == nil {
+		return
+	}
+
 	m := app.missions.GetMission(msg.Scope, missionName)
 
 	if m == nil {
 		return
 	}
 
+	if !msg.IsLocal() && !app.canWriteMission(m, msg) {
+		app.logger.Warn(fmt.Sprintf("%s can't write to mission %s", msg.From, missionName))
+
+		return
+	}
+
 	var change *im.Change
 
 	if msg.GetType() == "t-x-d-d" {
@@ -456,20 +584,101 @@ func (app *App) processMissionPoint(missionName string, msg *cot.CotMessage) {
 	}
 }
 
+// isClientOf checks that client with this uid is connected now as user
+func (app *App) isClientOf(uid, username string) bool {
+	if uid == "" {
+		return false
+	}
+
+	found := false
+
+	app.ForAllClients(func(ch client.ClientHandler) bool {
+		if ch.HasUID(uid) {
+			found = ch.GetUser().GetLogin() == username
+
+			return false
+		}
+
+		return true
+	})
+
+	return found
+}
+
+// userUIDs returns uids of clients connected now as user
+func (app *App) userUIDs(username string) []string {
+	uids := make([]string, 0)
+
+	app.ForAllClients(func(ch client.ClientHandler) bool {
+		if ch.GetUser().GetLogin() == username {
+			for uid := range ch.GetUids() {
+				uids = append(uids, uid)
+			}
+		}
+
+		return true
+	})
+
+	return uids
+}
+
+// canWriteMission checks if any contact of the message source has write permission in the mission
+func (app *App) canWriteMission(m *im.Mission, msg *cot.CotMessage) bool {
+	allowed := false
+
+	app.ForAllClients(func(ch client.ClientHandler) bool {
+		if ch.GetName() != msg.From {
+			return true
+		}
+
+		for uid := range ch.GetUids() {
+			if im.HasPermission(app.missions.GetRole(m, uid, ch.GetUser().GetLogin()), im.PermWrite) {
+				allowed = true
+			}
+		}
+
+		return false
+	})
+
+	return allowed
+}
+
 func (app *App) notifyMissionSubscribers(mission *im.Mission, c *im.Change) {
 	if mission == nil || c == nil {
 		return
 	}
 
 	msg := im.MissionChangeNotificationMsg(mission.Name, mission.Scope, c)
-	for _, uid := range app.missions.GetSubscribers(mission.ID) {
-		app.sendToUID(uid, msg)
+	seen := make(map[string]bool)
+
+	// subscribers of parent missions get changes of children too
+	for _, m := range append([]*im.Mission{mission}, app.missions.GetParents(mission)...) {
+		for _, uid := range app.missions.GetSubscribers(m.ID) {
+			if !seen[uid] {
+				seen[uid] = true
+				app.sendToUID(uid, msg)
+			}
+		}
 	}
 }
 
 func (app *App) cleaner() {
 	for range time.Tick(time.Minute) {
 		app.cleanOldUnits()
+		app.outbox.Cleanup()
+		app.compactChanges()
+		app.autoSnapshots()
+		app.cleanUploads()
+	}
+}
+
+func (app *App) compactChanges() {
+	if app.missions == nil || app.config.changesTTL <= 0 {
+		return
+	}
+
+	if n := app.missions.CompactChanges(time.Now().Add(-app.config.changesTTL)); n > 0 {
+		app.logger.Info(fmt.Sprintf("%d old mission changes compacted", n))
 	}
 }
 
@@ -498,6 +707,7 @@ func (app *App) cleanOldUnits() {
 
 	for _, uid := range toDelete {
 		app.items.Remove(uid)
+		app.removeDrawingFence(uid)
 		app.deleteCb.AddMessage(uid)
 	}
 }
@@ -544,6 +754,11 @@ func (app *App) sendBroadcast(msg *cot.CotMessage) {
 }
 
 func (app *App) sendToCallsign(callsign string, msg *cot.CotMessage) {
+	// the same message can be sent to many recipients, so it is queued before the storm check
+	if item := app.items.GetByCallsign(callsign); item != nil && item.GetClass() == model.CONTACT {
+		app.queueIfOffline(item.GetUID(), msg)
+	}
+
 	if mayCauseBroadCastStorm(msg) {
 		return
 	}
@@ -553,7 +768,7 @@ func (app *App) sendToCallsign(callsign string, msg *cot.CotMessage) {
 			return true
 		}
 
-		app.logger.Warn("sending", msg.Detail.AsXMLString(), "\nfrom", msg.From, "to", ch.GetName())
+		app.logger.Debug(fmt.Sprintf("sending %s from %s to %s", msg.GetUID(), msg.From, ch.GetName()))
 
 		// 对 fed 服务器的链路使用无条件广播，确保信息能传出
 		if strings.HasPrefix(ch.GetName(), "fed_") || ch.GetName() != msg.From {
@@ -575,6 +790,8 @@ func (app *App) sendToCallsign(callsign string, msg *cot.CotMessage) {
 }
 
 func (app *App) sendToUID(uid string, msg *cot.CotMessage) {
+	app.queueIfOffline(uid, msg)
+
 	if mayCauseBroadCastStorm(msg) {
 		return
 	}
@@ -655,19 +872,24 @@ func processCerts(conf *AppConfig) error {
 	return nil
 }
 
-func getDatabase() (*gorm.DB, error) {
-	db, err := gorm.Open(sqlite.Open(dbName), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
-	if err != nil {
-		return nil, err
-	}
-
-	return db, nil
-}
-
 func main() {
 	fmt.Printf("version %s\n", getVersion())
+
+	workdir := flag.String("workdir", "/root", "working directory, empty to keep current")
+	conf := flag.String("config", "goasae_server.yml", "name of config file")
+	exportName := flag.String("export", "", "export mission with this name to zip file and exit")
+	importFile := flag.String("import", "", "import mission from zip file and exit")
+	scope := flag.String("scope", "", "mission scope for export and import")
+	out := flag.String("out", "", "file name for mission export")
+	name := flag.String("name", "", "new mission name for import")
+	conflict := flag.String("conflict", missions.ConflictFail, "import policy for existing uids: fail, skip or new")
+	flag.Parse()
+
 	//Linux改变工作目录
-	err := os.Chdir("/root")
+	var err error
+	if *workdir != "" {
+		err = os.Chdir(*workdir)
+	}
 	if err!= nil {
 		fmt.Println("Error changing working directory:", err)
 		os.Exit(-1)
@@ -679,9 +901,6 @@ func main() {
 	}
 	fmt.Printf("Current working directory %s:\n", wd)
 
-	conf := flag.String("config", "goasae_server.yml", "name of config file")
-	flag.Parse()
-
 	viper.SetConfigFile(*conf)
 
 	viper.SetDefault("udp_addr", ":8999")
@@ -697,6 +916,13 @@ func main() {
 
 	viper.SetDefault("me.zoom", 10)
 	viper.SetDefault("ssl.cert_ttl_days", 365)
+	viper.SetDefault("outbox.ttl", "24h")
+	viper.SetDefault("outbox.max_size", 100)
+	viper.SetDefault("missions.snapshot_keep", 24)
+	viper.SetDefault("storage.gc_interval", "1h")
+	viper.SetDefault("storage.upload_ttl", "24h")
+	viper.SetDefault("video.probe_interval", "1m")
+	viper.SetDefault("video.probe_timeout", "5s")
 
 	err = viper.ReadInConfig()
 	if err != nil {
@@ -717,24 +943,46 @@ func main() {
 	slog.SetDefault(slog.New(h))
 
 	config := &AppConfig{
-		udpAddr:     viper.GetString("udp_addr"),
-		tcpAddr:     viper.GetString("tcp_addr"),
-		tcpFedAddr:  viper.GetString("tcp_fed_addr"),
-		adminAddr:   viper.GetString("admin_addr"),
-		apiAddr:     viper.GetString("api_addr"),
-		certAddr:    viper.GetString("cert_addr"),
-		tlsAddr:     viper.GetString("ssl_addr"),
-		useSsl:      viper.GetBool("ssl.use_ssl"),
-		logging:     viper.GetBool("log"),
-		dataDir:     viper.GetString("data_dir"),
-		debug:       debug,
-		connections: viper.GetStringSlice("connections"),
-		serials:     viper.GetStringSlice("serials"),
-		usersFile:   viper.GetString("users_file"),
-		webtakRoot:  viper.GetString("webtak_root"),
-		certTTLDays: viper.GetInt("ssl.cert_ttl_days"),
-		dataSync:    viper.GetBool("datasync"),
-		feds:        &[]FedConfig{},
+		udpAddr:          viper.GetString("udp_addr"),
+		tcpAddr:          viper.GetString("tcp_addr"),
+		tcpFedAddr:       viper.GetString("tcp_fed_addr"),
+		adminAddr:        viper.GetString("admin_addr"),
+		apiAddr:          viper.GetString("api_addr"),
+		certAddr:         viper.GetString("cert_addr"),
+		tlsAddr:          viper.GetString("ssl_addr"),
+		useSsl:           viper.GetBool("ssl.use_ssl"),
+		logging:          viper.GetBool("log"),
+		dataDir:          viper.GetString("data_dir"),
+		debug:            debug,
+		connections:      viper.GetStringSlice("connections"),
+		serials:          viper.GetStringSlice("serials"),
+		usersFile:        viper.GetString("users_file"),
+		webtakRoot:       viper.GetString("webtak_root"),
+		certTTLDays:      viper.GetInt("ssl.cert_ttl_days"),
+		dataSync:         viper.GetBool("datasync"),
+		feds:             &[]FedConfig{},
+		rulesFile:        viper.GetString("rules_file"),
+		outboxTTL:        viper.GetDuration("outbox.ttl"),
+		outboxSize:       viper.GetInt("outbox.max_size"),
+		outboxTypes:      viper.GetStringSlice("outbox.types"),
+		changesTTL:       viper.GetDuration("missions.changes_ttl"),
+		snapshotInterval: viper.GetDuration("missions.snapshot_interval"),
+		snapshotKeep:     viper.GetInt("missions.snapshot_keep"),
+		gcInterval:       viper.GetDuration("storage.gc_interval"),
+		uploadTTL:        viper.GetDuration("storage.upload_ttl"),
+		clamd:            viper.GetString("scan.clamd"),
+		scanTimeout:      viper.GetDuration("scan.timeout"),
+		scanFailOpen:     viper.GetBool("scan.fail_open"),
+		probeInterval:    viper.GetDuration("video.probe_interval"),
+		probeTimeout:     viper.GetDuration("video.probe_timeout"),
+		relayAddr:        viper.GetString("video.relay_addr"),
+		relayKey:         viper.GetString("video.relay_key"),
+		db: database.Config{
+			DSN:             viper.GetString("database.dsn"),
+			MaxOpenConns:    viper.GetInt("database.max_open_conns"),
+			MaxIdleConns:    viper.GetInt("database.max_idle_conns"),
+			ConnMaxLifetime: viper.GetDuration("database.conn_max_lifetime"),
+		},
 	}
 
 	feds, ok := viper.Get("feds").([]interface{})
@@ -750,12 +998,77 @@ func main() {
 		slog.Default().Info("no feds found in configuration")
 	}
 
+	// invalid rules, retention rules and quotas stop the server like invalid rules file does
+	if rr, ok := viper.Get("rules").([]interface{}); ok {
+		for _, r := range rr {
+			rule := new(rules.Rule)
+			if err := decodeMapToStruct(&r, rule); err != nil {
+				panic(fmt.Errorf("invalid rule: %w", err))
+			}
+			config.rules = append(config.rules, rule)
+		}
+	}
+
+	if rr, ok := viper.Get("storage.retention").([]interface{}); ok {
+		for _, r := range rr {
+			rc := new(RetentionConfig)
+			if err := decodeMapToStruct(&r, rc); err != nil {
+				panic(fmt.Errorf("invalid retention rule: %w", err))
+			}
+
+			rule, err := rc.Rule()
+			if err != nil {
+				panic(fmt.Errorf("invalid retention rule: %w", err))
+			}
+
+			config.retention = append(config.retention, rule)
+		}
+	}
+
+	for _, q := range []struct {
+		key string
+		val *int64
+	}{{"storage.user_quota", &config.quota.User}, {"storage.scope_quota", &config.quota.Scope}} {
+		if *q.val, err = pm.ParseSize(viper.GetString(q.key)); err != nil {
+			panic(fmt.Errorf("invalid %s: %w", q.key, err))
+		}
+	}
+
+	if viper.GetString("storage.backend") == "s3" {
+		config.s3 = &pm.S3Config{
+			Endpoint:  viper.GetString("storage.s3.endpoint"),
+			Region:    viper.GetString("storage.s3.region"),
+			Bucket:    viper.GetString("storage.s3.bucket"),
+			Prefix:    viper.GetString("storage.s3.prefix"),
+			AccessKey: viper.GetString("storage.s3.access_key"),
+			SecretKey: viper.GetString("storage.s3.secret_key"),
+			PathStyle: viper.GetBool("storage.s3.path_style"),
+		}
+	}
+
 	if err := processCerts(config); err != nil {
 		slog.Default().Error(err.Error())
 	}
 
 	app := NewApp(config)
 
+	if *exportName != "" || *importFile != "" {
+		var err error
+
+		if *exportName != "" {
+			err = app.exportMissionCmd(*exportName, *scope, *out)
+		} else {
+			err = app.importMissionCmd(*importFile, *scope, missions.ImportOptions{Name: *name, Conflict: *conflict})
+		}
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	app.lat = viper.GetFloat64("me.lat")
 	app.lon = viper.GetFloat64("me.lon")
 	app.zoom = int8(viper.GetInt("me.zoom"))
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -8,10 +9,13 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"slices"
 	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
 	"github.com/google/uuid"
 	"github.com/kdudkov/goasae/cmd/goasae_server/mp"
 	"github.com/kdudkov/goasae/internal/pm"
@@ -99,12 +103,18 @@ func addMartiRoutes(app *App, f fiber.Router) {
 
 	f.Get("/Marti/sync/content", getContentGetHandler(app))
 	f.Post("/Marti/sync/upload", getUploadHandler(app))
+	f.Post("/Marti/sync/upload/resumable", getResumableCreateHandler(app))
+	f.Head("/Marti/sync/upload/resumable/:id", getResumableHeadHandler(app))
+	f.Patch("/Marti/sync/upload/resumable/:id", getResumablePatchHandler(app))
+	f.Delete("/Marti/sync/upload/resumable/:id", getResumableDeleteHandler(app))
 
 	f.Get("/Marti/vcm", getVideoListHandler(app))
 	f.Post("/Marti/vcm", getVideoPostHandler(app))
 
 	f.Get("/Marti/api/video", getVideo2ListHandler(app))
 
+	f.Get("/Marti/api/chat/history", getChatHistoryHandler(app))
+
 	if app.config.dataSync {
 		addMissionApi(app, f)
 	}
@@ -235,7 +245,7 @@ func getMissionUploadHandler(app *App) fiber.Handler {
 		pi, err := app.uploadMultipart(ctx, "", hash, fname, true)
 		if err != nil {
 			app.logger.Error("error", slog.Any("error", err))
-			return ctx.SendStatus(fiber.StatusNotAcceptable)
+			return ctx.SendStatus(uploadErrorStatus(err))
 		}
 
 		app.logger.Info(fmt.Sprintf("save packege %s %s %s", pi.Name, pi.UID, pi.Hash))
@@ -260,7 +270,7 @@ func getUploadHandler(app *App) fiber.Handler {
 			pi, err := app.uploadMultipart(ctx, uid, "", fname, false)
 			if err != nil {
 				app.logger.Error("error", slog.Any("error", err))
-				return ctx.SendStatus(fiber.StatusNotAcceptable)
+				return ctx.SendStatus(uploadErrorStatus(err))
 			}
 
 			return ctx.SendString(fmt.Sprintf("/Marti/sync/content?hash=%s", pi.Hash))
@@ -269,7 +279,7 @@ func getUploadHandler(app *App) fiber.Handler {
 			pi, err := app.uploadFile(ctx, uid, fname)
 			if err != nil {
 				app.logger.Error("error", slog.Any("error", err))
-				return ctx.SendStatus(fiber.StatusNotAcceptable)
+				return ctx.SendStatus(uploadErrorStatus(err))
 			}
 
 			return ctx.SendString(fmt.Sprintf("/Marti/sync/content?hash=%s", pi.Hash))
@@ -308,6 +318,14 @@ func (app *App) uploadMultipart(ctx *fiber.Ctx, uid, hash, filename string, pack
 		pi.Tool = "public"
 	}
 
+	if err := app.config.quota.Check(app.packageManager, pi, fh.Size); err != nil {
+		return nil, err
+	}
+
+	if err := app.scanUpload(pi, func() (io.ReadCloser, error) { return fh.Open() }); err != nil {
+		return nil, err
+	}
+
 	f, err := fh.Open()
 
 	if err != nil {
@@ -323,30 +341,98 @@ func (app *App) uploadMultipart(ctx *fiber.Ctx, uid, hash, filename string, pack
 	return pi, nil
 }
 
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, pm.ErrQuotaExceeded):
+		return fiber.StatusRequestEntityTooLarge
+	case errors.Is(err, pm.ErrInfected):
+		return fiber.StatusUnprocessableEntity
+	case errors.Is(err, pm.ErrScanFailed):
+		return fiber.StatusServiceUnavailable
+	default:
+		return fiber.StatusNotAcceptable
+	}
+}
+
 func (app *App) uploadFile(ctx *fiber.Ctx, uid, filename string) (*pm.PackageInfo, error) {
 	username := Username(ctx)
 	user := app.users.GetUser(username)
 
+	// request strings are reused by fasthttp after the handler returns, package info keeps copies
 	pi := &pm.PackageInfo{
-		UID:                uid,
+		UID:                utils.CopyString(uid),
 		SubmissionDateTime: time.Now(),
 		Keywords:           nil,
-		MIMEType:           ctx.Get(fiber.HeaderContentType),
+		MIMEType:           utils.CopyString(ctx.Get(fiber.HeaderContentType)),
 		Size:               0,
 		SubmissionUser:     user.GetLogin(),
 		PrimaryKey:         0,
 		Hash:               "",
-		CreatorUID:         getStringParamIgnoreCaps(ctx, "creatorUid"),
+		CreatorUID:         utils.CopyString(getStringParamIgnoreCaps(ctx, "creatorUid")),
 		Scope:              user.GetScope(),
-		Name:               filename,
+		Name:               utils.CopyString(filename),
 		Tool:               "",
 	}
 
-	if err1 := app.packageManager.SaveFile(pi, ctx.Request().BodyStream()); err1 != nil {
+	size := int64(ctx.Request().Header.ContentLength())
+
+	var body io.Reader = ctx.Request().BodyStream()
+	var spooled *os.File
+
+	// body is not streamed when it is read by server at once
+	if body == nil {
+		body = bytes.NewReader(ctx.Body())
+	}
+
+	// scanner reads the file before it is stored, so body is spooled to temp file first
+	if app.scanner != nil {
+		f, n, err := spool(body)
+		if err != nil {
+			return nil, err
+		}
+
+		defer func() {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}()
+
+		spooled, size, body = f, n, f
+	}
+
+	if size > 0 {
+		if err := app.config.quota.Check(app.packageManager, pi, size); err != nil {
+			return nil, err
+		}
+	}
+
+	if spooled != nil {
+		if err := app.scanUpload(pi, func() (io.ReadCloser, error) { return os.Open(spooled.Name()) }); err != nil {
+			return nil, err
+		}
+	}
+
+	old := app.packageManager.Get(uid)
+
+	if err1 := app.packageManager.SaveFile(pi, body); err1 != nil {
 		app.logger.Error("save file error", slog.Any("error", err1))
 		return nil, err1
 	}
 
+	if size > 0 {
+		return pi, nil
+	}
+
+	// size of streamed body without content length is known only after save
+	if err := app.config.quota.Check(app.packageManager, pi, int64(pi.Size)); err != nil {
+		if old != nil {
+			app.packageManager.Store(old)
+		} else {
+			app.packageManager.Delete(pi.UID)
+		}
+
+		return nil, err
+	}
+
 	return pi, nil
 }
 
@@ -369,17 +455,23 @@ func getContentGetHandler(app *App) fiber.Handler {
 				return err
 			}
 
-			defer f.Close()
-
-			ctx.Set("ETag", hash)
+			size, err := app.packageManager.GetFileSize(hash)
+			if err != nil {
+				_ = f.Close()
+				app.logger.Error("get file error", slog.Any("error", err))
 
-			if size, err := app.packageManager.GetFileSize(hash); err == nil {
-				ctx.Set(fiber.HeaderContentLength, strconv.Itoa(int(size)))
+				return err
 			}
 
-			_, err = io.Copy(ctx.Response().BodyWriter(), f)
+			c := &content{hash: hash, size: size}
 
-			return err
+			if pi := app.packageManager.GetFirst(func(pi *pm.PackageInfo) bool {
+				return pi.Hash == hash && user.CanSeeScope(pi.Scope)
+			}); pi != nil {
+				c.name, c.mimeType, c.modified = pi.Name, pi.MIMEType, pi.SubmissionDateTime
+			}
+
+			return app.serveDownload(ctx, f, c)
 		}
 
 		if uid := ctx.Query("uid"); uid != "" {
@@ -391,16 +483,19 @@ func getContentGetHandler(app *App) fiber.Handler {
 					return err
 				}
 
-				defer f.Close()
-
-				ctx.Set(fiber.HeaderContentType, pi.MIMEType)
-				ctx.Set(fiber.HeaderLastModified, pi.SubmissionDateTime.UTC().Format(http.TimeFormat))
-				ctx.Set(fiber.HeaderContentLength, strconv.Itoa(pi.Size))
-				ctx.Set(fiber.HeaderETag, pi.Hash)
-
-				_, err = io.Copy(ctx.Response().BodyWriter(), f)
+				// package size can be stale if the file was stored before
+				size, err := app.packageManager.GetFileSize(pi.Hash)
+				if err != nil {
+					size = int64(pi.Size)
+				}
 
-				return err
+				return app.serveDownload(ctx, f, &content{
+					hash:     pi.Hash,
+					size:     size,
+					name:     pi.Name,
+					mimeType: pi.MIMEType,
+					modified: pi.SubmissionDateTime,
+				})
 			}
 
 			app.logger.Info("not found - uid " + uid)
@@ -471,19 +566,30 @@ func getMetadataPutHandler(app *App) fiber.Handler {
 
 func getSearchHandler(app *App) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
-		kw := ctx.Query("keywords")
-		tool := ctx.Query("tool")
+		q, err := getPackageQuery(ctx)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
 
 		user := app.users.GetUser(Username(ctx))
+		q.Scopes = append([]string{user.GetScope()}, user.GetReadScope()...)
 
-		result := make(map[string]any)
+		if slices.Contains(q.Scopes, "*") {
+			q.Scopes = nil
+		}
 
-		packages := app.packageManager.GetList(func(pi *pm.PackageInfo) bool {
-			return user.CanSeeScope(pi.Scope) && pi.HasKeyword(kw) && (tool == "" || pi.Tool == tool)
-		})
+		packages, total, err := app.packageIndex.Search(q)
+		if err != nil {
+			app.logger.Error("search error", slog.Any("error", err))
+
+			return ctx.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		result := make(map[string]any)
 
 		result["results"] = packages
 		result["resultCount"] = len(packages)
+		result["totalCount"] = total
 
 		return ctx.JSON(result)
 	}
@@ -549,18 +655,42 @@ func getProfileConnectionHandler(app *App) fiber.Handler {
 	}
 }
 
+func getChatHistoryHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		q, err := getChatQuery(ctx)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		user := app.users.GetUser(Username(ctx))
+		q.Scopes = append([]string{user.GetScope()}, user.GetReadScope()...)
+		// mission chat history is available via mission api
+		q.ExcludeMissions = true
+		// direct messages are available only to their sender and recipient
+		q.ReaderUIDs = app.userUIDs(Username(ctx))
+
+		if slices.Contains(q.Scopes, "*") {
+			q.Scopes = nil
+		}
+
+		return ctx.JSON(makeAnswer("ChatMessage", chatsToModel(app.chats.GetHistory(q))))
+	}
+}
+
 func getVideoListHandler(app *App) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		r := new(model.VideoConnections)
-		user := app.users.GetUser(Username(ctx))
 
-		app.feeds.ForEach(func(f *model.Feed2) bool {
-			if user.CanSeeScope(f.Scope) {
-				r.Feeds = append(r.Feeds, f.ToFeed())
+		username := Username(ctx)
+
+		for _, f := range app.visibleFeeds(app.users.GetUser(username)) {
+			feed := app.clientFeed(ctx.Hostname(), username, f).ToFeed()
+			if app.relayed(f) {
+				feed.RtspReliable = "1"
 			}
 
-			return true
-		})
+			r.Feeds = append(r.Feeds, feed)
+		}
 
 		return ctx.XML(r)
 	}
@@ -569,15 +699,12 @@ func getVideoListHandler(app *App) fiber.Handler {
 func getVideo2ListHandler(app *App) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		conn := make([]*model.VideoConnections2, 0)
-		user := app.users.GetUser(Username(ctx))
 
-		app.feeds.ForEach(func(f *model.Feed2) bool {
-			if user.CanSeeScope(f.Scope) {
-				conn = append(conn, &model.VideoConnections2{Feeds: []*model.Feed2{f}})
-			}
+		username := Username(ctx)
 
-			return true
-		})
+		for _, f := range app.visibleFeeds(app.users.GetUser(username)) {
+			conn = append(conn, &model.VideoConnections2{Feeds: []*model.Feed2{app.clientFeed(ctx.Hostname(), username, f)}})
+		}
 
 		r := make(map[string]any)
 		r["videoConnections"] = conn
@@ -597,8 +724,38 @@ func getVideoPostHandler(app *App) fiber.Handler {
 			return err
 		}
 
+		feeds := make([]*model.Feed2, 0, len(r.Feeds))
+
 		for _, f := range r.Feeds {
-			app.feeds.Store(f.ToFeed2().WithUser(username).WithScope(user.Scope))
+			f2 := f.ToFeed2().WithUser(username).WithScope(user.GetScope())
+
+			if f2.UID == "" {
+				f2.UID = uuid.NewString()
+			}
+
+			if err := f2.Validate(); err != nil {
+				return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+			}
+
+			if old := app.feeds.Get(f2.UID); old != nil {
+				if !canEditFeed(user, old) {
+					return ctx.Status(fiber.StatusForbidden).SendString("feed " + f2.UID + " belongs to other user")
+				}
+
+				f2.Roles = old.Roles
+				f2.Relay = old.Relay
+
+				// client sends back relay url it got from us
+				if app.relayed(old) && f2.URL == app.relay.URL(ctx.Hostname(), username, old.UID) {
+					f2.URL = old.URL
+				}
+			}
+
+			feeds = append(feeds, f2)
+		}
+
+		for _, f := range feeds {
+			app.feeds.Store(f)
 		}
 
 		return nil
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/cmd/goasae_server/chats"
+	im "github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+type fakeClient struct {
+	name string
+	uid  string
+	user *im.User
+
+	mx   sync.Mutex
+	sent []*cot.CotMessage
+}
+
+func (c *fakeClient) GetIdentifier() string         { return c.name }
+func (c *fakeClient) GetName() string               { return c.name }
+func (c *fakeClient) HasUID(uid string) bool        { return c.uid == uid }
+func (c *fakeClient) GetUids() map[string]string    { return map[string]string{c.uid: c.name} }
+func (c *fakeClient) GetUser() *im.User             { return c.user }
+func (c *fakeClient) GetSerial() string             { return "" }
+func (c *fakeClient) GetVersion() int32             { return 1 }
+func (c *fakeClient) GetLastSeen() *time.Time       { return nil }
+func (c *fakeClient) CanSeeScope(scope string) bool { return c.user.CanSeeScope(scope) }
+func (c *fakeClient) Start()                        {}
+func (c *fakeClient) Stop()                         {}
+func (c *fakeClient) CanSend() bool                 { return true }
+func (c *fakeClient) CanReceive() bool              { return true }
+func (c *fakeClient) SendMsg(msg *cot.CotMessage) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.sent = append(c.sent, msg)
+
+	return nil
+}
+
+func (c *fakeClient) Sent() []*cot.CotMessage {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	return c.sent
+}
+
+type fakeUsers map[string]*im.User
+
+func (u fakeUsers) Start() error                     { return nil }
+func (u fakeUsers) Stop()                            {}
+func (u fakeUsers) CheckUserAuth(_, _ string) bool   { return true }
+func (u fakeUsers) UserIsValid(_, _ string) bool     { return true }
+func (u fakeUsers) GetUser(username string) *im.User { return u[username] }
+
+func TestChatHistoryAccess(t *testing.T) {
+	db := prepare()
+
+	users := fakeUsers{
+		"a": {Login: "a", Scope: "s1"},
+		"b": {Login: "b", Scope: "s1"},
+	}
+
+	app := &App{logger: slog.Default(), users: users, chats: chats.New(db)}
+	app.AddClientHandler(&fakeClient{name: "client_a", uid: "uid_a", user: users["a"]})
+	app.AddClientHandler(&fakeClient{name: "client_b", uid: "uid_b", user: users["b"]})
+
+	now := time.Now()
+	require.NoError(t, app.chats.Add(&im.ChatMessage{MessageID: "1", Scope: "s1", Time: now, Chatroom: "All Chat Rooms", FromUID: "uid_b"}))
+	require.NoError(t, app.chats.Add(&im.ChatMessage{MessageID: "2", Scope: "s1", Time: now, Chatroom: "C", FromUID: "uid_b", ToUID: "uid_c", Direct: true}))
+
+	f := fiber.New()
+	f.Get("/", func(ctx *fiber.Ctx) error {
+		ctx.Locals(UsernameKey, ctx.Get("X-User"))
+
+		return ctx.Next()
+	}, getChatHistoryHandler(app))
+
+	history := func(user string) []string {
+		req := httptest.NewRequest(http.MethodGet, "/?uid=uid_c", nil)
+		req.Header.Set("X-User", user)
+
+		res, err := f.Test(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		b, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+
+		var ans struct {
+			Data []*model.ChatMessage `json:"data"`
+		}
+
+		require.NoError(t, json.Unmarshal(b, &ans))
+
+		ids := make([]string, 0)
+		for _, c := range ans.Data {
+			ids = append(ids, c.ID)
+		}
+
+		return ids
+	}
+
+	assert.Empty(t, history("a"))
+	assert.Equal(t, []string{"2"}, history("b"))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+
+	im "github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+// missionAoiProcessor adds map items entering bbox of auto include missions, updates items inside
+// and removes auto included items on exit
+func (app *App) missionAoiProcessor(msg *cot.CotMessage) bool {
+	if app.missions == nil || !msg.IsMapItem() || len(msg.GetDetail().GetDestMission()) > 0 {
+		return true
+	}
+
+	lat, lon := msg.GetLat(), msg.GetLon()
+
+	for _, m := range app.missions.GetAutoIncludeMissions(msg.Scope) {
+		var change *im.Change
+
+		switch {
+		case m.GetBBox().Contains(lat, lon):
+			if app.missions.UpdatePoint(m.ID, msg) {
+				continue
+			}
+
+			app.logger.Info(fmt.Sprintf("%s %s entered mission %s bbox", msg.GetUID(), msg.GetCallsign(), m.Name))
+			change = app.missions.AutoIncludePoint(m.ID, msg)
+		case app.missions.IsAutoIncluded(m.ID, msg.GetUID()):
+			app.logger.Info(fmt.Sprintf("%s %s left mission %s bbox", msg.GetUID(), msg.GetCallsign(), m.Name))
+			change = app.missions.ExcludePoint(m.ID, msg.GetUID())
+		}
+
+		if change != nil {
+			app.notifyMissionSubscribers(m, change)
+		}
+	}
+
+	return true
+}
+
+// getMissionAoiHandler returns map items that are in mission bbox now
+func getMissionAoiHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		user := app.users.GetUser(Username(ctx))
+		mission := app.missions.GetMission(user.GetScope(), ctx.Params("missionname"))
+
+		if mission == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		if !app.hasMissionPermission(ctx, mission, im.PermRead) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to read mission!")
+		}
+
+		bbox := mission.GetBBox()
+		if bbox == nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString("mission has no bbox")
+		}
+
+		result := make([]*im.MissionItemDTO, 0)
+
+		app.items.ForEach(func(item *model.Item) bool {
+			if item.GetScope() != mission.Scope {
+				return true
+			}
+
+			if lat, lon := item.GetLanLon(); bbox.Contains(lat, lon) {
+				d := &im.DataItem{UID: item.GetUID()}
+				d.UpdateFromMsg(item.GetMsg())
+				result = append(result, im.NewItemDTO(d))
+			}
+
+			return true
+		})
+
+		return ctx.JSON(makeAnswer(missionItemType, result))
+	}
+}
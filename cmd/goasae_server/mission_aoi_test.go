@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/cmd/goasae_server/missions"
+	im "github.com/kdudkov/goasae/internal/model"
+)
+
+func TestParseBBox(t *testing.T) {
+	b, err := im.ParseBBox("60, 31, 59,30")
+	require.NoError(t, err)
+	assert.Equal(t, "59,30,60,31", b.String())
+	assert.True(t, b.Contains(59.5, 30.5))
+	assert.False(t, b.Contains(58.5, 30.5))
+
+	_, err = im.ParseBBox("1,2,3")
+	require.Error(t, err)
+
+	_, err = im.ParseBBox("100,2,3,4")
+	require.Error(t, err)
+}
+
+func TestMissionAoi(t *testing.T) {
+	db := prepare()
+	app := &App{logger: slog.Default(), missions: missions.New(db)}
+
+	m := &im.Mission{Name: "mission1", Scope: "s1", Bbox: "59,30,60,31", AutoInclude: true}
+	require.NoError(t, app.missions.PutMission(m))
+	require.NoError(t, app.missions.PutMission(&im.Mission{Name: "mission2", Scope: "s1", Bbox: "59,30,60,31"}))
+
+	assert.True(t, app.missionAoiProcessor(newCotMessage("s1", "uid1", 58, 30.5)))
+	assert.False(t, app.missions.HasPoint(m.ID, "uid1"))
+
+	app.missionAoiProcessor(newCotMessage("s1", "uid1", 59.5, 30.5))
+	app.missionAoiProcessor(newCotMessage("s2", "uid2", 59.5, 30.5))
+	assert.True(t, app.missions.HasPoint(m.ID, "uid1"))
+	assert.False(t, app.missions.HasPoint(m.ID, "uid2"))
+
+	// position of the item inside is updated
+	app.missionAoiProcessor(newCotMessage("s1", "uid1", 59.6, 30.5))
+	assert.Len(t, app.missions.GetChanges(m.ID, time.Time{}), 2)
+	assert.InDelta(t, 59.6, app.missions.GetMissionById(m.ID).Items[0].Lat, 0.001)
+
+	// manually added item is updated but is not removed on exit
+	app.missions.AddPoint(app.missions.GetMissionById(m.ID), newCotMessage("s1", "uid3", 10, 10))
+	app.missionAoiProcessor(newCotMessage("s1", "uid3", 59.5, 30.5))
+	app.missionAoiProcessor(newCotMessage("s1", "uid3", 61, 30.5))
+	assert.True(t, app.missions.HasPoint(m.ID, "uid3"))
+
+	// state of items inside survives restart
+	app.missions = missions.New(db)
+	assert.True(t, app.missions.IsAutoIncluded(m.ID, "uid1"))
+
+	app.missionAoiProcessor(newCotMessage("s1", "uid1", 61, 30.5))
+	assert.False(t, app.missions.HasPoint(m.ID, "uid1"))
+
+	changes := app.missions.GetChanges(m.ID, time.Time{})
+	require.Len(t, changes, 4)
+	assert.Equal(t, "REMOVE_CONTENT", changes[0].Type)
+
+	// mission changes are seen by the processor
+	m.AutoInclude = false
+	app.missions.Save(m)
+	assert.Empty(t, app.missions.GetAutoIncludeMissions("s1"))
+}
+
+func TestUpdatePoint(t *testing.T) {
+	db := prepare()
+	m := missions.New(db)
+
+	m1 := &im.Mission{Name: "mission1", Scope: "s1"}
+	require.NoError(t, m.PutMission(m1))
+
+	assert.False(t, m.UpdatePoint(m1.ID, newCotMessage("s1", "uid1", 10, 20)))
+
+	m.AddPoint(m1, newCotMessage("s1", "uid1", 10, 20))
+	assert.True(t, m.UpdatePoint(m1.ID, newCotMessage("s1", "uid1", 10, 20)))
+
+	lat := func() float64 {
+		var d im.DataItem
+		require.NoError(t, db.Where("mission_id = ? AND uid = ?", m1.ID, "uid1").Take(&d).Error)
+
+		return d.Lat
+	}
+
+	// unchanged item is not saved
+	require.NoError(t, db.Model(&im.DataItem{}).Where("uid = ?", "uid1").Update("lat", 1).Error)
+	assert.True(t, m.UpdatePoint(m1.ID, newCotMessage("s1", "uid1", 10, 20)))
+	assert.InDelta(t, 1, lat(), 0.001)
+
+	assert.True(t, m.UpdatePoint(m1.ID, newCotMessage("s1", "uid1", 11, 20)))
+	assert.InDelta(t, 11, lat(), 0.001)
+
+	require.NotNil(t, m.DeleteMissionPoint(m1.ID, "uid1", ""))
+	assert.False(t, m.UpdatePoint(m1.ID, newCotMessage("s1", "uid1", 12, 20)))
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
@@ -11,6 +12,7 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 
+	"github.com/kdudkov/goasae/cmd/goasae_server/missions"
 	"github.com/kdudkov/goasae/internal/model"
 	"github.com/kdudkov/goasae/pkg/cot"
 )
@@ -22,6 +24,8 @@ const (
 	missionRoleType         = "MissionRole"
 	logEntryType            = "com.bbn.marti.sync.model.LogEntry"
 	missionChangeType       = "MissionChange"
+	missionExternalDataType = "com.bbn.marti.sync.model.ExternalMissionData"
+	missionItemType         = "MissionItem"
 )
 
 func addMissionApi(app *App, f fiber.Router) {
@@ -31,6 +35,11 @@ func addMissionApi(app *App, f fiber.Router) {
 
 	g.Get("/all/invitations", getMissionsInvitationsHandler(app))
 
+	g.Post("/logs/entries", getLogEntryPutHandler(app, false))
+	g.Put("/logs/entries", getLogEntryPutHandler(app, true))
+	g.Get("/logs/entries/:id", getLogEntryHandler(app))
+	g.Delete("/logs/entries/:id", getLogEntryDeleteHandler(app))
+
 	g.Get("/:missionname", getMissionHandler(app))
 	g.Put("/:missionname", getMissionPutHandler(app))
 	g.Delete("/:missionname", getMissionDeleteHandler(app))
@@ -49,6 +58,14 @@ func addMissionApi(app *App, f fiber.Router) {
 	g.Get("/:missionname/subscriptions", getMissionSubscriptionsHandler(app))
 	g.Get("/:missionname/subscriptions/roles", getMissionSubscriptionRolesHandler(app))
 	g.Put("/:missionname/invite/:type/:uid", getInvitePutHandler(app))
+	g.Post("/:missionname/externaldata", getExternalDataPostHandler(app))
+	g.Delete("/:missionname/externaldata/:id", getExternalDataDeleteHandler(app))
+	g.Get("/:missionname/aoi", getMissionAoiHandler(app))
+	g.Get("/:missionname/chat", getMissionChatHandler(app))
+	g.Get("/:missionname/children", getMissionChildrenHandler(app))
+	g.Get("/:missionname/parent", getMissionParentHandler(app))
+	g.Put("/:missionname/parent/:parentname", getMissionParentPutHandler(app))
+	g.Delete("/:missionname/parent", getMissionParentDeleteHandler(app))
 	g.Delete("/:missionname/invite/:type/:uid", getInviteDeleteHandler(app))
 }
 
@@ -60,7 +77,7 @@ func getMissionsHandler(app *App) fiber.Handler {
 		result := make([]*model.MissionDTO, len(data))
 
 		for i, m := range data {
-			result[i] = model.ToMissionDTO(m, app.packageManager, false)
+			result[i] = model.ToMissionDTO(m, app.packageManager, "")
 		}
 
 		return ctx.JSON(makeAnswer(missionType, result))
@@ -76,7 +93,7 @@ func getMissionHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
-		return ctx.JSON(makeAnswer(missionType, []*model.MissionDTO{model.ToMissionDTO(m, app.packageManager, false)}))
+		return ctx.JSON(makeAnswer(missionType, []*model.MissionDTO{model.ToMissionDTO(m, app.packageManager, "")}))
 	}
 }
 
@@ -100,11 +117,11 @@ func getMissionPutHandler(app *App) fiber.Handler {
 			LastEdit:       time.Now(),
 			BaseLayer:      ctx.Query("baseLayer"),
 			Bbox:           ctx.Query("bbox"),
+			AutoInclude:    ctx.QueryBool("autoInclude", false),
 			ChatRoom:       ctx.Query("chatRoom"),
 			Classification: ctx.Query("classification"),
 			Description:    ctx.Query("description"),
 			InviteOnly:     ctx.QueryBool("inviteOnly", false),
-			Password:       ctx.Query("password"),
 			Path:           ctx.Query("path"),
 			Tool:           ctx.Query("tool"),
 			Groups:         "",
@@ -112,17 +129,42 @@ func getMissionPutHandler(app *App) fiber.Handler {
 			Token:          uuid.NewString(),
 		}
 
+		if m.Bbox != "" {
+			if _, err := model.ParseBBox(m.Bbox); err != nil {
+				return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+			}
+		}
+
+		if err := m.SetPassword(ctx.Query("password")); err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+
 		if err := app.missions.PutMission(m); err != nil {
 			app.logger.Warn("mission add error", slog.Any("error", err))
 			return ctx.Status(fiber.StatusConflict).SendString(err.Error())
 		}
 
+		if m.CreatorUID != "" {
+			app.missions.PutSubscription(&model.Subscription{
+				MissionID:  m.ID,
+				ClientUID:  m.CreatorUID,
+				Username:   username,
+				CreateTime: time.Now(),
+				Role:       model.RoleOwner,
+			})
+		}
+
 		if !m.InviteOnly {
 			app.NewCotMessage(model.MissionCreateNotificationMsg(m))
 		}
 
+		token := ""
+		if m.CreatorUID != "" {
+			token = missions.MakeToken(m, m.CreatorUID)
+		}
+
 		return ctx.Status(fiber.StatusCreated).
-			JSON(makeAnswer(missionType, []*model.MissionDTO{model.ToMissionDTO(m, app.packageManager, true)}))
+			JSON(makeAnswer(missionType, []*model.MissionDTO{model.ToMissionDTO(m, app.packageManager, token)}))
 	}
 }
 
@@ -135,9 +177,13 @@ func getMissionDeleteHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
+		if !app.hasMissionPermission(ctx, m, model.PermDelete) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to delete mission!")
+		}
+
 		app.missions.DeleteMission(m.ID)
 
-		return ctx.JSON(makeAnswer(missionType, []*model.MissionDTO{model.ToMissionDTO(m, app.packageManager, false)}))
+		return ctx.JSON(makeAnswer(missionType, []*model.MissionDTO{model.ToMissionDTO(m, app.packageManager, "")}))
 	}
 }
 
@@ -158,7 +204,12 @@ func getMissionRoleHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
-		return ctx.JSON(makeAnswer(missionRoleType, model.GetRole("")))
+		role := app.missionRole(ctx, m)
+		if role == "" {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		return ctx.JSON(makeAnswer(missionRoleType, model.GetRole(role)))
 	}
 }
 
@@ -171,13 +222,34 @@ func getMissionRolePutHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
-		return ctx.JSON(makeAnswer(missionRoleType, model.GetRole("")))
+		if !app.hasMissionPermission(ctx, m, model.PermSetRole) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to set mission role!")
+		}
+
+		role := ctx.Query("role")
+
+		if role == "" && len(ctx.Body()) > 0 {
+			r := new(model.MissionRoleDTO)
+			if err := json.Unmarshal(ctx.Body(), r); err != nil {
+				return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+			}
+
+			role = r.Type
+		}
+
+		if !model.IsValidRole(role) {
+			return ctx.Status(fiber.StatusBadRequest).SendString("invalid role " + role)
+		}
+
+		if !app.missions.SetRole(m.ID, ctx.Query("clientUid"), role) {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		return ctx.JSON(makeAnswer(missionRoleType, model.GetRole(role)))
 	}
 }
 
 func getMissionLogHandler(app *App) fiber.Handler {
-	result := makeAnswer(logEntryType, []*model.MissionLogEntryDTO{})
-
 	return func(ctx *fiber.Ctx) error {
 		user := app.users.GetUser(Username(ctx))
 		m := app.missions.GetMission(user.GetScope(), ctx.Params("missionname"))
@@ -186,7 +258,144 @@ func getMissionLogHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
-		return ctx.JSON(result)
+		var start, end time.Time
+
+		if secago := ctx.QueryInt("secago", 0); secago > 0 {
+			start = time.Now().Add(-time.Second * time.Duration(secago))
+		}
+
+		for name, t := range map[string]*time.Time{"start": &start, "end": &end} {
+			if s := ctx.Query(name); s != "" {
+				tm, err := time.Parse(time.RFC3339, s)
+				if err != nil {
+					return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+				}
+
+				*t = tm
+			}
+		}
+
+		return ctx.JSON(makeAnswer(logEntryType, model.ToLogEntriesDTO(app.missions.GetLogEntries(m.ID, start, end))))
+	}
+}
+
+// getLogEntryPutHandler creates (POST) or updates (PUT) mission log entry
+func getLogEntryPutHandler(app *App, update bool) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		username := Username(ctx)
+		user := app.users.GetUser(username)
+
+		dto := new(model.MissionLogEntryDTO)
+
+		if err := json.Unmarshal(ctx.Body(), dto); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		if len(dto.MissionNames) == 0 {
+			return ctx.Status(fiber.StatusBadRequest).SendString("no mission names")
+		}
+
+		e := &model.LogEntry{
+			ID:      uuid.NewString(),
+			Scope:   user.GetScope(),
+			Created: time.Now(),
+		}
+
+		var old *model.LogEntry
+
+		if update {
+			if old = app.missions.GetLogEntry(dto.ID); old == nil || old.Scope != user.GetScope() {
+				return ctx.SendStatus(fiber.StatusNotFound)
+			}
+
+			e.ID = old.ID
+			e.Created = old.Created
+		}
+
+		e.Dtg = dto.Dtg
+		if e.Dtg.IsZero() {
+			e.Dtg = e.Created
+		}
+
+		e.CreatorUID = dto.CreatorUID
+		e.EntryUID = dto.EntryUID
+		e.Content = dto.Content
+		e.Keywords = strings.Join(dto.Keywords, ",")
+		e.ContentHashes = strings.Join(dto.ContentHashes, ",")
+
+		for _, name := range dto.MissionNames {
+			m := app.missions.GetMission(user.GetScope(), name)
+			if m == nil {
+				return ctx.Status(fiber.StatusNotFound).SendString("no such mission " + name)
+			}
+
+			e.Missions = append(e.Missions, m)
+		}
+
+		toNotify := e.Missions
+		if old != nil {
+			toNotify = append(toNotify, old.Missions...)
+		}
+
+		for _, m := range toNotify {
+			if !app.hasMissionPermission(ctx, m, model.PermWrite) {
+				return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to modify mission!")
+			}
+		}
+
+		if err := app.missions.PutLogEntry(e); err != nil {
+			app.logger.Error("log entry save error", slog.Any("error", err))
+
+			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+
+		typ := model.ChangeAddLog
+		if update {
+			typ = model.ChangeUpdateLog
+		}
+
+		app.notifyLogEntryChange(toNotify, e, typ)
+
+		if !update {
+			ctx.Status(fiber.StatusCreated)
+		}
+
+		return ctx.JSON(makeAnswer(logEntryType, []*model.MissionLogEntryDTO{model.ToLogEntryDTO(e)}))
+	}
+}
+
+func getLogEntryHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		user := app.users.GetUser(Username(ctx))
+		e := app.missions.GetLogEntry(ctx.Params("id"))
+
+		if e == nil || e.Scope != user.GetScope() {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		return ctx.JSON(makeAnswer(logEntryType, []*model.MissionLogEntryDTO{model.ToLogEntryDTO(e)}))
+	}
+}
+
+func getLogEntryDeleteHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		user := app.users.GetUser(Username(ctx))
+		e := app.missions.GetLogEntry(ctx.Params("id"))
+
+		if e == nil || e.Scope != user.GetScope() {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		for _, m := range e.Missions {
+			if !app.hasMissionPermission(ctx, m, model.PermWrite) {
+				return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to modify mission!")
+			}
+		}
+
+		app.missions.DeleteLogEntry(e.ID)
+		app.notifyLogEntryChange(e.Missions, e, model.ChangeDeleteLog)
+
+		return nil
 	}
 }
 
@@ -199,6 +408,10 @@ func getMissionKeywordsPutHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
+		if !app.hasMissionPermission(ctx, m, model.PermWrite) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to modify mission!")
+		}
+
 		var kw []string
 
 		if err := json.Unmarshal(ctx.Body(), &kw); err != nil {
@@ -238,7 +451,13 @@ func getMissionSubscriptionHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
-		return ctx.JSON(makeAnswer(missionSubscriptionType, model.ToMissionSubscriptionDTO(s, m.Token)))
+		// token is given to the subscriber only
+		token := ""
+		if s.Username == Username(ctx) {
+			token = missions.MakeToken(m, s.ClientUID)
+		}
+
+		return ctx.JSON(makeAnswer(missionSubscriptionType, model.ToMissionSubscriptionDTO(s, token)))
 	}
 }
 
@@ -251,25 +470,53 @@ func getMissionSubscriptionPutHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
-		if m.InviteOnly {
-			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to subscribe to invite only mission!")
+		username := Username(ctx)
+		uid := ctx.Query("uid")
+		role := model.RoleSubscriber
+
+		old := app.missions.GetSubscription(m.ID, uid)
+		if old != nil && old.Username != "" && old.Username != username {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to subscribe with uid of other user!")
 		}
 
-		if m.Password != "" && ctx.Query("password") != m.Password {
-			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to subscribe to mission! Password did not match.")
+		// invitation is for the device, so the device must be connected as this user
+		var inv *model.Invitation
+		if app.isClientOf(uid, username) {
+			inv = app.missions.GetInvitation(m.ID, uid, "clientUid")
+		}
+
+		switch {
+		case old != nil:
+			role = old.Role
+		case inv != nil:
+			if model.IsValidRole(inv.Role) {
+				role = inv.Role
+			}
+		default:
+			if m.InviteOnly {
+				return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to subscribe to invite only mission!")
+			}
+
+			if !m.CheckPassword(ctx.Query("password")) {
+				return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to subscribe to mission! Password did not match.")
+			}
 		}
 
 		s := &model.Subscription{
 			MissionID:  m.ID,
-			ClientUID:  ctx.Query("uid"),
-			Username:   Username(ctx),
+			ClientUID:  uid,
+			Username:   username,
 			CreateTime: time.Now(),
-			Role:       "MISSION_SUBSCRIBER",
+			Role:       role,
 		}
 
 		app.missions.PutSubscription(s)
 
-		return ctx.Status(fiber.StatusCreated).JSON(makeAnswer(missionSubscriptionType, model.ToMissionSubscriptionDTO(s, m.Token)))
+		if old == nil {
+			go app.sendMissionChatHistory(m, uid)
+		}
+
+		return ctx.Status(fiber.StatusCreated).JSON(makeAnswer(missionSubscriptionType, model.ToMissionSubscriptionDTO(s, missions.MakeToken(m, s.ClientUID))))
 	}
 }
 
@@ -282,7 +529,19 @@ func getMissionSubscriptionDeleteHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
-		app.missions.DeleteSubscription(ctx.Params("missionname"), ctx.Query("uid"))
+		uid := ctx.Query("uid")
+
+		s := app.missions.GetSubscription(m.ID, uid)
+		if s == nil {
+			return nil
+		}
+
+		if s.Username != "" && s.Username != Username(ctx) && !app.hasMissionPermission(ctx, m, model.PermSetRole) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to unsubscribe other user!")
+		}
+
+		// tokens of the removed subscription are rejected by CheckToken
+		app.missions.DeleteSubscription(m.ID, uid)
 
 		return nil
 	}
@@ -307,13 +566,21 @@ func getMissionChangesHandler(app *App) fiber.Handler {
 	return func(ctx *fiber.Ctx) error {
 		user := app.users.GetUser(Username(ctx))
 		mission := app.missions.GetMission(user.GetScope(), ctx.Params("missionname"))
-		d1 := time.Now().Add(-time.Second * time.Duration(ctx.QueryInt("secago", 31536000)))
 
 		if mission == nil {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
-		ch := app.missions.GetChanges(mission.ID, d1)
+		if !app.checkMissionToken(ctx, mission) {
+			return ctx.Status(fiber.StatusUnauthorized).SendString("invalid mission token")
+		}
+
+		q, err := getChangeQuery(ctx)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		ch := app.missions.QueryChanges(mission.ID, q)
 
 		result := make([]*model.MissionChangeDTO, len(ch))
 
@@ -334,6 +601,10 @@ func getMissionCotHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
+		if !app.checkMissionToken(ctx, mission) {
+			return ctx.Status(fiber.StatusUnauthorized).SendString("invalid mission token")
+		}
+
 		ctx.Set(fiber.HeaderContentType, "application/xml")
 
 		fb := new(strings.Builder)
@@ -375,6 +646,14 @@ func getMissionContentPutHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
+		if !app.hasMissionPermission(ctx, mission, model.PermWrite) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to modify mission!")
+		}
+
+		if !app.checkMissionToken(ctx, mission) {
+			return ctx.Status(fiber.StatusUnauthorized).SendString("invalid mission token")
+		}
+
 		var data map[string][]string
 
 		if err := json.Unmarshal(ctx.Body(), &data); err != nil {
@@ -394,7 +673,7 @@ func getMissionContentPutHandler(app *App) fiber.Handler {
 			ctx.Status(fiber.StatusCreated)
 		}
 
-		return ctx.JSON(makeAnswer(missionType, []*model.MissionDTO{model.ToMissionDTO(mission, app.packageManager, false)}))
+		return ctx.JSON(makeAnswer(missionType, []*model.MissionDTO{model.ToMissionDTO(mission, app.packageManager, "")}))
 	}
 }
 
@@ -407,6 +686,14 @@ func getMissionContentDeleteHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
+		if !app.hasMissionPermission(ctx, mission, model.PermWrite) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to modify mission!")
+		}
+
+		if !app.checkMissionToken(ctx, mission) {
+			return ctx.Status(fiber.StatusUnauthorized).SendString("invalid mission token")
+		}
+
 		author := ctx.Query("creatorUid")
 
 		if uid := ctx.Query("uid"); uid != "" {
@@ -421,7 +708,7 @@ func getMissionContentDeleteHandler(app *App) fiber.Handler {
 
 		m1 := app.missions.GetMissionById(mission.ID)
 
-		return ctx.JSON(makeAnswer(missionType, []*model.MissionDTO{model.ToMissionDTO(m1, app.packageManager, false)}))
+		return ctx.JSON(makeAnswer(missionType, []*model.MissionDTO{model.ToMissionDTO(m1, app.packageManager, "")}))
 	}
 }
 
@@ -434,6 +721,15 @@ func getInvitePutHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
+		if !app.hasMissionPermission(ctx, mission, model.PermWrite) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to modify mission!")
+		}
+
+		if role := ctx.Query("role"); role != "" && role != model.RoleSubscriber && role != model.RoleReadOnly &&
+			!app.hasMissionPermission(ctx, mission, model.PermSetRole) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to invite with role " + role)
+		}
+
 		// type can be: clientUid, callsign, userName, group, team
 		typ := ctx.Params("type")
 
@@ -466,8 +762,248 @@ func getInviteDeleteHandler(app *App) fiber.Handler {
 			return ctx.SendStatus(fiber.StatusNotFound)
 		}
 
+		if !app.hasMissionPermission(ctx, mission, model.PermWrite) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to modify mission!")
+		}
+
 		app.missions.DeleteInvitation(mission.ID, ctx.Params("uid"), ctx.Params("type"))
 
 		return nil
 	}
 }
+
+func getExternalDataPostHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		user := app.users.GetUser(Username(ctx))
+		mission := app.missions.GetMission(user.GetScope(), ctx.Params("missionname"))
+
+		if mission == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		if !app.hasMissionPermission(ctx, mission, model.PermWrite) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to modify mission!")
+		}
+
+		if !app.checkMissionToken(ctx, mission) {
+			return ctx.Status(fiber.StatusUnauthorized).SendString("invalid mission token")
+		}
+
+		var dto model.ExternalDataDTO
+
+		if err := json.Unmarshal(ctx.Body(), &dto); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		if dto.Name == "" || dto.URLData == "" {
+			return ctx.Status(fiber.StatusBadRequest).SendString("name and urlData are required")
+		}
+
+		d := &model.ExternalData{
+			ID:      dto.ID,
+			Name:    dto.Name,
+			Tool:    dto.Tool,
+			URLData: dto.URLData,
+			URLView: dto.URLView,
+			Notes:   dto.Notes,
+		}
+
+		change, err := app.missions.PutExternalData(mission, d, ctx.Query("creatorUid"))
+		if err != nil {
+			if errors.Is(err, missions.ErrExternalDataExists) {
+				return ctx.Status(fiber.StatusConflict).SendString(err.Error())
+			}
+
+			app.logger.Error("external data save error", slog.Any("error", err))
+
+			return ctx.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		app.notifyMissionSubscribers(mission, change)
+
+		return ctx.Status(fiber.StatusCreated).JSON(makeAnswer(missionExternalDataType, []*model.ExternalDataDTO{model.ToExternalDataDTO(d)}))
+	}
+}
+
+func getExternalDataDeleteHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		user := app.users.GetUser(Username(ctx))
+		mission := app.missions.GetMission(user.GetScope(), ctx.Params("missionname"))
+
+		if mission == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		if !app.hasMissionPermission(ctx, mission, model.PermWrite) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to modify mission!")
+		}
+
+		if !app.checkMissionToken(ctx, mission) {
+			return ctx.Status(fiber.StatusUnauthorized).SendString("invalid mission token")
+		}
+
+		change := app.missions.DeleteExternalData(mission, ctx.Params("id"), ctx.Query("creatorUid"))
+		if change == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		app.notifyMissionSubscribers(mission, change)
+
+		return nil
+	}
+}
+
+func getMissionChatHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		user := app.users.GetUser(Username(ctx))
+		mission := app.missions.GetMission(user.GetScope(), ctx.Params("missionname"))
+
+		if mission == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		if !app.hasMissionPermission(ctx, mission, model.PermRead) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to read mission chat!")
+		}
+
+		q, err := getChatQuery(ctx)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		q.MissionID = mission.ID
+
+		return ctx.JSON(makeAnswer("ChatMessage", chatsToModel(app.chats.GetHistory(q))))
+	}
+}
+
+func getMissionChildrenHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		user := app.users.GetUser(Username(ctx))
+		mission := app.missions.GetMission(user.GetScope(), ctx.Params("missionname"))
+
+		if mission == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		children := app.missions.GetChildren(mission)
+		result := make([]*model.MissionDTO, len(children))
+
+		for i, m := range children {
+			result[i] = model.ToMissionDTO(m, app.packageManager, "")
+		}
+
+		return ctx.JSON(makeAnswer(missionType, result))
+	}
+}
+
+func getMissionParentHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		user := app.users.GetUser(Username(ctx))
+		mission := app.missions.GetMission(user.GetScope(), ctx.Params("missionname"))
+
+		if mission == nil || mission.ParentID == 0 {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		parent := app.missions.GetMissionById(mission.ParentID)
+		if parent == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		return ctx.JSON(makeAnswer(missionType, []*model.MissionDTO{model.ToMissionDTO(parent, app.packageManager, "")}))
+	}
+}
+
+func getMissionParentPutHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		user := app.users.GetUser(Username(ctx))
+		mission := app.missions.GetMission(user.GetScope(), ctx.Params("missionname"))
+		parent := app.missions.GetMission(user.GetScope(), ctx.Params("parentname"))
+
+		if mission == nil || parent == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		if !app.hasMissionPermission(ctx, mission, model.PermWrite) || !app.hasMissionPermission(ctx, parent, model.PermWrite) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to modify mission!")
+		}
+
+		if err := app.missions.SetParent(mission, parent); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		return ctx.JSON(makeAnswer(missionType, []*model.MissionDTO{model.ToMissionDTO(mission, app.packageManager, "")}))
+	}
+}
+
+func getMissionParentDeleteHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		user := app.users.GetUser(Username(ctx))
+		mission := app.missions.GetMission(user.GetScope(), ctx.Params("missionname"))
+
+		if mission == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		if !app.hasMissionPermission(ctx, mission, model.PermWrite) {
+			return ctx.Status(fiber.StatusForbidden).SendString("Illegal attempt to modify mission!")
+		}
+
+		if err := app.missions.SetParent(mission, nil); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		return ctx.JSON(makeAnswer(missionType, []*model.MissionDTO{model.ToMissionDTO(mission, app.packageManager, "")}))
+	}
+}
+
+// missionRole returns role of the client in the mission. Client is identified by mission token if it is given,
+// otherwise client uid is taken from clientUid or creatorUid params and must be subscribed as the same user
+func (app *App) missionRole(ctx *fiber.Ctx, m *model.Mission) string {
+	if uid, err := missions.ParseToken(m, missionToken(ctx)); err == nil {
+		if role := app.missions.GetRole(m, uid, ""); role != "" {
+			return role
+		}
+	}
+
+	uid := ctx.Query("clientUid")
+	if uid == "" {
+		uid = ctx.Query("creatorUid")
+	}
+
+	return app.missions.GetRole(m, uid, Username(ctx))
+}
+
+func (app *App) hasMissionPermission(ctx *fiber.Ctx, m *model.Mission, perm string) bool {
+	return model.HasPermission(app.missionRole(ctx, m), perm)
+}
+
+func (app *App) notifyLogEntryChange(ms []*model.Mission, e *model.LogEntry, typ string) {
+	seen := make(map[uint]bool)
+
+	for _, m := range ms {
+		if seen[m.ID] {
+			continue
+		}
+
+		seen[m.ID] = true
+
+		app.notifyMissionSubscribers(m, app.missions.AddLogEntryChange(m, e, typ))
+	}
+}
+
+// missionToken returns mission token from MissionAuthorization or Authorization header or token param
+func missionToken(ctx *fiber.Ctx) string {
+	for _, h := range []string{"MissionAuthorization", fiber.HeaderAuthorization} {
+		if s, ok := strings.CutPrefix(ctx.Get(h), "Bearer "); ok {
+			return s
+		}
+	}
+
+	return ctx.Query("token")
+}
+
+func (app *App) checkMissionToken(ctx *fiber.Ctx, m *model.Mission) bool {
+	return app.missions.CheckToken(m, missionToken(ctx))
+}
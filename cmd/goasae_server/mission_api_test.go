@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/cmd/goasae_server/missions"
+	im "github.com/kdudkov/goasae/internal/model"
+)
+
+func TestMissionRolePut(t *testing.T) {
+	users := fakeUsers{
+		"owner": {Login: "owner", Scope: "s1"},
+		"b":     {Login: "b", Scope: "s1"},
+	}
+
+	app := &App{logger: slog.Default(), users: users, missions: missions.New(prepare())}
+
+	m := &im.Mission{Name: "mission1", Scope: "s1", Username: "owner"}
+	require.NoError(t, app.missions.PutMission(m))
+
+	app.missions.PutSubscription(&im.Subscription{MissionID: m.ID, ClientUID: "uid_b", Username: "b", Role: im.RoleSubscriber})
+
+	f := fiber.New()
+	f.Put("/:missionname/role", func(ctx *fiber.Ctx) error {
+		ctx.Locals(UsernameKey, ctx.Get("X-User"))
+
+		return ctx.Next()
+	}, getMissionRolePutHandler(app))
+
+	put := func(user string) int {
+		req := httptest.NewRequest(http.MethodPut, "/mission1/role?clientUid=uid_b&role="+im.RoleReadOnly, nil)
+		req.Header.Set("X-User", user)
+
+		res, err := f.Test(req)
+		require.NoError(t, err)
+
+		return res.StatusCode
+	}
+
+	// subscriber can't change roles
+	assert.Equal(t, http.StatusForbidden, put("b"))
+	assert.Equal(t, im.RoleSubscriber, app.missions.GetRole(m, "uid_b", "b"))
+
+	assert.Equal(t, http.StatusOK, put("owner"))
+	assert.Equal(t, im.RoleReadOnly, app.missions.GetRole(m, "uid_b", "b"))
+}
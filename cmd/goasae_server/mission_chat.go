@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/kdudkov/goasae/cmd/goasae_server/chats"
+	"github.com/kdudkov/goasae/internal/client"
+	im "github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+// number of last mission chat messages sent to new subscriber
+const missionChatHistorySize = 50
+
+// missionForChat returns mission that has chat room of the message
+func (app *App) missionForChat(scope string, c *model.ChatMessage) *im.Mission {
+	if app.missions == nil || c == nil || c.Direct {
+		return nil
+	}
+
+	return app.missions.GetMissionByChatRoom(scope, c.Chatroom)
+}
+
+// canChat checks if the message source is subscribed to the mission or has write permission in it
+func (app *App) canChat(m *im.Mission, msg *cot.CotMessage) bool {
+	uids := app.missions.GetSubscribers(m.ID)
+	subscribed := false
+
+	app.ForAllClients(func(ch client.ClientHandler) bool {
+		if ch.GetName() != msg.From {
+			return true
+		}
+
+		for uid := range ch.GetUids() {
+			if slices.Contains(uids, uid) {
+				subscribed = true
+			}
+		}
+
+		return false
+	})
+
+	return subscribed || app.canWriteMission(m, msg)
+}
+
+// missionChatProcessor delivers messages in mission chat room to mission subscribers only
+func (app *App) missionChatProcessor(msg *cot.CotMessage) bool {
+	m := app.missionForChat(msg.Scope, model.MsgToChat(msg))
+	if m == nil {
+		return true
+	}
+
+	if !app.canChat(m, msg) {
+		app.logger.Warn(fmt.Sprintf("%s is not subscribed to mission %s, chat message is dropped", msg.From, m.Name))
+
+		return false
+	}
+
+	uids := app.missions.GetSubscribers(m.ID)
+
+	app.ForAllClients(func(ch client.ClientHandler) bool {
+		if ch.GetName() == msg.From || !ch.CanSend() {
+			return true
+		}
+
+		for uid := range ch.GetUids() {
+			if slices.Contains(uids, uid) {
+				if err := ch.SendMsg(msg); err != nil {
+					app.logger.Error("send error", slog.Any("error", err))
+				}
+
+				break
+			}
+		}
+
+		return true
+	})
+
+	for _, uid := range uids {
+		app.queueIfOffline(uid, msg)
+	}
+
+	return false
+}
+
+// sendMissionChatHistory sends last messages of mission chat room to subscriber
+func (app *App) sendMissionChatHistory(m *im.Mission, uid string) {
+	if m.ChatRoom == "" {
+		return
+	}
+
+	var handler client.ClientHandler
+
+	app.ForAllClients(func(ch client.ClientHandler) bool {
+		if ch.HasUID(uid) {
+			handler = ch
+
+			return false
+		}
+
+		return true
+	})
+
+	if handler == nil {
+		return
+	}
+
+	msgs := app.chats.GetHistory(&chats.Query{MissionID: m.ID, Limit: missionChatHistorySize})
+
+	app.logger.Debug(fmt.Sprintf("sending %d chat messages of mission %s to %s", len(msgs), m.Name, uid))
+
+	// history is newest first
+	for i := len(msgs) - 1; i >= 0; i-- {
+		msg, err := cot.CotFromProto(model.MakeChatMessage(msgs[i].ToModel()), "", m.Scope)
+		if err != nil {
+			continue
+		}
+
+		if err := handler.SendMsg(msg); err != nil {
+			app.logger.Error("error sending chat history", slog.Any("error", err))
+
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/cmd/goasae_server/chats"
+	"github.com/kdudkov/goasae/cmd/goasae_server/missions"
+	im "github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+func newChatMessage(t *testing.T, id, room, from string) *cot.CotMessage {
+	c := &model.ChatMessage{ID: id, Chatroom: room, ToUID: room, FromUID: "uid1", From: "Alpha", Text: "text " + id}
+
+	msg, err := cot.CotFromProto(model.MakeChatMessage(c), from, "s1")
+	require.NoError(t, err)
+
+	return msg
+}
+
+func TestMissionChat(t *testing.T) {
+	db := prepare()
+
+	app := &App{logger: slog.Default(), missions: missions.New(db), chats: chats.New(db)}
+
+	m := &im.Mission{Name: "mission1", Scope: "s1", ChatRoom: "mission room"}
+	require.NoError(t, app.missions.PutMission(m))
+
+	user := &im.User{Login: "user1", Scope: "s1"}
+	sub1 := &fakeClient{name: "client1", uid: "uid1", user: user}
+	sub2 := &fakeClient{name: "client2", uid: "uid2", user: user}
+	other := &fakeClient{name: "client3", uid: "uid3", user: &im.User{Login: "user2", Scope: "s1"}}
+
+	for _, c := range []*fakeClient{sub1, sub2, other} {
+		app.AddClientHandler(c)
+	}
+
+	app.missions.PutSubscription(&im.Subscription{MissionID: m.ID, ClientUID: "uid1", Username: "user1", Role: im.RoleSubscriber})
+	app.missions.PutSubscription(&im.Subscription{MissionID: m.ID, ClientUID: "uid2", Username: "user1", Role: im.RoleSubscriber})
+
+	msg1 := newChatMessage(t, "1", "mission room", "client1")
+	msg2 := newChatMessage(t, "2", "All Chat Rooms", "client1")
+	msg3 := newChatMessage(t, "3", "mission room", "client3")
+
+	for _, msg := range []*cot.CotMessage{msg1, msg2, msg3} {
+		assert.True(t, app.chatProcessor(msg))
+	}
+
+	assert.False(t, app.missionChatProcessor(msg1))
+	assert.True(t, app.missionChatProcessor(msg2))
+	// not subscribed contact can't write to mission chat
+	assert.False(t, app.missionChatProcessor(msg3))
+
+	require.Len(t, sub2.Sent(), 1)
+	assert.Equal(t, msg1, sub2.Sent()[0])
+	assert.Empty(t, sub1.Sent())
+	assert.Empty(t, other.Sent())
+
+	res := app.chats.GetHistory(&chats.Query{MissionID: m.ID})
+	require.Len(t, res, 1)
+	assert.Equal(t, "1", res[0].MessageID)
+
+	res = app.chats.GetHistory(&chats.Query{ExcludeMissions: true})
+	require.Len(t, res, 1)
+	assert.Equal(t, "2", res[0].MessageID)
+}
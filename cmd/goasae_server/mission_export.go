@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/kdudkov/goasae/cmd/goasae_server/missions"
+)
+
+// exportMissionCmd writes mission package to file, used from command line
+func (app *App) exportMissionCmd(name, scope, out string) error {
+	if app.missions == nil {
+		return fmt.Errorf("datasync is disabled")
+	}
+
+	if err := app.packageManager.Start(); err != nil {
+		return err
+	}
+
+	m := app.missions.GetMission(scope, name)
+	if m == nil {
+		return fmt.Errorf("mission %s not found in scope %q", name, scope)
+	}
+
+	if out == "" {
+		out = name + ".zip"
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+
+	err = app.missions.Export(m, app.packageManager, f)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+
+	if err != nil {
+		_ = os.Remove(out)
+
+		return err
+	}
+
+	app.audit.Info("mission_export", slog.String("name", m.Name), slog.String("scope", m.Scope), slog.String("by", "cli"))
+	fmt.Printf("mission %s exported to %s\n", name, out)
+
+	return nil
+}
+
+// importMissionCmd creates mission from package file, used from command line
+func (app *App) importMissionCmd(fn, scope string, opts missions.ImportOptions) error {
+	if app.missions == nil {
+		return fmt.Errorf("datasync is disabled")
+	}
+
+	if err := app.packageManager.Start(); err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+
+	m, err := app.missions.Import(b, scope, opts, app.packageManager)
+	if err != nil {
+		return err
+	}
+
+	app.audit.Info("mission_import", slog.String("name", m.Name), slog.String("scope", m.Scope), slog.String("by", "cli"))
+	fmt.Printf("mission %s imported to scope %q with %d items\n", m.Name, m.Scope, len(m.Items))
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	im "github.com/kdudkov/goasae/internal/model"
+)
+
+// autoSnapshots makes snapshot of every mission edited since its last snapshot, not more often than snapshotInterval
+func (app *App) autoSnapshots() {
+	if app.missions == nil || app.config.snapshotInterval <= 0 {
+		return
+	}
+
+	for _, m := range app.missions.GetAllMissionsAdm() {
+		last := app.missions.LastSnapshotTime(m.ID)
+
+		if !m.LastEdit.After(last) || time.Since(last) < app.config.snapshotInterval {
+			continue
+		}
+
+		if _, err := app.missions.CreateSnapshot(m, "auto", "", true); err != nil {
+			app.logger.Error("snapshot error", slog.String("mission", m.Name), slog.Any("error", err))
+
+			continue
+		}
+
+		if n := app.missions.PruneSnapshots(m.ID, app.config.snapshotKeep); n > 0 {
+			app.logger.Debug(fmt.Sprintf("%d old snapshots of mission %s removed", n, m.Name))
+		}
+	}
+}
+
+// restoreMissionSnapshot restores snapshot and notifies mission subscribers
+func (app *App) restoreMissionSnapshot(m *im.Mission, s *im.MissionSnapshot, author string) ([]*im.Change, error) {
+	changes, err := app.missions.RestoreSnapshot(m, s, author)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range changes {
+		app.notifyMissionSubscribers(m, c)
+	}
+
+	return changes, nil
+}
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/glebarez/sqlite"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 
 	"github.com/kdudkov/goasae/cmd/goasae_server/missions"
+	"github.com/kdudkov/goasae/internal/database"
 	"github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/internal/pm"
 	"github.com/kdudkov/goasae/pkg/cot"
 )
 
@@ -19,7 +23,6 @@ func TestMissionSubscriptions(t *testing.T) {
 	db := prepare()
 
 	m := missions.New(db)
-	require.NoError(t, m.Migrate())
 
 	m1 := &model.Mission{Name: "mission1", Scope: "s1"}
 	m2 := &model.Mission{Name: "mission2", Scope: "s1"}
@@ -49,7 +52,6 @@ func TestMissionCRUD(t *testing.T) {
 	db := prepare()
 
 	m := missions.New(db)
-	require.NoError(t, m.Migrate())
 
 	m1 := &model.Mission{Name: "mission1", Scope: "scope1"}
 	m2 := &model.Mission{Name: "mission2", Scope: "scope1"}
@@ -88,7 +90,6 @@ func TestAddPoint(t *testing.T) {
 	db := prepare()
 
 	m := missions.New(db)
-	require.NoError(t, m.Migrate())
 
 	m1 := &model.Mission{Name: "mission1", Scope: "scope1"}
 	m2 := &model.Mission{Name: "mission2", Scope: "scope1"}
@@ -111,6 +112,340 @@ func TestAddPoint(t *testing.T) {
 	assert.Len(t, m.GetMission("scope1", m2.Name).Items, 1)
 }
 
+func TestMissionRoles(t *testing.T) {
+	db := prepare()
+
+	m := missions.New(db)
+
+	m1 := &model.Mission{Name: "mission1", Scope: "scope1", Username: "owner", CreatorUID: "uid0"}
+	require.NoError(t, m.PutMission(m1))
+
+	m.PutSubscription(&model.Subscription{MissionID: m1.ID, ClientUID: "uid1", Username: "user1", Role: model.RoleSubscriber})
+	m.PutSubscription(&model.Subscription{MissionID: m1.ID, ClientUID: "uid2", Username: "user2", Role: model.RoleReadOnly})
+
+	assert.Equal(t, "", m.GetRole(m1, "uid0", ""))
+	assert.Equal(t, "", m.GetRole(m1, "uid0", "user3"))
+	assert.Equal(t, model.RoleOwner, m.GetRole(m1, "", "owner"))
+	assert.Equal(t, model.RoleSubscriber, m.GetRole(m1, "uid1", "user1"))
+	assert.Equal(t, model.RoleSubscriber, m.GetRole(m1, "", "user1"))
+	assert.Equal(t, model.RoleReadOnly, m.GetRole(m1, "uid2", ""))
+	assert.Equal(t, "", m.GetRole(m1, "uid3", "user3"))
+
+	// other user's subscription
+	assert.Equal(t, model.RoleReadOnly, m.GetRole(m1, "uid1", "user2"))
+
+	assert.True(t, model.HasPermission(m.GetRole(m1, "uid1", ""), model.PermWrite))
+	assert.False(t, model.HasPermission(m.GetRole(m1, "uid1", ""), model.PermDelete))
+	assert.False(t, model.HasPermission(m.GetRole(m1, "uid2", ""), model.PermWrite))
+	assert.False(t, model.HasPermission(m.GetRole(m1, "uid3", ""), model.PermRead))
+
+	assert.True(t, m.SetRole(m1.ID, "uid2", model.RoleOwner))
+	assert.False(t, m.SetRole(m1.ID, "uid3", model.RoleOwner))
+	assert.True(t, model.HasPermission(m.GetRole(m1, "uid2", ""), model.PermDelete))
+
+	m.DeleteSubscription(m1.ID, "uid2")
+	assert.Len(t, m.GetSubscriptions(m1.ID), 1)
+}
+
+func TestLogEntries(t *testing.T) {
+	db := prepare()
+
+	m := missions.New(db)
+
+	m1 := &model.Mission{Name: "mission1", Scope: "scope1"}
+	m2 := &model.Mission{Name: "mission2", Scope: "scope1"}
+
+	require.NoError(t, m.PutMission(m1))
+	require.NoError(t, m.PutMission(m2))
+
+	now := time.Now()
+
+	e1 := &model.LogEntry{ID: "e1", Scope: "scope1", Dtg: now.Add(-time.Hour), Content: "c1", Missions: []*model.Mission{m1, m2}}
+	e2 := &model.LogEntry{ID: "e2", Scope: "scope1", Dtg: now, Content: "c2", Missions: []*model.Mission{m1}}
+
+	require.NoError(t, m.PutLogEntry(e1))
+	require.NoError(t, m.PutLogEntry(e2))
+
+	assert.Len(t, m.GetLogEntries(m1.ID, time.Time{}, time.Time{}), 2)
+	assert.Len(t, m.GetLogEntries(m2.ID, time.Time{}, time.Time{}), 1)
+	assert.Len(t, m.GetLogEntries(m1.ID, now.Add(-time.Minute), time.Time{}), 1)
+
+	e1.Content = "c11"
+	e1.Missions = []*model.Mission{m2}
+	require.NoError(t, m.PutLogEntry(e1))
+
+	e := m.GetLogEntry("e1")
+	require.NotNil(t, e)
+	assert.Equal(t, "c11", e.Content)
+	require.Len(t, e.Missions, 1)
+	assert.Equal(t, "mission2", e.Missions[0].Name)
+	assert.Len(t, m.GetLogEntries(m1.ID, time.Time{}, time.Time{}), 1)
+
+	c := m.AddLogEntryChange(m2, e1, model.ChangeUpdateLog)
+	require.NotNil(t, c)
+
+	changes := m.QueryChanges(m2.ID, &missions.ChangeQuery{})
+	require.NotEmpty(t, changes)
+	assert.Equal(t, model.ChangeUpdateLog, changes[0].Type)
+	assert.Equal(t, "e1", changes[0].ContentUID)
+
+	m.DeleteLogEntry("e1")
+	assert.Nil(t, m.GetLogEntry("e1"))
+	assert.Empty(t, m.GetLogEntries(m2.ID, time.Time{}, time.Time{}))
+
+	e3 := &model.LogEntry{ID: "e3", Scope: "scope1", Dtg: now, Content: "c3", Missions: []*model.Mission{m1, m2}}
+	require.NoError(t, m.PutLogEntry(e3))
+
+	m.DeleteMission(m1.ID)
+	assert.Nil(t, m.GetLogEntry("e2"))
+	assert.NotNil(t, m.GetLogEntry("e3"))
+	assert.Len(t, m.GetLogEntries(m2.ID, time.Time{}, time.Time{}), 1)
+}
+
+func TestMissionToken(t *testing.T) {
+	db := prepare()
+
+	m := missions.New(db)
+
+	m1 := &model.Mission{Name: "mission1", Scope: "scope1", Token: "key1"}
+	m2 := &model.Mission{Name: "mission2", Scope: "scope1", Token: "key1"}
+	require.NoError(t, m.PutMission(m1))
+	require.NoError(t, m.PutMission(m2))
+
+	m.PutSubscription(getSubscription(m1.ID, "uid1"))
+	m.PutSubscription(getSubscription(m1.ID, "uid2"))
+
+	t1 := missions.MakeToken(m1, "uid1")
+	t2 := missions.MakeToken(m1, "uid2")
+
+	uid, err := missions.ParseToken(m1, t1)
+	require.NoError(t, err)
+	assert.Equal(t, "uid1", uid)
+
+	assert.True(t, m.CheckToken(m1, t1))
+	assert.False(t, m.CheckToken(m2, t1))
+	assert.False(t, m.CheckToken(m1, t1+"x"))
+	assert.False(t, m.CheckToken(m1, ""))
+
+	m.DeleteSubscription(m1.ID, "uid2")
+	assert.False(t, m.CheckToken(m1, t2))
+	assert.True(t, m.CheckToken(m1, t1))
+}
+
+func TestMissionPassword(t *testing.T) {
+	m := &model.Mission{Name: "mission1"}
+
+	assert.True(t, m.CheckPassword(""))
+
+	require.NoError(t, m.SetPassword("secret"))
+	assert.NotEqual(t, "secret", m.Password)
+	assert.True(t, m.CheckPassword("secret"))
+	assert.False(t, m.CheckPassword("other"))
+
+	// missions made before passwords were hashed
+	m.Password = "plain"
+	assert.False(t, m.CheckPassword("plain"))
+
+	changed, err := m.HashPassword()
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.NotEqual(t, "plain", m.Password)
+	assert.True(t, m.CheckPassword("plain"))
+
+	changed, err = m.HashPassword()
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestMissionChanges(t *testing.T) {
+	m := missions.New(prepare())
+
+	m1 := &model.Mission{Name: "mission1", Scope: "scope1"}
+	require.NoError(t, m.PutMission(m1))
+
+	m.AddPoint(m1, newCotMessage("scope1", "uid1", 10, 20))
+	c2 := m.AddPoint(m1, newCotMessage("scope1", "uid2", 10, 20))
+	require.NotNil(t, m.DeleteMissionPoint(m1.ID, "uid1", ""))
+	m1 = m.GetMissionById(m1.ID)
+	m.AddPoint(m1, newCotMessage("scope1", "uid1", 10, 20))
+
+	all := m.QueryChanges(m1.ID, &missions.ChangeQuery{})
+	require.Len(t, all, 5)
+	assert.Equal(t, "ADD_CONTENT", all[0].Type)
+	assert.Equal(t, "CREATE_MISSION", all[4].Type)
+
+	squashed := m.QueryChanges(m1.ID, &missions.ChangeQuery{Squashed: true})
+	require.Len(t, squashed, 3)
+	assert.Equal(t, all[0].ID, squashed[0].ID)
+
+	since := m.QueryChanges(m1.ID, &missions.ChangeQuery{Since: c2.ID})
+	require.Len(t, since, 2)
+	assert.Equal(t, "REMOVE_CONTENT", since[0].Type)
+	assert.Equal(t, "ADD_CONTENT", since[1].Type)
+
+	page := m.QueryChanges(m1.ID, &missions.ChangeQuery{Limit: 2, Offset: 1})
+	require.Len(t, page, 2)
+	assert.Equal(t, all[1].ID, page[0].ID)
+
+	assert.Equal(t, int64(2), m.CompactChanges(time.Now().Add(time.Minute)))
+	assert.Len(t, m.QueryChanges(m1.ID, &missions.ChangeQuery{}), 3)
+}
+
+func TestMissionHierarchy(t *testing.T) {
+	m := missions.New(prepare())
+
+	p := &model.Mission{Name: "parent", Scope: "scope1"}
+	c1 := &model.Mission{Name: "child1", Scope: "scope1"}
+	c2 := &model.Mission{Name: "child2", Scope: "scope1"}
+	other := &model.Mission{Name: "other", Scope: "scope2"}
+
+	for _, mi := range []*model.Mission{p, c1, c2, other} {
+		require.NoError(t, m.PutMission(mi))
+	}
+
+	require.NoError(t, m.SetParent(c1, p))
+	require.NoError(t, m.SetParent(c2, c1))
+	require.Error(t, m.SetParent(p, c2))
+	require.Error(t, m.SetParent(other, p))
+
+	pp := m.GetMission("scope1", "parent")
+	assert.Equal(t, []string{"child1"}, pp.Children)
+	assert.Empty(t, pp.ParentName)
+
+	cm := m.GetMission("scope1", "child1")
+	assert.Equal(t, "parent", cm.ParentName)
+	assert.Equal(t, []string{"child2"}, cm.Children)
+
+	parents := m.GetParents(m.GetMission("scope1", "child2"))
+	require.Len(t, parents, 2)
+	assert.Equal(t, "child1", parents[0].Name)
+	assert.Equal(t, "parent", parents[1].Name)
+
+	require.Len(t, m.GetChildren(pp), 1)
+
+	m.DeleteMission(c1.ID)
+	assert.Empty(t, m.GetMission("scope1", "child2").ParentName)
+	assert.Empty(t, m.GetMission("scope1", "parent").Children)
+}
+
+func TestExternalData(t *testing.T) {
+	m := missions.New(prepare())
+
+	m1 := &model.Mission{Name: "mission1", Scope: "scope1"}
+	require.NoError(t, m.PutMission(m1))
+
+	c, err := m.PutExternalData(m1, &model.ExternalData{Name: "feed", URLData: "http://example.com/data"}, "uid1")
+	require.NoError(t, err)
+	require.NotNil(t, c)
+	require.NotEmpty(t, c.ContentUID)
+
+	// other mission can't take the data by id
+	m2 := &model.Mission{Name: "mission2", Scope: "scope2"}
+	require.NoError(t, m.PutMission(m2))
+
+	_, err = m.PutExternalData(m2, &model.ExternalData{ID: c.ContentUID, Name: "stolen", URLData: "http://example.com/other"}, "uid2")
+	require.ErrorIs(t, err, missions.ErrExternalDataExists)
+
+	mi := m.GetMission("scope1", "mission1")
+	require.Len(t, mi.ExternalData, 1)
+	assert.Equal(t, "http://example.com/data", mi.ExternalData[0].URLData)
+
+	dto := model.ToMissionDTO(mi, nil, "")
+	require.Len(t, dto.ExternalData, 1)
+	assert.Equal(t, "feed", dto.ExternalData[0].Name)
+
+	assert.Nil(t, m.DeleteExternalData(mi, "bad_id", "uid1"))
+	require.NotNil(t, m.DeleteExternalData(mi, c.ContentUID, "uid1"))
+	assert.Empty(t, m.GetMission("scope1", "mission1").ExternalData)
+
+	changes := m.GetChanges(m1.ID, time.Time{})
+	require.Len(t, changes, 3)
+	assert.Equal(t, "REMOVE_CONTENT", changes[0].Type)
+	require.NotNil(t, changes[0].ExternalData)
+	assert.Equal(t, "feed", changes[0].ExternalData.Name)
+
+	ch := model.NewChangeDTO(changes[0], "mission1")
+	require.NotNil(t, ch.ExternalData)
+	assert.Nil(t, ch.Details)
+}
+
+func TestMissionExportImport(t *testing.T) {
+	m := missions.New(prepare())
+
+	files := pm.NewPackageManager(t.TempDir())
+	require.NoError(t, files.Start())
+
+	m1 := &model.Mission{Name: "mission1", Scope: "scope1", Keywords: "a,b", Description: "desc"}
+	require.NoError(t, m1.SetPassword("secret"))
+	require.NoError(t, m.PutMission(m1))
+
+	m.AddPoint(m1, newCotMessage("scope1", "uid1", 10, 20))
+	m.AddPoint(m1, newCotMessage("scope1", "uid2", 30, 40))
+
+	h := sha256.Sum256([]byte("file content"))
+	pi := &pm.PackageInfo{Name: "test.txt", Scope: "scope1", Hash: hex.EncodeToString(h[:])}
+	require.NoError(t, files.SaveFile(pi, strings.NewReader("file content")))
+	m1.AddHashes(pi.Hash)
+	m.Save(m1)
+
+	require.NoError(t, m.PutLogEntry(&model.LogEntry{ID: "e1", Scope: "scope1", Dtg: time.Now(), Content: "log", Missions: []*model.Mission{m1}}))
+
+	var buf bytes.Buffer
+
+	require.NoError(t, m.Export(m.GetMission("scope1", "mission1"), files, &buf))
+
+	data := buf.Bytes()
+	assert.NotContains(t, string(data), "secret")
+
+	// import to other server
+	m2 := missions.New(prepare())
+
+	files2 := pm.NewPackageManager(t.TempDir())
+	require.NoError(t, files2.Start())
+
+	res, err := m2.Import(data, "scope2", missions.ImportOptions{}, files2)
+	require.NoError(t, err)
+
+	mi := m2.GetMission("scope2", "mission1")
+	require.NotNil(t, mi)
+	assert.Equal(t, res.ID, mi.ID)
+	assert.Equal(t, "desc", mi.Description)
+	assert.Equal(t, "a,b", mi.Keywords)
+	assert.True(t, mi.CheckPassword("secret"))
+	assert.False(t, mi.CheckPassword("other"))
+	assert.Equal(t, []string{pi.Hash}, mi.GetHashes())
+	require.Len(t, mi.Items, 2)
+	assert.NotNil(t, m2.GetPoint("uid1"))
+	assert.Equal(t, 30., m2.GetPoint("uid2").GetEvent().GetLat())
+	assert.Len(t, m2.GetChanges(mi.ID, time.Time{}), 3)
+	assert.Len(t, m2.GetLogEntries(mi.ID, time.Time{}, time.Time{}), 1)
+
+	pi2 := files2.GetFirst(func(p *pm.PackageInfo) bool { return p.Hash == pi.Hash })
+	require.NotNil(t, pi2)
+	assert.Equal(t, "scope2", pi2.Scope)
+	assert.Equal(t, "test.txt", pi2.Name)
+
+	// mission name conflict
+	_, err = m2.Import(data, "scope2", missions.ImportOptions{}, files2)
+	require.ErrorIs(t, err, missions.ErrMissionExists)
+
+	// uid conflicts
+	_, err = m2.Import(data, "scope2", missions.ImportOptions{Name: "mission2"}, files2)
+	require.Error(t, err)
+
+	res, err = m2.Import(data, "scope2", missions.ImportOptions{Name: "mission2", Conflict: missions.ConflictSkip}, files2)
+	require.NoError(t, err)
+	assert.Empty(t, res.Items)
+	assert.Empty(t, m2.GetLogEntries(res.ID, time.Time{}, time.Time{}))
+
+	res, err = m2.Import(data, "scope2", missions.ImportOptions{Name: "mission3", Conflict: missions.ConflictNew}, files2)
+	require.NoError(t, err)
+	require.Len(t, res.Items, 2)
+	assert.NotEqual(t, "uid1", res.Items[0].UID)
+	assert.NotEqual(t, "uid2", res.Items[0].UID)
+	assert.Len(t, m2.GetLogEntries(res.ID, time.Time{}, time.Time{}), 1)
+}
+
 func TestHash(t *testing.T) {
 	m := &model.Mission{Name: "mission1", Scope: "scope1"}
 
@@ -125,7 +460,6 @@ func TestGetPoint(t *testing.T) {
 	db := prepare()
 
 	m := missions.New(db)
-	require.NoError(t, m.Migrate())
 
 	m1 := &model.Mission{Name: "mission1", Scope: "scope1"}
 	require.NoError(t, m.PutMission(m1))
@@ -140,11 +474,15 @@ func TestGetPoint(t *testing.T) {
 }
 
 func prepare() *gorm.DB {
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Info)})
+	db, err := database.Open(database.Config{DSN: ":memory:", MaxOpenConns: 1}, "")
 	if err != nil {
 		panic("failed to connect database")
 	}
 
+	if err := database.Migrate(db); err != nil {
+		panic(err)
+	}
+
 	return db
 }
 
@@ -0,0 +1,234 @@
+package missions
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/pkg/cot"
+)
+
+// unchanged mission item is saved once in this interval to keep its timestamp and event fresh
+const aoiRefreshInterval = time.Minute
+
+type aoiKey struct {
+	mission uint
+	uid     string
+}
+
+// aoiPoint is the last saved state of mission item updated by UpdatePoint
+type aoiPoint struct {
+	item  model.DataItem
+	saved time.Time
+}
+
+// changed checks if the message changes position or data of the item
+func (p *aoiPoint) changed(msg *cot.CotMessage) bool {
+	parent, _ := msg.GetParent()
+
+	return p.item.Lat != msg.GetLat() || p.item.Lon != msg.GetLon() ||
+		p.item.Type != msg.GetType() || p.item.Callsign != msg.GetCallsign() ||
+		p.item.IconsetPath != msg.GetIconsetPath() || p.item.Color != msg.GetColor() ||
+		p.item.CreatorUID != parent
+}
+
+// aoiCache keeps auto include missions by scope, items added to them by bbox and last saved mission items,
+// it is loaded from the database on first use after every mission change
+type aoiCache struct {
+	mx       sync.RWMutex
+	loaded   bool
+	missions map[string][]*model.Mission
+	items    map[aoiKey]bool
+	points   map[aoiKey]*aoiPoint
+}
+
+func (mm *MissionManager) invalidateAoi() {
+	if mm == nil {
+		return
+	}
+
+	mm.aoi.mx.Lock()
+	mm.aoi.loaded = false
+	mm.aoi.mx.Unlock()
+}
+
+func (mm *MissionManager) loadAoi() {
+	mm.aoi.mx.RLock()
+	loaded := mm.aoi.loaded
+	mm.aoi.mx.RUnlock()
+
+	if loaded {
+		return
+	}
+
+	mm.aoi.mx.Lock()
+	defer mm.aoi.mx.Unlock()
+
+	if mm.aoi.loaded {
+		return
+	}
+
+	var list []*model.Mission
+
+	mm.db.Where("auto_include = ? AND bbox <> ''", true).Find(&list)
+
+	mm.aoi.missions = make(map[string][]*model.Mission)
+	mm.aoi.items = make(map[aoiKey]bool)
+	mm.aoi.points = make(map[aoiKey]*aoiPoint)
+
+	ids := make([]uint, 0, len(list))
+
+	for _, m := range list {
+		mm.aoi.missions[m.Scope] = append(mm.aoi.missions[m.Scope], m)
+		ids = append(ids, m.ID)
+	}
+
+	if len(ids) > 0 {
+		var items []*model.DataItem
+
+		mm.db.Select("mission_id", "uid").Where("auto_included = ? AND mission_id IN ?", true, ids).Find(&items)
+
+		for _, d := range items {
+			mm.aoi.items[aoiKey{mission: d.MissionID, uid: d.UID}] = true
+		}
+	}
+
+	mm.aoi.loaded = true
+}
+
+func (mm *MissionManager) setAutoIncluded(missionId uint, uid string, auto bool) {
+	mm.aoi.mx.Lock()
+	defer mm.aoi.mx.Unlock()
+
+	if mm.aoi.items == nil {
+		return
+	}
+
+	if auto {
+		mm.aoi.items[aoiKey{mission: missionId, uid: uid}] = true
+	} else {
+		delete(mm.aoi.items, aoiKey{mission: missionId, uid: uid})
+	}
+}
+
+func (mm *MissionManager) forgetPoint(missionId uint, uid string) {
+	mm.aoi.mx.Lock()
+	defer mm.aoi.mx.Unlock()
+
+	delete(mm.aoi.points, aoiKey{mission: missionId, uid: uid})
+}
+
+// GetAutoIncludeMissions returns missions without items that include map items in their bbox automatically.
+// Missions are shared by callers and must not be changed.
+func (mm *MissionManager) GetAutoIncludeMissions(scope string) []*model.Mission {
+	if mm == nil || mm.db == nil {
+		return nil
+	}
+
+	mm.loadAoi()
+
+	mm.aoi.mx.RLock()
+	defer mm.aoi.mx.RUnlock()
+
+	return mm.aoi.missions[scope]
+}
+
+// IsAutoIncluded returns true if item was added to the mission by its bbox
+func (mm *MissionManager) IsAutoIncluded(missionId uint, uid string) bool {
+	if mm == nil || mm.db == nil {
+		return false
+	}
+
+	mm.loadAoi()
+
+	mm.aoi.mx.RLock()
+	defer mm.aoi.mx.RUnlock()
+
+	return mm.aoi.items[aoiKey{mission: missionId, uid: uid}]
+}
+
+// UpdatePoint updates position and data of the mission item, returns false if there is no such item in the mission.
+// Item is read from the database once, it is saved only when the message changes it or refresh interval is passed
+func (mm *MissionManager) UpdatePoint(missionId uint, msg *cot.CotMessage) bool {
+	if mm == nil || mm.db == nil {
+		return false
+	}
+
+	mm.loadAoi()
+
+	key := aoiKey{mission: missionId, uid: msg.GetUID()}
+
+	mm.aoi.mx.RLock()
+	p := mm.aoi.points[key]
+	mm.aoi.mx.RUnlock()
+
+	var d model.DataItem
+
+	if p != nil {
+		if !p.changed(msg) && time.Since(p.saved) < aoiRefreshInterval {
+			return true
+		}
+
+		d = p.item
+	} else if err := mm.db.Where("mission_id = ? AND uid = ?", missionId, key.uid).Take(&d).Error; err != nil {
+		return false
+	}
+
+	d.UpdateFromMsg(msg)
+
+	// selected update does not create item deleted in the meantime
+	res := mm.db.Select("*").Save(&d)
+
+	if res.Error != nil {
+		mm.logger.Error("mission item save error", slog.Any("error", res.Error))
+		mm.forgetPoint(missionId, key.uid)
+
+		return true
+	}
+
+	if res.RowsAffected == 0 {
+		mm.forgetPoint(missionId, key.uid)
+
+		return false
+	}
+
+	mm.aoi.mx.Lock()
+	if mm.aoi.points != nil {
+		mm.aoi.points[key] = &aoiPoint{item: d, saved: time.Now()}
+	}
+	mm.aoi.mx.Unlock()
+
+	return true
+}
+
+// AutoIncludePoint adds map item entered the mission bbox
+func (mm *MissionManager) AutoIncludePoint(missionId uint, msg *cot.CotMessage) *model.Change {
+	if mm == nil || mm.db == nil {
+		return nil
+	}
+
+	c := mm.addPoint(mm.GetMissionById(missionId), msg, true)
+
+	if c != nil {
+		mm.setAutoIncluded(missionId, msg.GetUID(), true)
+	}
+
+	return c
+}
+
+// ExcludePoint removes map item left the mission bbox if it was added by bbox
+func (mm *MissionManager) ExcludePoint(missionId uint, uid string) *model.Change {
+	if mm == nil || mm.db == nil {
+		return nil
+	}
+
+	var d *model.DataItem
+
+	if err := mm.db.Where("mission_id = ? AND uid = ? AND auto_included = ?", missionId, uid, true).Take(&d).Error; err != nil {
+		mm.setAutoIncluded(missionId, uid, false)
+		return nil
+	}
+
+	return mm.DeleteMissionPoint(missionId, uid, "")
+}
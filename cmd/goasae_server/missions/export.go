@@ -0,0 +1,432 @@
+package missions
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/kdudkov/goasae/cmd/goasae_server/mp"
+	"github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/internal/pm"
+	"github.com/kdudkov/goasae/pkg/cot"
+)
+
+const (
+	exportVersion = 1
+	exportFile    = "mission.json"
+	cotDir        = "cot/"
+	filesDir      = "files/"
+)
+
+// UID conflict policies for import
+const (
+	ConflictFail = "fail"
+	ConflictSkip = "skip"
+	ConflictNew  = "new"
+)
+
+var ErrMissionExists = errors.New("mission exists")
+
+type ImportOptions struct {
+	// Name overrides mission name from package
+	Name string
+	// Conflict is the policy for data items, files and log entries with uid that already exists on this server
+	Conflict string
+}
+
+type exportData struct {
+	Version  int               `json:"version"`
+	Exported time.Time         `json:"exported"`
+	Mission  *model.Mission    `json:"mission"`
+	Contents []*pm.PackageInfo `json:"contents"`
+	Logs     []*model.LogEntry `json:"logs"`
+	Changes  []*model.Change   `json:"changes"`
+}
+
+// Export writes mission package with mission metadata, data items, content files, log entries and change log to w.
+// Content files are streamed from storage
+func (mm *MissionManager) Export(m *model.Mission, files pm.PackageManager, w io.Writer) error {
+	if mm == nil || mm.db == nil {
+		return fmt.Errorf("no database")
+	}
+
+	if m == nil {
+		return fmt.Errorf("null mission")
+	}
+
+	pkg := mp.NewMissionPackage("MissionExport-"+uuid.NewString(), m.Name)
+	pkg.Param("mission_name", m.Name)
+	pkg.Param("mission_export", "true")
+	pkg.Param("onReceiveImport", "false")
+
+	meta := *m
+	meta.ID = 0
+	meta.Scope = ""
+	meta.Token = ""
+	meta.Items = nil
+
+	// bcrypt hash is exported to keep the mission protected on other server, plain text password never is
+	if _, err := meta.HashPassword(); err != nil {
+		return err
+	}
+
+	data := &exportData{
+		Version:  exportVersion,
+		Exported: time.Now().UTC(),
+		Mission:  &meta,
+		Contents: []*pm.PackageInfo{},
+		Logs:     []*model.LogEntry{},
+		Changes:  mm.GetChanges(m.ID, time.Time{}),
+	}
+
+	for _, item := range m.Items {
+		b, err := xml.Marshal(cot.CotToEvent(item.GetEvent()))
+		if err != nil {
+			return fmt.Errorf("item %s: %w", item.UID, err)
+		}
+
+		pkg.AddFile(mp.NewBytesFile(cotDir+item.UID+".cot", b))
+	}
+
+	for _, h := range m.GetHashes() {
+		pi := files.GetFirst(func(pi *pm.PackageInfo) bool {
+			return pi.Hash == h && pi.Scope == m.Scope
+		})
+
+		if pi == nil {
+			mm.logger.Warn(fmt.Sprintf("no file with hash %s for mission %s", h, m.Name))
+
+			continue
+		}
+
+		pkg.AddFile(mp.NewReaderFile(filesDir+h+"/"+path.Base(pi.Name), func() (io.ReadCloser, error) {
+			return files.GetFile(h)
+		}))
+		data.Contents = append(data.Contents, pi)
+	}
+
+	for _, e := range mm.GetLogEntries(m.ID, time.Time{}, time.Time{}) {
+		e.Missions = nil
+		data.Logs = append(data.Logs, e)
+	}
+
+	for _, c := range data.Changes {
+		c.ID = 0
+		c.MissionID = 0
+	}
+
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	pkg.AddFile(mp.NewBytesFile(exportFile, b))
+
+	return pkg.Write(w)
+}
+
+// Import creates new mission in scope from package made by Export
+func (mm *MissionManager) Import(b []byte, scope string, opts ImportOptions, files pm.PackageManager) (*model.Mission, error) {
+	if mm == nil || mm.db == nil {
+		return nil, fmt.Errorf("no database")
+	}
+
+	if opts.Conflict == "" {
+		opts.Conflict = ConflictFail
+	}
+
+	if opts.Conflict != ConflictFail && opts.Conflict != ConflictSkip && opts.Conflict != ConflictNew {
+		return nil, fmt.Errorf("invalid conflict policy %s", opts.Conflict)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	data := new(exportData)
+
+	if err := readJSON(zr, exportFile, data); err != nil {
+		return nil, err
+	}
+
+	if data.Version != exportVersion || data.Mission == nil {
+		return nil, fmt.Errorf("unsupported mission package")
+	}
+
+	m := data.Mission
+	m.ID = 0
+	m.Scope = scope
+	m.Token = uuid.NewString()
+	m.Items = nil
+	m.ParentID = 0
+
+	if opts.Name != "" {
+		m.Name = opts.Name
+	}
+
+	if _, err := m.HashPassword(); err != nil {
+		return nil, err
+	}
+
+	if m.Name == "" {
+		return nil, fmt.Errorf("null mission name")
+	}
+
+	if mm.GetMission(m.Scope, m.Name) != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMissionExists, m.Name)
+	}
+
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, cotDir) {
+			continue
+		}
+
+		item, err := readItem(f, scope)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+
+		if mm.pointExists(item.UID) {
+			switch opts.Conflict {
+			case ConflictSkip:
+				continue
+			case ConflictNew:
+				item.GetEvent().Uid = uuid.NewString()
+				item.UID = item.GetEvent().GetUid()
+			default:
+				return nil, fmt.Errorf("data item %s exists", item.UID)
+			}
+		}
+
+		m.Items = append(m.Items, item)
+	}
+
+	extData := make([]*model.ExternalData, 0, len(m.ExternalData))
+
+	for _, d := range m.ExternalData {
+		if mm.externalDataExists(d.ID) {
+			switch opts.Conflict {
+			case ConflictSkip:
+				continue
+			case ConflictNew:
+				d.ID = uuid.NewString()
+			default:
+				return nil, fmt.Errorf("external data %s exists", d.ID)
+			}
+		}
+
+		d.MissionID = 0
+		extData = append(extData, d)
+	}
+
+	m.ExternalData = extData
+
+	logs := make([]*model.LogEntry, 0, len(data.Logs))
+
+	for _, e := range data.Logs {
+		if mm.GetLogEntry(e.ID) != nil {
+			switch opts.Conflict {
+			case ConflictSkip:
+				continue
+			case ConflictNew:
+				e.ID = uuid.NewString()
+			default:
+				return nil, fmt.Errorf("log entry %s exists", e.ID)
+			}
+		}
+
+		e.Scope = scope
+		logs = append(logs, e)
+	}
+
+	saved, err := mm.importContents(zr, data.Contents, m, opts.Conflict, files)
+	if err != nil {
+		removeContents(saved, files)
+
+		return nil, err
+	}
+
+	err = mm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(m).Error; err != nil {
+			return err
+		}
+
+		changes := data.Changes
+		if len(changes) == 0 {
+			changes = []*model.Change{{CreateTime: time.Now(), Type: "CREATE_MISSION", CreatorUID: m.CreatorUID}}
+		}
+
+		// keep change sequence in time order
+		sort.Slice(changes, func(i, j int) bool {
+			return changes[i].CreateTime.Before(changes[j].CreateTime)
+		})
+
+		for _, c := range changes {
+			c.ID = 0
+			c.MissionID = m.ID
+		}
+
+		if err := tx.Create(changes).Error; err != nil {
+			return err
+		}
+
+		for _, e := range logs {
+			e.Missions = []*model.Mission{m}
+
+			if err := tx.Omit("Missions").Create(e).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Model(e).Association("Missions").Replace(e.Missions); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		removeContents(saved, files)
+
+		return nil, err
+	}
+
+	mm.invalidateAoi()
+
+	return m, nil
+}
+
+// importContents stores package files and fixes mission hashes, returns stored packages
+func (mm *MissionManager) importContents(zr *zip.Reader, contents []*pm.PackageInfo, m *model.Mission, conflict string, files pm.PackageManager) ([]*pm.PackageInfo, error) {
+	saved := make([]*pm.PackageInfo, 0, len(contents))
+
+	for _, pi := range contents {
+		// same file is already in this scope
+		if files.GetFirst(func(p *pm.PackageInfo) bool { return p.Hash == pi.Hash && p.Scope == m.Scope }) != nil {
+			continue
+		}
+
+		if files.Get(pi.UID) != nil {
+			switch conflict {
+			case ConflictSkip:
+				m.RemoveHash(pi.Hash)
+
+				continue
+			case ConflictNew:
+				pi.UID = uuid.NewString()
+			default:
+				return saved, fmt.Errorf("file %s exists", pi.UID)
+			}
+		}
+
+		f := findFile(zr, filesDir+pi.Hash+"/")
+		if f == nil {
+			mm.logger.Warn(fmt.Sprintf("no file %s in package", pi.Hash))
+			m.RemoveHash(pi.Hash)
+
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return saved, err
+		}
+
+		pi.Scope = m.Scope
+		err = files.SaveFile(pi, r)
+		_ = r.Close()
+
+		if err != nil {
+			return saved, fmt.Errorf("file %s: %w", pi.Name, err)
+		}
+
+		saved = append(saved, pi)
+	}
+
+	return saved, nil
+}
+
+// removeContents removes packages stored by failed import, file is kept if other package uses it
+func removeContents(saved []*pm.PackageInfo, files pm.PackageManager) {
+	for _, pi := range saved {
+		files.Delete(pi.UID)
+
+		if files.GetFirst(func(p *pm.PackageInfo) bool { return p.Hash == pi.Hash }) == nil {
+			_ = files.DeleteFile(pi.Hash)
+		}
+	}
+}
+
+func (mm *MissionManager) pointExists(uid string) bool {
+	var n int64
+
+	mm.db.Model(&model.DataItem{}).Where("uid = ?", uid).Count(&n)
+
+	return n > 0
+}
+
+func (mm *MissionManager) externalDataExists(id string) bool {
+	var n int64
+
+	mm.db.Model(&model.ExternalData{}).Where("id = ?", id).Count(&n)
+
+	return n > 0
+}
+
+func readItem(f *zip.File, scope string) (*model.DataItem, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	ev := new(cot.Event)
+
+	if err := xml.NewDecoder(r).Decode(ev); err != nil {
+		return nil, err
+	}
+
+	msg, err := cot.EventToProtoExt(ev, "", scope)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &model.DataItem{UID: msg.GetUID()}
+	item.UpdateFromMsg(msg)
+
+	return item, nil
+}
+
+func readJSON(zr *zip.Reader, name string, v any) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return json.NewDecoder(f).Decode(v)
+}
+
+func findFile(zr *zip.Reader, prefix string) *zip.File {
+	for _, f := range zr.File {
+		if strings.HasPrefix(f.Name, prefix) {
+			return f
+		}
+	}
+
+	return nil
+}
@@ -7,15 +7,33 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 
 	"github.com/kdudkov/goasae/internal/model"
 	"github.com/kdudkov/goasae/pkg/cot"
 )
 
+var ErrExternalDataExists = errors.New("external data exists in other mission")
+
+// contentChange selects changes of data items, external data and log entries (by content uid) and of files (by hash)
+const contentChange = "content_uid <> '' OR content_hash <> ''"
+
 type MissionManager struct {
 	db     *gorm.DB
 	logger *slog.Logger
+	aoi    aoiCache
+}
+
+// ChangeQuery is a filter for mission changes. Change id is a monotonic sequence, Since returns changes after it.
+// Squashed returns only the latest change for every content uid or file hash.
+type ChangeQuery struct {
+	After    time.Time
+	Before   time.Time
+	Since    uint
+	Limit    int
+	Offset   int
+	Squashed bool
 }
 
 func New(db *gorm.DB) *MissionManager {
@@ -33,24 +51,10 @@ func (mm *MissionManager) Save(s any) {
 	}
 
 	mm.db.Save(s)
-}
 
-func (mm *MissionManager) Migrate() error {
-	if mm == nil || mm.db == nil {
-		return fmt.Errorf("no database")
+	if _, ok := s.(*model.Mission); ok {
+		mm.invalidateAoi()
 	}
-
-	// Migrate the schema
-	if err := mm.db.AutoMigrate(
-		&model.Mission{},
-		&model.Subscription{},
-		&model.Invitation{},
-		&model.DataItem{},
-		&model.Change{}); err != nil {
-		return err
-	}
-
-	return nil
 }
 
 func (mm *MissionManager) GetAllMissionsAdm() []*model.Mission {
@@ -62,7 +66,9 @@ func (mm *MissionManager) GetAllMissionsAdm() []*model.Mission {
 
 	mm.db.Preload("Items", func(db *gorm.DB) *gorm.DB {
 		return db.Order("timestamp desc")
-	}).Find(&result)
+	}).Preload("ExternalData").Find(&result)
+
+	mm.setRelatives(result...)
 
 	return result
 }
@@ -76,7 +82,9 @@ func (mm *MissionManager) GetAllMissions(scope string) []*model.Mission {
 
 	mm.db.Preload("Items", func(db *gorm.DB) *gorm.DB {
 		return db.Order("timestamp desc")
-	}).Where("scope = ?", scope).Find(&result)
+	}).Preload("ExternalData").Where("scope = ?", scope).Find(&result)
+
+	mm.setRelatives(result...)
 
 	return result
 }
@@ -84,12 +92,14 @@ func (mm *MissionManager) GetAllMissions(scope string) []*model.Mission {
 func (mm *MissionManager) GetMissionById(id uint) *model.Mission {
 	var m *model.Mission
 
-	result := mm.db.Preload("Items").Take(&m, "id = ?", id)
+	result := mm.db.Preload("Items").Preload("ExternalData").Take(&m, "id = ?", id)
 
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return nil
 	}
 
+	mm.setRelatives(m)
+
 	return m
 }
 
@@ -98,12 +108,41 @@ func (mm *MissionManager) GetMission(scope, name string) *model.Mission {
 
 	result := mm.db.Preload("Items", func(db *gorm.DB) *gorm.DB {
 		return db.Order("timestamp desc")
-	}).Take(&m, "scope = ? and name = ?", scope, name)
+	}).Preload("ExternalData").Take(&m, "scope = ? and name = ?", scope, name)
 
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return nil
 	}
 
+	mm.setRelatives(m)
+
+	return m
+}
+
+func (mm *MissionManager) HasPoint(missionId uint, uid string) bool {
+	if mm == nil || mm.db == nil {
+		return false
+	}
+
+	var n int64
+
+	mm.db.Model(&model.DataItem{}).Where("mission_id = ? AND uid = ?", missionId, uid).Count(&n)
+
+	return n > 0
+}
+
+// GetMissionByChatRoom returns mission without items that has this chat room
+func (mm *MissionManager) GetMissionByChatRoom(scope, room string) *model.Mission {
+	if mm == nil || mm.db == nil || room == "" {
+		return nil
+	}
+
+	var m *model.Mission
+
+	if err := mm.db.Take(&m, "scope = ? and chat_room = ?", scope, room).Error; err != nil {
+		return nil
+	}
+
 	return m
 }
 
@@ -130,6 +169,8 @@ func (mm *MissionManager) PutMission(m *model.Mission) error {
 		return tx.Error
 	}
 
+	mm.invalidateAoi()
+
 	c := &model.Change{
 		CreateTime: time.Now(),
 		Type:       "CREATE_MISSION",
@@ -148,6 +189,21 @@ func (mm *MissionManager) DeleteMission(id uint) {
 	mm.db.Where("mission_id = ?", id).Delete(&model.Invitation{})
 	mm.db.Where("mission_id = ?", id).Delete(&model.DataItem{})
 	mm.db.Where("mission_id = ?", id).Delete(&model.Change{})
+	mm.db.Where("mission_id = ?", id).Delete(&model.ExternalData{})
+	mm.db.Model(&model.Mission{}).Where("parent_id = ?", id).Update("parent_id", 0)
+
+	var logs []string
+
+	mm.db.Raw("SELECT log_entry_id FROM log_entry_missions WHERE mission_id = ?", id).Scan(&logs)
+	mm.db.Exec("DELETE FROM log_entry_missions WHERE mission_id = ?", id)
+
+	// log entries of other missions are kept
+	if len(logs) > 0 {
+		mm.db.Where("id IN ? AND id NOT IN (SELECT log_entry_id FROM log_entry_missions)", logs).Delete(&model.LogEntry{})
+	}
+
+	mm.deleteSnapshots(id)
+	mm.invalidateAoi()
 }
 
 func (mm *MissionManager) AddKw(name string, kw []string) {
@@ -171,10 +227,16 @@ func (mm *MissionManager) GetPoint(uid string) *model.DataItem {
 }
 
 func (mm *MissionManager) AddPoint(mission *model.Mission, msg *cot.CotMessage) *model.Change {
+	return mm.addPoint(mission, msg, false)
+}
+
+func (mm *MissionManager) addPoint(mission *model.Mission, msg *cot.CotMessage, auto bool) *model.Change {
 	if mission == nil {
 		return nil
 	}
 
+	mm.forgetPoint(mission.ID, msg.GetUID())
+
 	now := time.Now()
 
 	for _, dp := range mission.Items {
@@ -187,7 +249,8 @@ func (mm *MissionManager) AddPoint(mission *model.Mission, msg *cot.CotMessage)
 	}
 
 	i := &model.DataItem{
-		UID: msg.GetUID(),
+		UID:          msg.GetUID(),
+		AutoIncluded: auto,
 	}
 
 	i.UpdateFromMsg(msg)
@@ -226,12 +289,17 @@ func (mm *MissionManager) DeleteMissionPoint(missionId uint, uid string, authorU
 
 	var mp *model.DataItem
 
-	res := mm.db.Where("mission_id = ? AND uid = ?", missionId, uid).Delete(&mp)
+	if err := mm.db.Where("mission_id = ? AND uid = ?", missionId, uid).Take(&mp).Error; err != nil {
+		return nil
+	}
 
-	if res.RowsAffected == 0 {
+	if res := mm.db.Delete(mp); res.RowsAffected == 0 {
 		return nil
 	}
 
+	mm.setAutoIncluded(missionId, uid, false)
+	mm.forgetPoint(missionId, uid)
+
 	c := &model.Change{
 		CreateTime:  time.Now(),
 		Type:        "REMOVE_CONTENT",
@@ -327,14 +395,61 @@ func (mm *MissionManager) GetSubscription(missionId uint, uid string) *model.Sub
 	return s
 }
 
-func (mm *MissionManager) DeleteSubscription(name string, uid string) {
-	mm.db.Where("mission_name = ? AND client_uid = ?", name, uid).Delete(&model.Subscription{})
+func (mm *MissionManager) DeleteSubscription(missionId uint, uid string) {
+	mm.db.Where("mission_id = ? AND client_uid = ?", missionId, uid).Delete(&model.Subscription{})
+}
+
+// SetRole changes role of existing subscription
+func (mm *MissionManager) SetRole(missionId uint, uid string, role string) bool {
+	res := mm.db.Model(&model.Subscription{}).Where("mission_id = ? AND client_uid = ?", missionId, uid).Update("role", role)
+
+	return res.Error == nil && res.RowsAffected > 0
+}
+
+// GetRole returns mission role of the client by its subscription or the best role of user subscriptions.
+// User created the mission is an owner. Empty string means no role
+func (mm *MissionManager) GetRole(m *model.Mission, uid string, username string) string {
+	if mm == nil || mm.db == nil || m == nil {
+		return ""
+	}
+
+	if uid != "" {
+		// subscription made by other user does not count
+		if s := mm.GetSubscription(m.ID, uid); s != nil && (s.Username == "" || username == "" || s.Username == username) {
+			return s.Role
+		}
+	}
+
+	if username != "" && username == m.Username {
+		return model.RoleOwner
+	}
+
+	if username == "" {
+		return ""
+	}
+
+	var subscriptions []*model.Subscription
+
+	mm.db.Where("mission_id = ? AND username = ?", m.ID, username).Find(&subscriptions)
+
+	role := ""
+
+	for _, s := range subscriptions {
+		switch {
+		case s.Role == model.RoleOwner:
+			return s.Role
+		case s.Role == model.RoleSubscriber, role == "":
+			role = s.Role
+		}
+	}
+
+	return role
 }
 
 func (mm *MissionManager) GetInvitation(missionId uint, uid string, typ string) *model.Invitation {
 	var s *model.Invitation
 
-	result := mm.db.Where("mission_id = ? AND client_uid = ? AND typ = ?", missionId, uid, typ).Take(&s)
+	result := mm.db.Where("mission_id = ? AND invitee = ? AND typ = ?", missionId, uid, typ).Take(&s)
 
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return nil
@@ -354,13 +469,13 @@ func (mm *MissionManager) PutInvitation(s *model.Invitation) {
 }
 
 func (mm *MissionManager) DeleteInvitation(missionId uint, uid string, typ string) {
-	mm.db.Where("mission_id = ? AND client_uid = ? AND typ = ?", missionId, uid, typ).Delete(&model.Invitation{})
+	mm.db.Where("mission_id = ? AND invitee = ? AND typ = ?", missionId, uid, typ).Delete(&model.Invitation{})
 }
 
 func (mm *MissionManager) GetInvitations(uid string) []string {
 	var m []*model.Invitation
 
-	result := mm.db.Where("client_uid = ?", uid).Find(&m)
+	result := mm.db.Where("invitee = ?", uid).Find(&m)
 
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return nil
@@ -377,13 +492,391 @@ func (mm *MissionManager) GetInvitations(uid string) []string {
 }
 
 func (mm *MissionManager) GetChanges(missionId uint, after time.Time) []*model.Change {
+	return mm.QueryChanges(missionId, &ChangeQuery{After: after})
+}
+
+// QueryChanges returns mission changes, newest first or, when Since is set, oldest first
+func (mm *MissionManager) QueryChanges(missionId uint, q *ChangeQuery) []*model.Change {
+	if mm == nil || mm.db == nil {
+		return nil
+	}
+
+	filter := func(tx *gorm.DB) *gorm.DB {
+		tx = tx.Where("mission_id = ?", missionId)
+
+		if !q.After.IsZero() {
+			tx = tx.Where("create_time > ?", q.After)
+		}
+
+		if !q.Before.IsZero() {
+			tx = tx.Where("create_time < ?", q.Before)
+		}
+
+		if q.Since > 0 {
+			tx = tx.Where("id > ?", q.Since)
+		}
+
+		return tx
+	}
+
+	tx := filter(mm.db.Model(&model.Change{}))
+
+	if q.Squashed {
+		latest := filter(mm.db.Model(&model.Change{})).Select("MAX(id)").Where(contentChange).Group("content_uid, content_hash")
+		tx = tx.Where("NOT ("+contentChange+") OR id IN (?)", latest)
+	}
+
+	if q.Since > 0 {
+		tx = tx.Order("id")
+	} else {
+		tx = tx.Order("id DESC")
+	}
+
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit)
+	}
+
+	if q.Offset > 0 {
+		tx = tx.Offset(q.Offset)
+	}
+
 	var m []*model.Change
 
-	err := mm.db.Where("mission_id = ? and create_time > ?", missionId, after).Order("create_time DESC").Find(&m).Error
+	tx.Find(&m)
 
-	if errors.Is(err, gorm.ErrRecordNotFound) {
+	return m
+}
+
+// CompactChanges removes changes older than before that are superseded by later change of the same content
+func (mm *MissionManager) CompactChanges(before time.Time) int64 {
+	if mm == nil || mm.db == nil {
+		return 0
+	}
+
+	latest := mm.db.Model(&model.Change{}).Select("MAX(id)").Where(contentChange).Group("mission_id, content_uid, content_hash")
+
+	res := mm.db.Where("create_time < ? AND ("+contentChange+") AND id NOT IN (?)", before, latest).Delete(&model.Change{})
+
+	if res.Error != nil {
+		mm.logger.Error("changes compaction error", slog.Any("error", res.Error))
+
+		return 0
+	}
+
+	return res.RowsAffected
+}
+
+// PutLogEntry creates or updates log entry and its missions
+func (mm *MissionManager) PutLogEntry(e *model.LogEntry) error {
+	if mm == nil || mm.db == nil {
+		return fmt.Errorf("no database")
+	}
+
+	if e.ID == "" {
+		return fmt.Errorf("empty log entry id")
+	}
+
+	return mm.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Missions").Save(e).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(e).Association("Missions").Replace(e.Missions)
+	})
+}
+
+// AddLogEntryChange stores add, update or delete change of the log entry in the mission
+func (mm *MissionManager) AddLogEntryChange(m *model.Mission, e *model.LogEntry, typ string) *model.Change {
+	if mm == nil || mm.db == nil || m == nil || e == nil {
 		return nil
 	}
 
-	return m
+	c := &model.Change{
+		CreateTime: time.Now(),
+		Type:       typ,
+		MissionID:  m.ID,
+		CreatorUID: e.CreatorUID,
+		ContentUID: e.ID,
+	}
+
+	if err := mm.db.Create(c).Error; err != nil {
+		mm.logger.Error("change save error", slog.Any("error", err))
+
+		return nil
+	}
+
+	return c
+}
+
+func (mm *MissionManager) GetLogEntry(id string) *model.LogEntry {
+	if mm == nil || mm.db == nil {
+		return nil
+	}
+
+	var e *model.LogEntry
+
+	result := mm.db.Preload("Missions").Take(&e, "id = ?", id)
+
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil
+	}
+
+	return e
+}
+
+func (mm *MissionManager) DeleteLogEntry(id string) {
+	if mm == nil || mm.db == nil {
+		return
+	}
+
+	mm.db.Exec("DELETE FROM log_entry_missions WHERE log_entry_id = ?", id)
+	mm.db.Where("id = ?", id).Delete(&model.LogEntry{})
+}
+
+// GetLogEntries returns mission log entries with dtg in [start, end), zero time means no limit
+func (mm *MissionManager) GetLogEntries(missionId uint, start, end time.Time) []*model.LogEntry {
+	if mm == nil || mm.db == nil {
+		return nil
+	}
+
+	tx := mm.db.Preload("Missions").
+		Joins("JOIN log_entry_missions ON log_entry_missions.log_entry_id = log_entries.id").
+		Where("log_entry_missions.mission_id = ?", missionId)
+
+	if !start.IsZero() {
+		tx = tx.Where("log_entries.dtg >= ?", start)
+	}
+
+	if !end.IsZero() {
+		tx = tx.Where("log_entries.dtg < ?", end)
+	}
+
+	var res []*model.LogEntry
+
+	tx.Order("log_entries.dtg DESC").Find(&res)
+
+	return res
+}
+
+// GetReferencedHashes returns file hashes used by missions, mission snapshots and log entries
+func (mm *MissionManager) GetReferencedHashes() map[string]bool {
+	res := make(map[string]bool)
+
+	if mm == nil || mm.db == nil {
+		return res
+	}
+
+	var lists []string
+
+	for _, q := range []struct {
+		m   any
+		col string
+	}{{&model.Mission{}, "hashes"}, {&model.MissionSnapshot{}, "hashes"}, {&model.LogEntry{}, "content_hashes"}} {
+		var l []string
+
+		mm.db.Model(q.m).Where(q.col+" <> ''").Pluck(q.col, &l)
+		lists = append(lists, l...)
+	}
+
+	for _, l := range lists {
+		for _, h := range strings.Split(l, ",") {
+			if h != "" {
+				res[h] = true
+			}
+		}
+	}
+
+	return res
+}
+
+// CheckToken checks that token is signed with mission key and its subscriber is still subscribed
+func (mm *MissionManager) CheckToken(m *model.Mission, token string) bool {
+	if mm == nil || mm.db == nil || m == nil || token == "" {
+		return false
+	}
+
+	uid, err := ParseToken(m, token)
+	if err != nil {
+		return false
+	}
+
+	return mm.GetSubscription(m.ID, uid) != nil
+}
+
+// PutExternalData adds or updates mission external data. Data with the same id in other mission can't be changed
+func (mm *MissionManager) PutExternalData(m *model.Mission, d *model.ExternalData, authorUID string) (*model.Change, error) {
+	if mm == nil || mm.db == nil || m == nil || d == nil {
+		return nil, fmt.Errorf("no database")
+	}
+
+	now := time.Now()
+
+	if d.ID == "" {
+		d.ID = uuid.NewString()
+	} else {
+		var old *model.ExternalData
+
+		if err := mm.db.Where("id = ?", d.ID).Take(&old).Error; err == nil && old.MissionID != m.ID {
+			return nil, fmt.Errorf("%w: %s", ErrExternalDataExists, d.ID)
+		}
+	}
+
+	d.MissionID = m.ID
+	d.CreatorUID = authorUID
+	d.Created = now
+
+	if err := mm.db.Save(d).Error; err != nil {
+		return nil, err
+	}
+
+	mm.db.Model(m).Update("last_edit", now)
+
+	c := &model.Change{
+		CreateTime:   now,
+		Type:         "ADD_CONTENT",
+		MissionID:    m.ID,
+		CreatorUID:   authorUID,
+		ContentUID:   d.ID,
+		ExternalData: d,
+	}
+
+	mm.db.Create(c)
+
+	return c, nil
+}
+
+func (mm *MissionManager) DeleteExternalData(m *model.Mission, id string, authorUID string) *model.Change {
+	if mm == nil || mm.db == nil || m == nil || id == "" {
+		return nil
+	}
+
+	var d *model.ExternalData
+
+	if err := mm.db.Where("mission_id = ? AND id = ?", m.ID, id).Take(&d).Error; err != nil {
+		return nil
+	}
+
+	mm.db.Delete(d)
+
+	c := &model.Change{
+		CreateTime:   time.Now(),
+		Type:         "REMOVE_CONTENT",
+		MissionID:    m.ID,
+		CreatorUID:   authorUID,
+		ContentUID:   d.ID,
+		ExternalData: d,
+	}
+
+	mm.db.Create(c)
+
+	return c
+}
+
+// SetParent makes mission a child of parent, nil parent makes it top level mission
+func (mm *MissionManager) SetParent(m *model.Mission, parent *model.Mission) error {
+	if mm == nil || mm.db == nil {
+		return fmt.Errorf("no database")
+	}
+
+	var parentID uint
+
+	if parent != nil {
+		if parent.Scope != m.Scope {
+			return fmt.Errorf("parent mission is in other scope")
+		}
+
+		for _, p := range append([]*model.Mission{parent}, mm.GetParents(parent)...) {
+			if p.ID == m.ID {
+				return fmt.Errorf("mission %s can't be a parent of itself", m.Name)
+			}
+		}
+
+		parentID = parent.ID
+	}
+
+	if err := mm.db.Model(m).Update("parent_id", parentID).Error; err != nil {
+		return err
+	}
+
+	m.ParentID = parentID
+	mm.setRelatives(m)
+	mm.invalidateAoi()
+
+	return nil
+}
+
+// GetParents returns parent, grandparent etc. of the mission
+func (mm *MissionManager) GetParents(m *model.Mission) []*model.Mission {
+	if mm == nil || mm.db == nil || m == nil {
+		return nil
+	}
+
+	var res []*model.Mission
+
+	seen := map[uint]bool{m.ID: true}
+
+	for id := m.ParentID; id != 0 && !seen[id]; {
+		var p *model.Mission
+
+		if err := mm.db.Take(&p, "id = ?", id).Error; err != nil {
+			break
+		}
+
+		seen[id] = true
+		res = append(res, p)
+		id = p.ParentID
+	}
+
+	return res
+}
+
+func (mm *MissionManager) GetChildren(m *model.Mission) []*model.Mission {
+	if mm == nil || mm.db == nil || m == nil {
+		return nil
+	}
+
+	var res []*model.Mission
+
+	mm.db.Preload("Items").Preload("ExternalData").Where("parent_id = ?", m.ID).Order("name").Find(&res)
+
+	mm.setRelatives(res...)
+
+	return res
+}
+
+// setRelatives fills parent and children names
+func (mm *MissionManager) setRelatives(ms ...*model.Mission) {
+	if len(ms) == 0 {
+		return
+	}
+
+	ids := make([]uint, 0, len(ms))
+	parentIds := make([]uint, 0)
+
+	for _, m := range ms {
+		ids = append(ids, m.ID)
+
+		if m.ParentID != 0 {
+			parentIds = append(parentIds, m.ParentID)
+		}
+	}
+
+	var relatives []*model.Mission
+
+	mm.db.Select("id", "name", "parent_id").Where("id IN ? OR parent_id IN ?", parentIds, ids).Order("name").Find(&relatives)
+
+	for _, m := range ms {
+		m.ParentName = ""
+		m.Children = nil
+
+		for _, r := range relatives {
+			if r.ID == m.ParentID {
+				m.ParentName = r.Name
+			}
+
+			if r.ParentID == m.ID {
+				m.Children = append(m.Children, r.Name)
+			}
+		}
+	}
 }
@@ -0,0 +1,68 @@
+package missions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/kdudkov/goasae/internal/database/dbtest"
+	"github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/pkg/cot"
+)
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func newMsg(scope, uid string) *cot.CotMessage {
+	tak := cot.BasicMsg("a-f-G", uid, time.Second*10)
+	det, _ := cot.DetailsFromString(tak.GetCotEvent().GetDetail().GetXmlDetail())
+
+	return &cot.CotMessage{TakMessage: tak, Detail: det, Scope: scope}
+}
+
+func TestMissions(t *testing.T) {
+	dbtest.ForEach(t, func(t *testing.T, db *gorm.DB) {
+		mm := New(db)
+
+		m1 := &model.Mission{Name: "mission1", Scope: "s1", ChatRoom: "room1", Bbox: "59,30,60,31", AutoInclude: true}
+		require.NoError(t, mm.PutMission(m1))
+		require.NoError(t, mm.PutMission(&model.Mission{Name: "mission2", Scope: "s1"}))
+		require.Error(t, mm.PutMission(&model.Mission{Name: "mission1", Scope: "s1"}))
+
+		assert.Len(t, mm.GetAllMissions("s1"), 2)
+		assert.Len(t, mm.GetAutoIncludeMissions("s1"), 1)
+		assert.Equal(t, m1.ID, mm.GetMissionByChatRoom("s1", "room1").ID)
+
+		mm.AddPoint(m1, newMsg("s1", "uid1"))
+		mm.AddPoint(m1, newMsg("s1", "uid2"))
+		assert.True(t, mm.HasPoint(m1.ID, "uid1"))
+		assert.Len(t, mm.GetMission("s1", "mission1").Items, 2)
+
+		require.NotNil(t, mm.DeleteMissionPoint(m1.ID, "uid1", ""))
+		assert.False(t, mm.HasPoint(m1.ID, "uid1"))
+
+		// file added and removed
+		for _, typ := range []string{"ADD_CONTENT", "REMOVE_CONTENT"} {
+			require.NoError(t, db.Create(&model.Change{CreateTime: time.Now(), Type: typ, MissionID: m1.ID, ContentHash: "h1"}).Error)
+		}
+
+		assert.Len(t, mm.QueryChanges(m1.ID, &ChangeQuery{}), 6)
+		assert.Len(t, mm.QueryChanges(m1.ID, &ChangeQuery{Squashed: true}), 4)
+		assert.Equal(t, int64(2), mm.CompactChanges(time.Now().Add(time.Minute)))
+		assert.Len(t, mm.QueryChanges(m1.ID, &ChangeQuery{}), 4)
+
+		mm.PutSubscription(&model.Subscription{MissionID: m1.ID, ClientUID: "client1", Role: "MISSION_OWNER", CreateTime: time.Now()})
+		assert.Equal(t, []string{"client1"}, mm.GetSubscribers(m1.ID))
+
+		require.NoError(t, mm.PutLogEntry(&model.LogEntry{ID: "log1", Scope: "s1", Dtg: time.Now(), Missions: []*model.Mission{m1}}))
+		assert.Len(t, mm.GetLogEntries(m1.ID, time.Now().Add(-time.Hour), time.Time{}), 1)
+
+		mm.DeleteMission(m1.ID)
+		assert.Nil(t, mm.GetMission("s1", "mission1"))
+		assert.Empty(t, mm.GetLogEntries(m1.ID, time.Time{}, time.Time{}))
+	})
+}
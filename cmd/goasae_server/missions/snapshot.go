@@ -0,0 +1,315 @@
+package missions
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/kdudkov/goasae/internal/model"
+)
+
+// SnapshotRestored is the creator of snapshots taken before restore
+const SnapshotRestored = "restore"
+
+type SnapshotDiff struct {
+	Added         []string `json:"added"`
+	Removed       []string `json:"removed"`
+	Changed       []string `json:"changed"`
+	AddedHashes   []string `json:"addedHashes"`
+	RemovedHashes []string `json:"removedHashes"`
+	Keywords      bool     `json:"keywordsChanged"`
+}
+
+func (d *SnapshotDiff) Empty() bool {
+	return len(d.Added)+len(d.Removed)+len(d.Changed)+len(d.AddedHashes)+len(d.RemovedHashes) == 0 && !d.Keywords
+}
+
+// CreateSnapshot stores current mission items, hashes and keywords. Items not changed since previous snapshots are not copied
+func (mm *MissionManager) CreateSnapshot(m *model.Mission, creatorUID, comment string, auto bool) (*model.MissionSnapshot, error) {
+	if mm == nil || mm.db == nil {
+		return nil, fmt.Errorf("no database")
+	}
+
+	if m == nil {
+		return nil, fmt.Errorf("null mission")
+	}
+
+	var s *model.MissionSnapshot
+
+	err := mm.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+
+		s, err = snapshot(tx, m.ID, creatorUID, comment, auto)
+
+		return err
+	})
+
+	return s, err
+}
+
+func snapshot(tx *gorm.DB, missionId uint, creatorUID, comment string, auto bool) (*model.MissionSnapshot, error) {
+	var m *model.Mission
+
+	if err := tx.Preload("Items").Take(&m, "id = ?", missionId).Error; err != nil {
+		return nil, err
+	}
+
+	s := &model.MissionSnapshot{
+		MissionID:  m.ID,
+		Created:    time.Now(),
+		CreatorUID: creatorUID,
+		Auto:       auto,
+		Comment:    comment,
+		Hashes:     m.Hashes,
+		Keywords:   m.Keywords,
+		Items:      make([]*model.SnapshotItem, 0, len(m.Items)),
+	}
+
+	for _, item := range m.Items {
+		b, err := model.NewSnapshotBlob(item)
+		if err != nil {
+			return nil, fmt.Errorf("item %s: %w", item.UID, err)
+		}
+
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(b).Error; err != nil {
+			return nil, err
+		}
+
+		s.Items = append(s.Items, &model.SnapshotItem{UID: item.UID, Hash: b.Hash})
+	}
+
+	if err := tx.Create(s).Error; err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// GetSnapshots returns mission snapshots, newest first
+func (mm *MissionManager) GetSnapshots(missionId uint) []*model.MissionSnapshot {
+	if mm == nil || mm.db == nil {
+		return nil
+	}
+
+	var res []*model.MissionSnapshot
+
+	mm.db.Preload("Items").Where("mission_id = ?", missionId).Order("id DESC").Find(&res)
+
+	return res
+}
+
+func (mm *MissionManager) GetSnapshot(missionId, id uint) *model.MissionSnapshot {
+	if mm == nil || mm.db == nil {
+		return nil
+	}
+
+	var s *model.MissionSnapshot
+
+	if err := mm.db.Preload("Items").Where("mission_id = ? AND id = ?", missionId, id).Take(&s).Error; err != nil {
+		return nil
+	}
+
+	return s
+}
+
+// LastSnapshotTime returns time of the latest mission snapshot or zero time
+func (mm *MissionManager) LastSnapshotTime(missionId uint) time.Time {
+	var s *model.MissionSnapshot
+
+	if err := mm.db.Where("mission_id = ?", missionId).Order("id DESC").Take(&s).Error; err != nil {
+		return time.Time{}
+	}
+
+	return s.Created
+}
+
+func (mm *MissionManager) DeleteSnapshot(id uint) {
+	if mm == nil || mm.db == nil {
+		return
+	}
+
+	mm.db.Where("snapshot_id = ?", id).Delete(&model.SnapshotItem{})
+	mm.db.Where("id = ?", id).Delete(&model.MissionSnapshot{})
+	mm.deleteUnusedBlobs()
+}
+
+// PruneSnapshots keeps only keep newest automatic snapshots of the mission
+func (mm *MissionManager) PruneSnapshots(missionId uint, keep int) int {
+	if mm == nil || mm.db == nil || keep <= 0 {
+		return 0
+	}
+
+	var ids []uint
+
+	mm.db.Model(&model.MissionSnapshot{}).Where("mission_id = ? AND auto = ?", missionId, true).
+		Order("id DESC").Offset(keep).Pluck("id", &ids)
+
+	if len(ids) == 0 {
+		return 0
+	}
+
+	mm.db.Where("snapshot_id IN ?", ids).Delete(&model.SnapshotItem{})
+	mm.db.Where("id IN ?", ids).Delete(&model.MissionSnapshot{})
+	mm.deleteUnusedBlobs()
+
+	return len(ids)
+}
+
+func (mm *MissionManager) deleteSnapshots(missionId uint) {
+	ids := mm.db.Model(&model.MissionSnapshot{}).Select("id").Where("mission_id = ?", missionId)
+	mm.db.Where("snapshot_id IN (?)", ids).Delete(&model.SnapshotItem{})
+	mm.db.Where("mission_id = ?", missionId).Delete(&model.MissionSnapshot{})
+	mm.deleteUnusedBlobs()
+}
+
+func (mm *MissionManager) deleteUnusedBlobs() {
+	used := mm.db.Model(&model.SnapshotItem{}).Select("hash")
+	mm.db.Where("hash NOT IN (?)", used).Delete(&model.SnapshotBlob{})
+}
+
+// DiffSnapshots returns changes needed to go from snapshot a to snapshot b
+func DiffSnapshots(a, b *model.MissionSnapshot) *SnapshotDiff {
+	d := &SnapshotDiff{
+		Added:         []string{},
+		Removed:       []string{},
+		Changed:       []string{},
+		AddedHashes:   []string{},
+		RemovedHashes: []string{},
+		Keywords:      a.Keywords != b.Keywords,
+	}
+
+	itemsA, itemsB := a.ItemHashes(), b.ItemHashes()
+
+	for uid, h := range itemsB {
+		if old, ok := itemsA[uid]; !ok {
+			d.Added = append(d.Added, uid)
+		} else if old != h {
+			d.Changed = append(d.Changed, uid)
+		}
+	}
+
+	for uid := range itemsA {
+		if _, ok := itemsB[uid]; !ok {
+			d.Removed = append(d.Removed, uid)
+		}
+	}
+
+	hashesA, hashesB := a.GetHashes(), b.GetHashes()
+
+	d.AddedHashes = slices.DeleteFunc(append([]string{}, hashesB...), func(h string) bool { return slices.Contains(hashesA, h) })
+	d.RemovedHashes = slices.DeleteFunc(append([]string{}, hashesA...), func(h string) bool { return slices.Contains(hashesB, h) })
+
+	for _, l := range [][]string{d.Added, d.Removed, d.Changed} {
+		sort.Strings(l)
+	}
+
+	return d
+}
+
+// RestoreSnapshot makes mission content equal to snapshot. Current state is saved as a new snapshot first,
+// returned changes are recorded in mission change log
+func (mm *MissionManager) RestoreSnapshot(m *model.Mission, s *model.MissionSnapshot, authorUID string) ([]*model.Change, error) {
+	if mm == nil || mm.db == nil {
+		return nil, fmt.Errorf("no database")
+	}
+
+	if m == nil || s == nil || s.MissionID != m.ID {
+		return nil, fmt.Errorf("invalid snapshot")
+	}
+
+	var changes []*model.Change
+
+	err := mm.db.Transaction(func(tx *gorm.DB) error {
+		current, err := snapshot(tx, m.ID, SnapshotRestored, fmt.Sprintf("before restore of snapshot %d", s.ID), false)
+		if err != nil {
+			return err
+		}
+
+		diff := DiffSnapshots(current, s)
+		now := time.Now()
+
+		for _, uid := range append(diff.Removed, diff.Changed...) {
+			var d *model.DataItem
+
+			if err := tx.Where("mission_id = ? AND uid = ?", m.ID, uid).Take(&d).Error; err != nil {
+				return err
+			}
+
+			if err := tx.Delete(d).Error; err != nil {
+				return err
+			}
+
+			if !slices.Contains(diff.Changed, uid) {
+				changes = append(changes, itemChange("REMOVE_CONTENT", m.ID, authorUID, now, d))
+			}
+		}
+
+		hashes := s.ItemHashes()
+
+		for _, uid := range append(diff.Added, diff.Changed...) {
+			var b *model.SnapshotBlob
+
+			if err := tx.Take(&b, "hash = ?", hashes[uid]).Error; err != nil {
+				return fmt.Errorf("item %s: %w", uid, err)
+			}
+
+			d, err := b.GetItem()
+			if err != nil {
+				return err
+			}
+
+			d.MissionID = m.ID
+
+			if err := tx.Create(d).Error; err != nil {
+				return err
+			}
+
+			changes = append(changes, itemChange("ADD_CONTENT", m.ID, authorUID, now, d))
+		}
+
+		for _, h := range diff.AddedHashes {
+			changes = append(changes, &model.Change{CreateTime: now, Type: "ADD_CONTENT", MissionID: m.ID, CreatorUID: authorUID, ContentHash: h})
+		}
+
+		for _, h := range diff.RemovedHashes {
+			changes = append(changes, &model.Change{CreateTime: now, Type: "REMOVE_CONTENT", MissionID: m.ID, CreatorUID: authorUID, ContentHash: h})
+		}
+
+		if len(changes) > 0 {
+			if err := tx.Create(changes).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&model.Mission{}).Where("id = ?", m.ID).
+			Updates(map[string]any{"hashes": s.Hashes, "keywords": s.Keywords, "last_edit": now}).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	mm.invalidateAoi()
+
+	return changes, nil
+}
+
+func itemChange(typ string, missionId uint, authorUID string, t time.Time, d *model.DataItem) *model.Change {
+	return &model.Change{
+		CreateTime:  t,
+		Type:        typ,
+		MissionID:   missionId,
+		CreatorUID:  authorUID,
+		ContentUID:  d.UID,
+		CotType:     d.Type,
+		Callsign:    d.Callsign,
+		IconsetPath: d.IconsetPath,
+		Color:       d.Color,
+		Lat:         d.Lat,
+		Lon:         d.Lon,
+	}
+}
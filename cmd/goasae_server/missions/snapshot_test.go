@@ -0,0 +1,89 @@
+package missions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/kdudkov/goasae/internal/database/dbtest"
+	"github.com/kdudkov/goasae/internal/model"
+)
+
+func TestSnapshots(t *testing.T) {
+	dbtest.ForEach(t, func(t *testing.T, db *gorm.DB) {
+		mm := New(db)
+
+		m := &model.Mission{Name: "mission1", Scope: "s1", Hashes: "h1", Keywords: "a"}
+		require.NoError(t, mm.PutMission(m))
+
+		mm.AddPoint(m, newMsg("s1", "uid1"))
+		mm.AddPoint(m, newMsg("s1", "uid2"))
+
+		s1, err := mm.CreateSnapshot(mm.GetMissionById(m.ID), "admin", "", false)
+		require.NoError(t, err)
+		require.Len(t, s1.Items, 2)
+
+		mm.DeleteMissionPoint(m.ID, "uid1", "")
+
+		m = mm.GetMissionById(m.ID)
+		msg := newMsg("s1", "uid2")
+		msg.GetTakMessage().GetCotEvent().Lat = 10
+		mm.AddPoint(m, msg)
+		mm.AddPoint(m, newMsg("s1", "uid3"))
+		m.AddHashes("h2")
+		m.Keywords = "a,b"
+		mm.Save(m)
+
+		s2, err := mm.CreateSnapshot(mm.GetMissionById(m.ID), "admin", "", false)
+		require.NoError(t, err)
+
+		d := DiffSnapshots(s1, s2)
+		assert.Equal(t, []string{"uid3"}, d.Added)
+		assert.Equal(t, []string{"uid1"}, d.Removed)
+		assert.Equal(t, []string{"uid2"}, d.Changed)
+		assert.Equal(t, []string{"h2"}, d.AddedHashes)
+		assert.Empty(t, d.RemovedHashes)
+		assert.True(t, d.Keywords)
+
+		changes, err := mm.RestoreSnapshot(m, mm.GetSnapshot(m.ID, s1.ID), "user1")
+		require.NoError(t, err)
+		assert.Len(t, changes, 4)
+
+		m = mm.GetMissionById(m.ID)
+		require.Len(t, m.Items, 2)
+		assert.Equal(t, "h1", m.Hashes)
+		assert.Equal(t, "a", m.Keywords)
+
+		for _, item := range m.Items {
+			assert.Equal(t, 0., item.GetEvent().GetLat())
+		}
+
+		snapshots := mm.GetSnapshots(m.ID)
+		require.Len(t, snapshots, 3)
+		assert.Equal(t, SnapshotRestored, snapshots[0].CreatorUID)
+		assert.True(t, DiffSnapshots(snapshots[0], s2).Empty())
+
+		refs := mm.GetReferencedHashes()
+		assert.True(t, refs["h1"])
+		assert.True(t, refs["h2"])
+
+		var n int64
+		db.Model(&model.SnapshotBlob{}).Count(&n)
+		assert.Equal(t, int64(4), n)
+
+		mm.DeleteSnapshot(s2.ID)
+		mm.DeleteSnapshot(snapshots[0].ID)
+		db.Model(&model.SnapshotBlob{}).Count(&n)
+		assert.Equal(t, int64(2), n)
+
+		for i := 0; i < 3; i++ {
+			_, err = mm.CreateSnapshot(m, "auto", "", true)
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, 1, mm.PruneSnapshots(m.ID, 2))
+		assert.Len(t, mm.GetSnapshots(m.ID), 3)
+	})
+}
@@ -0,0 +1,78 @@
+package missions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kdudkov/goasae/internal/model"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid mission token")
+
+	tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+)
+
+type tokenClaims struct {
+	ID      string `json:"jti"`
+	Subject string `json:"sub"`
+	Mission string `json:"mission"`
+	Issued  int64  `json:"iat"`
+}
+
+// MakeToken makes JWT mission token for subscriber uid signed with mission key (Mission.Token)
+func MakeToken(m *model.Mission, uid string) string {
+	claims, _ := json.Marshal(&tokenClaims{
+		ID:      uuid.NewString(),
+		Subject: uid,
+		Mission: m.Name,
+		Issued:  time.Now().Unix(),
+	})
+
+	s := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	return s + "." + sign(m.Token, s)
+}
+
+// ParseToken checks token signature and returns subscriber uid
+func ParseToken(m *model.Mission, token string) (string, error) {
+	n := strings.LastIndexByte(token, '.')
+	if n < 0 || m.Token == "" {
+		return "", ErrInvalidToken
+	}
+
+	if !hmac.Equal([]byte(token[n+1:]), []byte(sign(m.Token, token[:n]))) {
+		return "", ErrInvalidToken
+	}
+
+	parts := strings.Split(token[:n], ".")
+	if len(parts) != 2 || parts[0] != tokenHeader {
+		return "", ErrInvalidToken
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	c := new(tokenClaims)
+	if err := json.Unmarshal(b, c); err != nil || c.Mission != m.Name {
+		return "", ErrInvalidToken
+	}
+
+	return c.Subject, nil
+}
+
+func sign(key string, s string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(s))
+
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
@@ -4,6 +4,7 @@ import (
 	"archive/zip"
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 )
@@ -48,34 +49,45 @@ func (m *MissionPackage) Manifest() []byte {
 
 func (m *MissionPackage) Create() ([]byte, error) {
 	buff := new(bytes.Buffer)
-	zipW := zip.NewWriter(buff)
 
-	f, err := zipW.Create("MANIFEST/manifest.xml")
-	if err != nil {
+	if err := m.Write(buff); err != nil {
 		return nil, err
 	}
 
-	_, err = f.Write(m.Manifest())
+	return buff.Bytes(), nil
+}
 
+// Write writes package zip to w, content of ReaderFile files is copied without reading it to memory
+func (m *MissionPackage) Write(w io.Writer) error {
+	zipW := zip.NewWriter(w)
+
+	f, err := zipW.Create("MANIFEST/manifest.xml")
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if _, err := f.Write(m.Manifest()); err != nil {
+		return err
 	}
 
 	for _, zf := range m.files {
 		f1, err := zipW.Create(zf.Name())
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		if rf, ok := zf.(*ReaderFile); ok {
+			err = rf.copyTo(f1)
+		} else {
+			_, err = f1.Write(zf.Content())
 		}
 
-		_, err = f1.Write(zf.Content())
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("%s: %w", zf.Name(), err)
 		}
 	}
 
-	err = zipW.Close()
-
-	return buff.Bytes(), err
+	return zipW.Close()
 }
 
 type FileContent interface {
@@ -84,6 +96,45 @@ type FileContent interface {
 	Content() []byte
 }
 
+// ReaderFile is a file opened only when package is written
+type ReaderFile struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+func NewReaderFile(name string, open func() (io.ReadCloser, error)) *ReaderFile {
+	return &ReaderFile{name: name, open: open}
+}
+
+func (f *ReaderFile) Name() string {
+	return f.name
+}
+
+func (f *ReaderFile) SetName(name string) {
+	f.name = name
+}
+
+func (f *ReaderFile) Content() []byte {
+	var b bytes.Buffer
+
+	_ = f.copyTo(&b)
+
+	return b.Bytes()
+}
+
+func (f *ReaderFile) copyTo(w io.Writer) error {
+	r, err := f.open()
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+
+	return err
+}
+
 type FsFile struct {
 	name string
 	data []byte
@@ -98,6 +149,10 @@ func NewFsFile(name, path string) (*FsFile, error) {
 	return &FsFile{name: name, data: dat}, nil
 }
 
+func NewBytesFile(name string, data []byte) *FsFile {
+	return &FsFile{name: name, data: data}
+}
+
 func (f *FsFile) Name() string {
 	return f.name
 }
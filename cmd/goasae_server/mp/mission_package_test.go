@@ -1,7 +1,11 @@
 package mp
 
 import (
+	"archive/zip"
+	"bytes"
+	"io"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/google/uuid"
@@ -33,3 +37,29 @@ func TestMissionPackage_Create(t *testing.T) {
 	_, _ = f.Write(dat)
 	_ = f.Close()
 }
+
+func TestMissionPackage_Write(t *testing.T) {
+	mp := NewMissionPackage("test", "test")
+	mp.AddFile(NewReaderFile("files/a.txt", func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("content")), nil
+	}))
+
+	var buf bytes.Buffer
+	if err := mp.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := zr.Open("files/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := io.ReadAll(f); string(b) != "content" {
+		t.Errorf("invalid content %q", b)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kdudkov/goasae/internal/client"
+	"github.com/kdudkov/goasae/pkg/cot"
+)
+
+// queueIfOffline stores direct message for the contact that is not connected now
+func (app *App) queueIfOffline(uid string, msg *cot.CotMessage) {
+	if !app.outbox.Accepts(msg) || app.isOnline(uid) {
+		return
+	}
+
+	app.logger.Debug(fmt.Sprintf("%s is offline, queue %s %s", uid, msg.GetType(), msg.GetUID()))
+
+	if err := app.outbox.Put(uid, msg); err != nil {
+		app.logger.Error("outbox error", slog.Any("error", err))
+	}
+}
+
+// deliverOutbox sends queued messages to the contact that is connected again
+func (app *App) deliverOutbox(uid string) {
+	var handler client.ClientHandler
+
+	app.ForAllClients(func(ch client.ClientHandler) bool {
+		if ch.HasUID(uid) {
+			handler = ch
+
+			return false
+		}
+
+		return true
+	})
+
+	if handler == nil {
+		return
+	}
+
+	n := app.outbox.Deliver(uid, func(msg *cot.CotMessage) error {
+		if err := handler.SendMsg(msg); err != nil {
+			return err
+		}
+
+		if msg.GetType() == "b-f-t-r" {
+			app.transfers.SetSent(msg.GetUID(), uid, handler.GetUser().GetLogin(), time.Now())
+		}
+
+		return nil
+	})
+
+	if n > 0 {
+		app.logger.Info(fmt.Sprintf("%d queued messages are sent to %s", n, uid))
+	}
+}
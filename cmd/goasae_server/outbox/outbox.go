@@ -0,0 +1,156 @@
+package outbox
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+
+	"github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/cotproto"
+)
+
+// DefaultTypes are direct message types that are kept for offline recipients:
+// chat, file share, mission invite and image
+var DefaultTypes = []string{"b-t-f", "b-f-t-", "t-x-m-i", "b-i-x-"}
+
+// Outbox is a durable per-recipient queue of direct messages
+type Outbox struct {
+	db      *gorm.DB
+	logger  *slog.Logger
+	ttl     time.Duration
+	maxSize int
+	types   []string
+}
+
+func New(db *gorm.DB, ttl time.Duration, maxSize int, types []string) *Outbox {
+	if len(types) == 0 {
+		types = DefaultTypes
+	}
+
+	return &Outbox{
+		db:      db,
+		logger:  slog.Default().With("logger", "Outbox"),
+		ttl:     ttl,
+		maxSize: maxSize,
+		types:   types,
+	}
+}
+
+// Accepts checks if message of this type should be queued
+func (o *Outbox) Accepts(msg *cot.CotMessage) bool {
+	if o == nil || msg.IsPing() || msg.IsControl() {
+		return false
+	}
+
+	return cot.MatchAnyPattern(msg.GetType(), o.types...)
+}
+
+// Put queues message for uid, oldest messages are removed when queue is full
+func (o *Outbox) Put(uid string, msg *cot.CotMessage) error {
+	if o == nil || o.db == nil {
+		return nil
+	}
+
+	data, err := proto.Marshal(msg.GetTakMessage())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	item := &model.OutboxItem{
+		UID:       uid,
+		Created:   now,
+		Expires:   now.Add(o.ttl),
+		From:      msg.From,
+		Scope:     msg.Scope,
+		Type:      msg.GetType(),
+		EventData: data,
+	}
+
+	return o.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(item).Error; err != nil {
+			return err
+		}
+
+		if o.maxSize <= 0 {
+			return nil
+		}
+
+		var ids []uint
+
+		tx.Model(&model.OutboxItem{}).Where("uid = ?", uid).
+			Order("id desc").Offset(o.maxSize).Pluck("id", &ids)
+
+		if len(ids) > 0 {
+			o.logger.Warn(fmt.Sprintf("outbox for %s is full, %d messages dropped", uid, len(ids)))
+
+			return tx.Delete(&model.OutboxItem{}, ids).Error
+		}
+
+		return nil
+	})
+}
+
+// Deliver sends not expired messages for uid in order. Message is removed from queue after it is sent,
+// delivery stops at the first send error and the rest is kept for the next try. Returns number of sent messages
+func (o *Outbox) Deliver(uid string, send func(msg *cot.CotMessage) error) int {
+	if o == nil || o.db == nil {
+		return 0
+	}
+
+	var items []*model.OutboxItem
+
+	if err := o.db.Where("uid = ? AND expires > ?", uid, time.Now()).Order("id").Find(&items).Error; err != nil {
+		o.logger.Error("outbox read error", slog.Any("error", err))
+
+		return 0
+	}
+
+	n := 0
+
+	for _, item := range items {
+		msg, err := o.decode(item)
+		if err != nil {
+			o.logger.Error("invalid outbox message", slog.Any("error", err))
+		} else {
+			if err := send(msg); err != nil {
+				o.logger.Warn(fmt.Sprintf("delivery to %s is stopped, %d messages left", uid, len(items)-n), slog.Any("error", err))
+
+				return n
+			}
+
+			n++
+		}
+
+		if err := o.db.Delete(&model.OutboxItem{}, item.ID).Error; err != nil {
+			o.logger.Error("outbox delete error", slog.Any("error", err))
+		}
+	}
+
+	return n
+}
+
+func (o *Outbox) decode(item *model.OutboxItem) (*cot.CotMessage, error) {
+	tak := new(cotproto.TakMessage)
+	if err := proto.Unmarshal(item.EventData, tak); err != nil {
+		return nil, err
+	}
+
+	return cot.CotFromProto(tak, item.From, item.Scope)
+}
+
+// Cleanup removes expired messages
+func (o *Outbox) Cleanup() {
+	if o == nil || o.db == nil {
+		return
+	}
+
+	if res := o.db.Where("expires <= ?", time.Now()).Delete(&model.OutboxItem{}); res.RowsAffected > 0 {
+		o.logger.Info(fmt.Sprintf("%d expired messages removed", res.RowsAffected))
+	}
+}
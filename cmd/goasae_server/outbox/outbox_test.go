@@ -0,0 +1,109 @@
+package outbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/kdudkov/goasae/internal/database/dbtest"
+	"github.com/kdudkov/goasae/pkg/cot"
+)
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func newMsg(typ, uid string) *cot.CotMessage {
+	msg, _ := cot.CotFromProto(cot.BasicMsg(typ, uid, time.Minute), "client1", "s1")
+
+	return msg
+}
+
+func take(o *Outbox, uid string) []*cot.CotMessage {
+	var res []*cot.CotMessage
+
+	o.Deliver(uid, func(msg *cot.CotMessage) error {
+		res = append(res, msg)
+
+		return nil
+	})
+
+	return res
+}
+
+func TestAccepts(t *testing.T) {
+	o := New(nil, time.Hour, 10, nil)
+
+	assert.True(t, o.Accepts(newMsg("b-t-f", "1")))
+	assert.True(t, o.Accepts(newMsg("b-f-t-r", "1")))
+	assert.False(t, o.Accepts(newMsg("a-f-G", "1")))
+	assert.False(t, o.Accepts(newMsg("t-x-c-t", "1")))
+	assert.False(t, o.Accepts(newMsg("t-x-m-c", "1")))
+}
+
+func TestPutTake(t *testing.T) {
+	dbtest.ForEach(t, func(t *testing.T, db *gorm.DB) {
+		o := New(db, time.Hour, 3, nil)
+
+		for _, uid := range []string{"m1", "m2", "m3", "m4"} {
+			require.NoError(t, o.Put("user1", newMsg("b-t-f", uid)))
+		}
+
+		require.NoError(t, o.Put("user2", newMsg("b-t-f", "m5")))
+
+		res := take(o, "user1")
+		require.Len(t, res, 3)
+		assert.Equal(t, "m2", res[0].GetUID())
+		assert.Equal(t, "m3", res[1].GetUID())
+		assert.Equal(t, "m4", res[2].GetUID())
+		assert.Equal(t, "client1", res[0].From)
+		assert.Equal(t, "s1", res[0].Scope)
+
+		assert.Empty(t, take(o, "user1"))
+		assert.Len(t, take(o, "user2"), 1)
+	})
+}
+
+func TestDeliverError(t *testing.T) {
+	dbtest.ForEach(t, func(t *testing.T, db *gorm.DB) {
+		o := New(db, time.Hour, 10, nil)
+
+		for _, uid := range []string{"m1", "m2", "m3"} {
+			require.NoError(t, o.Put("user1", newMsg("b-t-f", uid)))
+		}
+
+		n := o.Deliver("user1", func(msg *cot.CotMessage) error {
+			if msg.GetUID() == "m2" {
+				return errors.New("send error")
+			}
+
+			return nil
+		})
+		assert.Equal(t, 1, n)
+
+		res := take(o, "user1")
+		require.Len(t, res, 2)
+		assert.Equal(t, "m2", res[0].GetUID())
+		assert.Equal(t, "m3", res[1].GetUID())
+	})
+}
+
+func TestExpire(t *testing.T) {
+	dbtest.ForEach(t, func(t *testing.T, db *gorm.DB) {
+		o := New(db, -time.Second, 10, nil)
+
+		require.NoError(t, o.Put("user1", newMsg("b-t-f", "m1")))
+		assert.Empty(t, take(o, "user1"))
+
+		require.NoError(t, o.Put("user1", newMsg("b-t-f", "m2")))
+		o.Cleanup()
+
+		var n int64
+		o.db.Table("outbox_items").Count(&n)
+		assert.Equal(t, int64(0), n)
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/cmd/goasae_server/outbox"
+	"github.com/kdudkov/goasae/pkg/cot"
+)
+
+func TestQueueForManyRecipients(t *testing.T) {
+	app := &App{logger: slog.Default(), outbox: outbox.New(prepare(), time.Hour, 10, nil)}
+
+	msg, err := cot.CotFromProto(cot.BasicMsg("b-t-f", "outbox-many", time.Minute), "client1", "s1")
+	require.NoError(t, err)
+
+	// the same message to every recipient, like mission notifications
+	for _, uid := range []string{"uid1", "uid2", "uid3"} {
+		app.sendToUID(uid, msg)
+	}
+
+	for _, uid := range []string{"uid1", "uid2", "uid3"} {
+		n := app.outbox.Deliver(uid, func(*cot.CotMessage) error { return nil })
+		assert.Equal(t, 1, n, uid)
+	}
+}
@@ -0,0 +1,206 @@
+package packages
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/kdudkov/goasae/internal/database"
+	"github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/internal/pm"
+)
+
+// Index is a database store of package infos with search
+type Index struct {
+	db     *gorm.DB
+	logger *slog.Logger
+}
+
+// Query is a package search filter. Empty fields are ignored, nil Scopes means any scope.
+// Sort is name, time or size, zero Limit means no limit
+type Query struct {
+	Scopes         []string
+	Text           string
+	Name           string
+	CreatorUID     string
+	SubmissionUser string
+	Keyword        string
+	Tool           string
+	MIMEType       string
+	MinSize        int64
+	MaxSize        int64
+	After          time.Time
+	Before         time.Time
+	BBox           *pm.Bounds
+	Sort           string
+	Desc           bool
+	Limit          int
+	Offset         int
+}
+
+func New(db *gorm.DB) *Index {
+	return &Index{
+		db:     db,
+		logger: slog.Default().With("logger", "PackageIndex"),
+	}
+}
+
+func (i *Index) Put(pi *pm.PackageInfo) error {
+	return i.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(model.NewPackageRecord(pi)).Error
+}
+
+func (i *Index) Delete(uid string) error {
+	return i.db.Where("uid = ?", uid).Delete(&model.PackageRecord{}).Error
+}
+
+func (i *Index) Get(uid string) *pm.PackageInfo {
+	var r *model.PackageRecord
+
+	if err := i.db.Select("info").Take(&r, "uid = ?", uid).Error; err != nil {
+		return nil
+	}
+
+	return r.Info
+}
+
+func (i *Index) List(filter func(pi *pm.PackageInfo) bool) []*pm.PackageInfo {
+	var records []*model.PackageRecord
+
+	if err := i.db.Select("info").Order("uid").Find(&records).Error; err != nil {
+		i.logger.Error("list error", slog.Any("error", err))
+
+		return nil
+	}
+
+	var res []*pm.PackageInfo
+
+	for _, r := range records {
+		if r.Info != nil && (filter == nil || filter(r.Info)) {
+			res = append(res, r.Info)
+		}
+	}
+
+	return res
+}
+
+// Search returns packages matching the query and total number of matching packages
+func (i *Index) Search(q *Query) ([]*pm.PackageInfo, int64, error) {
+	tx := i.db.Model(&model.PackageRecord{})
+
+	if q.Scopes != nil {
+		tx = tx.Where("scope IN ?", q.Scopes)
+	}
+
+	if q.Text != "" {
+		tx = i.textFilter(tx, q.Text)
+	}
+
+	if q.Name != "" {
+		tx = tx.Where("LOWER(name) LIKE ? ESCAPE '\\'", like(q.Name))
+	}
+
+	for col, v := range map[string]string{
+		"creator_uid":     q.CreatorUID,
+		"submission_user": q.SubmissionUser,
+		"tool":            q.Tool,
+	} {
+		if v != "" {
+			tx = tx.Where(col+" = ?", v)
+		}
+	}
+
+	if q.Keyword != "" {
+		tx = tx.Where("keywords LIKE ? ESCAPE '\\'", "%,"+escapeLike(q.Keyword)+",%")
+	}
+
+	if prefix, ok := strings.CutSuffix(q.MIMEType, "*"); ok {
+		tx = tx.Where("mime_type LIKE ? ESCAPE '\\'", escapeLike(prefix)+"%")
+	} else if q.MIMEType != "" {
+		tx = tx.Where("mime_type = ?", q.MIMEType)
+	}
+
+	if q.MinSize > 0 {
+		tx = tx.Where("size >= ?", q.MinSize)
+	}
+
+	if q.MaxSize > 0 {
+		tx = tx.Where("size <= ?", q.MaxSize)
+	}
+
+	if !q.After.IsZero() {
+		tx = tx.Where("submitted > ?", q.After)
+	}
+
+	if !q.Before.IsZero() {
+		tx = tx.Where("submitted < ?", q.Before)
+	}
+
+	if b := q.BBox; b != nil {
+		tx = tx.Where("has_bounds = ? AND min_lat <= ? AND max_lat >= ? AND min_lon <= ? AND max_lon >= ?",
+			true, b.MaxLat, b.MinLat, b.MaxLon, b.MinLon)
+	}
+
+	// filtered statement is used for count and select
+	tx = tx.Session(&gorm.Session{})
+
+	var total int64
+
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	order := map[string]string{"name": "LOWER(name)", "size": "size"}[q.Sort]
+	if order == "" {
+		order = "submitted"
+	}
+
+	// newest first by default
+	if q.Desc || (q.Sort == "" && order == "submitted") {
+		order += " DESC"
+	}
+
+	tx = tx.Order(order + ", uid")
+
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit)
+	}
+
+	var records []*model.PackageRecord
+
+	if err := tx.Offset(q.Offset).Select("info").Find(&records).Error; err != nil {
+		return nil, 0, err
+	}
+
+	res := make([]*pm.PackageInfo, 0, len(records))
+
+	for _, r := range records {
+		if r.Info != nil {
+			res = append(res, r.Info)
+		}
+	}
+
+	return res, total, nil
+}
+
+// textFilter finds substring with fts5 trigram index in sqlite and with pg_trgm index in PostgreSQL.
+// Trigram index can't be used for shorter strings
+func (i *Index) textFilter(tx *gorm.DB, s string) *gorm.DB {
+	if i.db.Dialector.Name() == database.DialectSqlite && utf8.RuneCountInString(s) >= 3 {
+		return tx.Where("rowid IN (SELECT rowid FROM package_search WHERE package_search MATCH ?)",
+			`"`+strings.ReplaceAll(s, `"`, `""`)+`"`)
+	}
+
+	return tx.Where("text LIKE ? ESCAPE '\\'", like(s))
+}
+
+func like(s string) string {
+	return "%" + escapeLike(strings.ToLower(s)) + "%"
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
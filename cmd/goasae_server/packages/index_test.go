@@ -0,0 +1,111 @@
+package packages
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/kdudkov/goasae/internal/database/dbtest"
+	"github.com/kdudkov/goasae/internal/pm"
+)
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func uids(list []*pm.PackageInfo) []string {
+	res := make([]string, len(list))
+
+	for i, pi := range list {
+		res[i] = pi.UID
+	}
+
+	return res
+}
+
+func TestSearch(t *testing.T) {
+	dbtest.ForEach(t, func(t *testing.T, db *gorm.DB) {
+		dir := t.TempDir()
+		now := time.Now()
+
+		old := pm.NewPackageManager(dir)
+		require.NoError(t, old.Start())
+
+		// package stored in yml file before index exists
+		require.NoError(t, old.SaveFile(&pm.PackageInfo{UID: "old", Name: "old_100%.txt", Scope: "s1", SubmissionDateTime: now.Add(-time.Hour * 48)}, bytes.NewReader([]byte("old"))))
+
+		idx := New(db)
+		files := pm.NewPackageManager(dir)
+		files.SetInfoStore(idx)
+		require.NoError(t, files.Start())
+		assert.NoFileExists(t, filepath.Join(dir, "old.yml"))
+
+		add := func(pi *pm.PackageInfo, size int) {
+			require.NoError(t, files.SaveFile(pi, bytes.NewReader(bytes.Repeat([]byte(pi.UID), size))))
+		}
+
+		add(&pm.PackageInfo{UID: "p1", Name: "Recon.zip", Scope: "s1", CreatorUID: "c1", SubmissionUser: "user1",
+			Keywords: []string{"missionpackage"}, Tool: "public", MIMEType: "application/zip", SubmissionDateTime: now.Add(-time.Hour)}, 10)
+		add(&pm.PackageInfo{UID: "p2", Name: "photo.jpg", Scope: "s1", CreatorUID: "c2", SubmissionUser: "user2",
+			MIMEType: "image/jpeg", SubmissionDateTime: now.Add(-time.Minute)}, 100)
+		add(&pm.PackageInfo{UID: "p3", Name: "other.zip", Scope: "s2", CreatorUID: "c1", SubmissionUser: "user1",
+			Keywords: []string{"missionpackage_x"}, SubmissionDateTime: now}, 1)
+
+		pi := files.Get("p2")
+		pi.Metadata = &pm.Metadata{Items: []*pm.CotItem{{UID: "point-1", Callsign: "Target"}},
+			Bounds: &pm.Bounds{MinLat: 60, MinLon: 30, MaxLat: 60, MaxLon: 30}}
+		files.Store(pi)
+
+		// package infos are kept in database
+		files = pm.NewPackageManager(dir)
+		files.SetInfoStore(New(db))
+		require.NoError(t, files.Start())
+		assert.Equal(t, "Target", files.Get("p2").Metadata.Items[0].Callsign)
+		assert.Len(t, files.GetList(nil), 4)
+
+		search := func(q *Query) []string {
+			res, _, err := idx.Search(q)
+			require.NoError(t, err)
+
+			return uids(res)
+		}
+
+		assert.Equal(t, []string{"p3", "p2", "p1", "old"}, search(&Query{}))
+		assert.Equal(t, []string{"p2", "p1", "old"}, search(&Query{Scopes: []string{"s1"}}))
+		assert.Equal(t, []string{"p1"}, search(&Query{Text: "RECON"}))
+		assert.Equal(t, []string{"p2"}, search(&Query{Text: "target"}))
+		assert.Equal(t, []string{"p3", "p1"}, search(&Query{Text: "c1"}))
+		assert.Equal(t, []string{"p2"}, search(&Query{Text: "point-1"}))
+		assert.Empty(t, search(&Query{Text: `"point-1" OR`}))
+		assert.Equal(t, []string{"old"}, search(&Query{Name: "_100%"}))
+		assert.Equal(t, []string{"p3", "p1"}, search(&Query{CreatorUID: "c1"}))
+		assert.Equal(t, []string{"p2"}, search(&Query{SubmissionUser: "user2"}))
+		assert.Equal(t, []string{"p1"}, search(&Query{Keyword: "missionpackage"}))
+		assert.Equal(t, []string{"p2"}, search(&Query{MIMEType: "image/*"}))
+		assert.Equal(t, []string{"p1"}, search(&Query{MIMEType: "application/zip"}))
+		assert.Equal(t, []string{"p1"}, search(&Query{MinSize: 10, MaxSize: 50}))
+		assert.Equal(t, []string{"p2", "p1"}, search(&Query{After: now.Add(-time.Hour * 2), Before: now.Add(-time.Second)}))
+		assert.Equal(t, []string{"p2"}, search(&Query{BBox: &pm.Bounds{MinLat: 59, MinLon: 29, MaxLat: 61, MaxLon: 31}}))
+		assert.Empty(t, search(&Query{BBox: &pm.Bounds{MinLat: 50, MinLon: 29, MaxLat: 51, MaxLon: 31}}))
+
+		assert.Equal(t, []string{"old", "p3", "p2", "p1"}, search(&Query{Sort: "name"}))
+		assert.Equal(t, []string{"p2", "p1", "old", "p3"}, search(&Query{Sort: "size", Desc: true}))
+
+		res, total, err := idx.Search(&Query{Limit: 2, Offset: 1})
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), total)
+		assert.Equal(t, []string{"p2", "p1"}, uids(res))
+
+		res, _, err = idx.Search(&Query{Offset: 3})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"old"}, uids(res))
+
+		files.Delete("p1")
+		assert.Equal(t, []string{"p3", "p2", "old"}, search(&Query{}))
+	})
+}
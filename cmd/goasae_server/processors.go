@@ -12,6 +12,8 @@ import (
 	"github.com/spf13/viper"
 	"google.golang.org/protobuf/proto"
 
+	"github.com/kdudkov/goasae/internal/client"
+	im "github.com/kdudkov/goasae/internal/model"
 	"github.com/kdudkov/goasae/pkg/cot"
 	"github.com/kdudkov/goasae/pkg/model"
 )
@@ -35,11 +37,20 @@ func (app *App) InitMessageProcessors() {
 		app.AddEventProcessor("file_logger", app.fileLoggerProcessor, ".-")
 	}
 
+	if app.rules != nil {
+		app.AddEventProcessor("rules", app.rules.Process, ".-")
+	}
+
 	app.AddEventProcessor("metrics", app.metricsProcessor, "t-x-c-m")
 	app.AddEventProcessor("remove", app.removeItemProcessor, "t-x-d-d")
 	app.AddEventProcessor("chat", app.chatProcessor, "b-t-f")
+	app.AddEventProcessor("chat_receipt", app.chatReceiptProcessor, "b-t-f-d", "b-t-f-r")
+	app.AddEventProcessor("file_ack", app.fileAckProcessor, "b-f-t-a")
 	app.AddEventProcessor("items", app.saveItemProcessor, "a-", "b-", "u-")
+	app.AddEventProcessor("mission_aoi", app.missionAoiProcessor, "a-", "b-", "u-")
 	app.AddEventProcessor("filter_control", filterProcessor, "t-")
+	app.AddEventProcessor("emergency", app.emergencyProcessor, "b-a-o-")
+	app.AddEventProcessor("mission_chat", app.missionChatProcessor, "b-t-f")
 
 	app.AddEventProcessor("router", app.route, ".-")
 }
@@ -83,6 +94,7 @@ func (app *App) removeItemProcessor(msg *cot.CotMessage) bool {
 			case model.UNIT, model.POINT:
 				app.logger.Debug(fmt.Sprintf("remove unit/point %s type %s by message", uid, typ))
 				app.items.Remove(uid)
+				app.removeDrawingFence(uid)
 				app.deleteCb.AddMessage(uid)
 			}
 		}
@@ -105,14 +117,58 @@ func (app *App) chatProcessor(msg *cot.CotMessage) bool {
 
 	app.logger.Info("Chat " + c.String())
 
-	app.messages = append(app.messages, c)
-	if err := logChatMessage(c); err != nil {
-		app.logger.Warn("error logging chat", slog.Any("error", err))
+	if c.FromUID == WELCOME_MESSAGE_FROM_UID {
+		return true
+	}
+
+	stored := im.ChatFromModel(msg.Scope, c)
+
+	if m := app.missionForChat(msg.Scope, c); m != nil {
+		// message is dropped by mission chat processor
+		if !app.canChat(m, msg) {
+			return true
+		}
+
+		stored.MissionID = m.ID
+	}
+
+	if err := app.chats.Add(stored); err != nil {
+		app.logger.Warn("error saving chat", slog.Any("error", err))
+	}
+
+	// message to online contact is delivered by router
+	if c.Direct && app.isOnline(c.ToUID) {
+		app.chats.SetDelivered(c.ID, time.Now())
+	}
+
+	return true
+}
+
+// chatReceiptProcessor handles delivered (b-t-f-d) and read (b-t-f-r) chat receipts
+func (app *App) chatReceiptProcessor(msg *cot.CotMessage) bool {
+	id := model.ChatReceiptID(msg)
+
+	if msg.GetType() == "b-t-f-r" {
+		app.chats.SetRead(id, time.Now())
+	} else {
+		app.chats.SetDelivered(id, time.Now())
 	}
 
 	return true
 }
 
+func (app *App) isOnline(uid string) bool {
+	online := false
+
+	app.ForAllClients(func(ch client.ClientHandler) bool {
+		online = ch.HasUID(uid)
+
+		return !online
+	})
+
+	return online
+}
+
 func (app *App) saveItemProcessor(msg *cot.CotMessage) bool {
 	if !msg.IsMapItem() {
 		return true
@@ -124,12 +180,20 @@ func (app *App) saveItemProcessor(msg *cot.CotMessage) bool {
 		c.Update(msg)
 		app.items.Store(c)
 		app.changeCb.AddMessage(c)
+
+		if cl == model.CONTACT || cl == model.UNIT {
+			app.checkGeofences(c)
+		}
 	} else {
 		app.logger.Info(fmt.Sprintf("new %s %s (%s) %s", cl, msg.GetUID(), msg.GetCallsign(), msg.GetType()))
 		item := model.FromMsg(msg)
 		app.items.Store(item)
 		app.changeCb.AddMessage(item)
 
+		if cl == model.CONTACT || cl == model.UNIT {
+			app.checkGeofences(item)
+		}
+
 		if cl == model.CONTACT && viper.GetString("welcome_msg") != "" {
 			chat := &model.ChatMessage{
 				ID:       uuid.NewString(),
@@ -147,6 +211,8 @@ func (app *App) saveItemProcessor(msg *cot.CotMessage) bool {
 		}
 	}
 
+	app.updateDrawingFence(msg)
+
 	return true
 }
 
@@ -217,19 +283,3 @@ func logMessage(msg *cot.CotMessage, dir string) error {
 
 	return nil
 }
-
-func logChatMessage(c *model.ChatMessage) error {
-	if c.FromUID == WELCOME_MESSAGE_FROM_UID {
-		return nil
-	}
-
-	fd, err := os.OpenFile("msg.log", os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
-	if err != nil {
-		return nil
-	}
-
-	defer fd.Close()
-	_, err = fmt.Fprintf(fd, "%s %s (%s) -> %s (%s) \"%s\"\n", c.Time, c.From, c.FromUID, c.Chatroom, c.ToUID, c.Text)
-
-	return err
-}
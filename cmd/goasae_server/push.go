@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/kdudkov/goasae/internal/client"
+	im "github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/internal/pm"
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+// PushRequest selects file by hash and contacts to send it to. Contact matching any of the lists is a recipient
+type PushRequest struct {
+	Hash      string   `json:"hash"`
+	Name      string   `json:"name,omitempty"`
+	BaseURL   string   `json:"base_url,omitempty"`
+	Callsigns []string `json:"callsigns,omitempty"`
+	UIDs      []string `json:"uids,omitempty"`
+	Teams     []string `json:"teams,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+}
+
+func (r *PushRequest) matches(item *model.Item) bool {
+	return slices.Contains(r.UIDs, item.GetUID()) ||
+		slices.Contains(r.Callsigns, item.GetCallsign()) ||
+		slices.Contains(r.Teams, item.GetMsg().GetTeam()) ||
+		slices.Contains(r.Scopes, item.GetScope())
+}
+
+func (app *App) pushRecipients(r *PushRequest) []*model.Item {
+	res := make([]*model.Item, 0)
+
+	app.items.ForEach(func(item *model.Item) bool {
+		if item.GetClass() == model.CONTACT && r.matches(item) {
+			res = append(res, item)
+		}
+
+		return true
+	})
+
+	return res
+}
+
+// pushFile sends file transfer message to contacts, offline contacts get it from outbox
+func (app *App) pushFile(pi *pm.PackageInfo, name, baseURL string, dest []*model.Item, by string) (*im.Transfer, error) {
+	t := &im.Transfer{
+		ID:        uuid.NewString(),
+		Created:   time.Now(),
+		Hash:      pi.Hash,
+		Name:      name,
+		Size:      int64(pi.Size),
+		CreatedBy: by,
+	}
+
+	uids := make([]string, 0, len(dest))
+
+	for _, item := range dest {
+		t.Recipients = append(t.Recipients, &im.TransferRecipient{UID: item.GetUID(), Callsign: item.GetCallsign()})
+		uids = append(uids, item.GetUID())
+	}
+
+	if err := app.transfers.Add(t); err != nil {
+		return nil, err
+	}
+
+	msg := cot.LocalCotMessage(model.MakeFileShare(&model.FileShare{
+		ID:             t.ID,
+		Filename:       pi.Name,
+		Name:           name,
+		URL:            baseURL + "/Marti/sync/content?hash=" + pi.Hash,
+		Size:           t.Size,
+		Hash:           pi.Hash,
+		SenderUID:      app.uid,
+		SenderCallsign: "Server",
+		DestUIDs:       uids,
+	}))
+
+	// recipients connected now get the message from router, others get it from outbox
+	for _, r := range t.Recipients {
+		if login, ok := app.clientLogin(r.UID); ok {
+			now := time.Now()
+			r.Sent, r.Login = &now, login
+			app.transfers.SetSent(t.ID, r.UID, login, now)
+		}
+	}
+
+	app.NewCotMessage(msg)
+
+	app.logger.Info(fmt.Sprintf("file %s is pushed to %d contacts", name, len(t.Recipients)))
+
+	return t, nil
+}
+
+// clientLogin returns login of the user of connected contact and true if contact is online
+func (app *App) clientLogin(uid string) (string, bool) {
+	var handler client.ClientHandler
+
+	app.ForAllClients(func(ch client.ClientHandler) bool {
+		if ch.HasUID(uid) {
+			handler = ch
+
+			return false
+		}
+
+		return true
+	})
+
+	if handler == nil {
+		return "", false
+	}
+
+	return handler.GetUser().GetLogin(), true
+}
+
+// fileAckProcessor handles file transfer receipts (b-f-t-a), receipts of server transfers are not routed
+func (app *App) fileAckProcessor(msg *cot.CotMessage) bool {
+	id, uid := model.FileShareAck(msg)
+	if id == "" || uid == "" {
+		return true
+	}
+
+	if app.transfers.Get(id) == nil {
+		return true
+	}
+
+	if app.transfers.SetAcked(id, uid, time.Now()) {
+		app.logger.Info(fmt.Sprintf("transfer %s is received by %s", id, uid))
+	}
+
+	return false
+}
+
+// serveDownload sends file, transfers of the file to the user are marked as downloaded when the last byte is sent
+func (app *App) serveDownload(ctx *fiber.Ctx, f io.ReadSeekCloser, c *content) error {
+	if ctx.Method() == fiber.MethodGet {
+		username := Username(ctx)
+
+		c.onSent = func() {
+			app.transfers.SetDownloaded(c.hash, username, time.Now())
+		}
+	}
+
+	return serveContent(ctx, f, c)
+}
+
+// martiBaseURL makes url of marti api on the host admin api is called at
+func (app *App) martiBaseURL(host string) string {
+	scheme := "http"
+	if app.config.useSsl {
+		scheme = "https"
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if _, port, err := net.SplitHostPort(app.config.apiAddr); err == nil && port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	return scheme + "://" + host
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/cmd/goasae_server/outbox"
+	"github.com/kdudkov/goasae/cmd/goasae_server/transfers"
+	im "github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/internal/pm"
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+func TestMartiBaseURL(t *testing.T) {
+	app := &App{config: &AppConfig{apiAddr: ":8080"}}
+
+	assert.Equal(t, "http://server.local:8080", app.martiBaseURL("server.local:8443"))
+	assert.Equal(t, "http://10.0.0.1:8080", app.martiBaseURL("10.0.0.1"))
+
+	app.config.useSsl = true
+	assert.Equal(t, "https://[::1]:8080", app.martiBaseURL("[::1]:8443"))
+}
+
+func TestPushFile(t *testing.T) {
+	db := prepare()
+
+	app := &App{
+		logger:    slog.Default(),
+		ch:        make(chan *cot.CotMessage, 10),
+		outbox:    outbox.New(db, time.Hour, 10, nil),
+		transfers: transfers.New(db),
+	}
+
+	a := &fakeClient{name: "client_a", uid: "uid_a", user: &im.User{Login: "a", Scope: "s1"}}
+	app.AddClientHandler(a)
+
+	dest := []*model.Item{
+		model.FromMsg(newCotMessage("s1", "uid_a", 10, 20)),
+		model.FromMsg(newCotMessage("s1", "uid_b", 10, 20)),
+	}
+
+	tr, err := app.pushFile(&pm.PackageInfo{Hash: "abc", Name: "file.kmz", Size: 100}, "file", "http://localhost:8080", dest, "admin")
+	require.NoError(t, err)
+	require.Len(t, tr.Recipients, 2)
+	assert.NotNil(t, tr.Recipients[0].Sent)
+	assert.Equal(t, "a", tr.Recipients[0].Login)
+	assert.Nil(t, tr.Recipients[1].Sent)
+
+	// message goes through processors and router
+	require.Len(t, app.ch, 1)
+	msg := <-app.ch
+	assert.Equal(t, "b-f-t-r", msg.GetType())
+	assert.Equal(t, []string{"uid_a", "uid_b"}, msg.GetDetail().GetDestUID())
+	assert.Empty(t, a.Sent())
+
+	app.route(msg)
+	assert.Len(t, a.Sent(), 1)
+	assert.Equal(t, 1, app.outbox.Deliver("uid_b", func(*cot.CotMessage) error { return nil }))
+}
@@ -0,0 +1,230 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+
+	"github.com/kdudkov/goasae/pkg/cot"
+)
+
+const (
+	webhookTimeout = time.Second * 5
+	// maxWebhooks is the number of webhooks sent at the same time, webhooks over it are dropped
+	maxWebhooks = 16
+)
+
+type Engine struct {
+	logger  *slog.Logger
+	mx      sync.RWMutex
+	static  []*Rule
+	rules   []*Rule
+	file    string
+	watcher *fsnotify.Watcher
+	client  *http.Client
+	hooks   chan struct{}
+}
+
+// New creates engine with rules from server config. Rules from file (if any) are evaluated after them.
+func New(static []*Rule, file string) (*Engine, error) {
+	e := &Engine{
+		logger: slog.Default().With("logger", "rules"),
+		file:   file,
+		client: &http.Client{Timeout: webhookTimeout},
+		hooks:  make(chan struct{}, maxWebhooks),
+	}
+
+	for _, r := range static {
+		if err := r.Compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	e.static = static
+	e.rules = static
+
+	if file != "" {
+		if err := e.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+func LoadRules(file string) ([]*Rule, error) {
+	dat, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*Rule
+
+	if err := yaml.Unmarshal(dat, &rules); err != nil {
+		return nil, err
+	}
+
+	for _, r := range rules {
+		if err := r.Compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return rules, nil
+}
+
+func (e *Engine) load() error {
+	rules, err := LoadRules(e.file)
+	if err != nil {
+		return err
+	}
+
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	e.rules = append(append(make([]*Rule, 0, len(e.static)+len(rules)), e.static...), rules...)
+	e.logger.Info(fmt.Sprintf("loaded %d rules from %s", len(rules), e.file))
+
+	return nil
+}
+
+// Start watches rules file and reloads it on change
+func (e *Engine) Start() error {
+	if e.file == "" {
+		return nil
+	}
+
+	var err error
+
+	if e.watcher, err = fsnotify.NewWatcher(); err != nil {
+		return err
+	}
+
+	// watch dir, editors often replace file instead of writing it
+	if err := e.watcher.Add(filepath.Dir(e.file)); err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-e.watcher.Events:
+				if !ok {
+					return
+				}
+
+				if filepath.Clean(event.Name) == filepath.Clean(e.file) && event.Has(fsnotify.Write|fsnotify.Create) {
+					e.logger.Info("rules file is modified, reloading")
+
+					// keep old rules if new ones are broken
+					if err := e.load(); err != nil {
+						e.logger.Error("error loading rules", slog.Any("error", err))
+					}
+				}
+			case err, ok := <-e.watcher.Errors:
+				if !ok {
+					return
+				}
+
+				e.logger.Error("error", slog.Any("error", err))
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (e *Engine) Stop() {
+	if e.watcher != nil {
+		_ = e.watcher.Close()
+	}
+}
+
+func (e *Engine) GetRules() []*Rule {
+	e.mx.RLock()
+	defer e.mx.RUnlock()
+
+	return e.rules
+}
+
+// Process applies all matching rules to message. Returns false if message must be dropped.
+func (e *Engine) Process(msg *cot.CotMessage) bool {
+	if e == nil {
+		return true
+	}
+
+	for _, r := range e.GetRules() {
+		if !r.Matches(msg) {
+			continue
+		}
+
+		e.logger.Debug(fmt.Sprintf("rule %s matched %s %s", r.Name, msg.GetType(), msg.GetUID()))
+
+		if r.Actions.Webhook != "" {
+			e.sendWebhook(r, msg)
+		}
+
+		if !r.apply(msg) {
+			return false
+		}
+
+		if r.Final {
+			break
+		}
+	}
+
+	return true
+}
+
+func (e *Engine) sendWebhook(r *Rule, msg *cot.CotMessage) {
+	body, err := json.Marshal(map[string]any{
+		"rule":     r.Name,
+		"uid":      msg.GetUID(),
+		"type":     msg.GetType(),
+		"callsign": msg.GetCallsign(),
+		"scope":    msg.Scope,
+		"lat":      msg.GetLat(),
+		"lon":      msg.GetLon(),
+		"detail":   msg.GetDetail().AsXMLString(),
+	})
+
+	if err != nil {
+		e.logger.Error("webhook marshal error", slog.Any("error", err))
+
+		return
+	}
+
+	select {
+	case e.hooks <- struct{}{}:
+	default:
+		e.logger.Warn("too many webhooks in progress, dropped", slog.String("rule", r.Name))
+
+		return
+	}
+
+	go func() {
+		defer func() { <-e.hooks }()
+
+		resp, err := e.client.Post(r.Actions.Webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			e.logger.Warn("webhook error", slog.String("rule", r.Name), slog.Any("error", err))
+
+			return
+		}
+
+		_ = resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			e.logger.Warn(fmt.Sprintf("webhook %s returned %d", r.Actions.Webhook, resp.StatusCode))
+		}
+	}()
+}
@@ -0,0 +1,223 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+type Rule struct {
+	Name    string  `yaml:"name"    mapstructure:"name"`
+	Match   Match   `yaml:"match"   mapstructure:"match"`
+	Actions Actions `yaml:"actions" mapstructure:"actions"`
+	// stop rule evaluation after this rule matched
+	Final bool `yaml:"final" mapstructure:"final"`
+
+	callsign *regexp.Regexp
+	detail   []*selector
+}
+
+type Match struct {
+	Types    []string          `yaml:"types"    mapstructure:"types"`
+	Scope    string            `yaml:"scope"    mapstructure:"scope"`
+	Callsign string            `yaml:"callsign" mapstructure:"callsign"`
+	Area     *Area             `yaml:"area"     mapstructure:"area"`
+	Detail   map[string]string `yaml:"detail"   mapstructure:"detail"`
+}
+
+// Area is a circle (lat, lon, radius in meters) or polygon given as list of [lat, lon] pairs
+type Area struct {
+	Lat     float64     `yaml:"lat"     mapstructure:"lat"`
+	Lon     float64     `yaml:"lon"     mapstructure:"lon"`
+	Radius  float64     `yaml:"radius"  mapstructure:"radius"`
+	Polygon [][]float64 `yaml:"polygon" mapstructure:"polygon"`
+
+	poly []*model.Pos
+}
+
+type Actions struct {
+	Drop         bool     `yaml:"drop"          mapstructure:"drop"`
+	SetType      string   `yaml:"set_type"      mapstructure:"set_type"`
+	AddDetail    string   `yaml:"add_detail"    mapstructure:"add_detail"`
+	StripDetail  []string `yaml:"strip_detail"  mapstructure:"strip_detail"`
+	DestCallsign []string `yaml:"dest_callsign" mapstructure:"dest_callsign"`
+	DestMission  []string `yaml:"dest_mission"  mapstructure:"dest_mission"`
+	Webhook      string   `yaml:"webhook"       mapstructure:"webhook"`
+}
+
+// selector is a detail path with optional attribute, like "__group@role" or "remarks".
+// Value is checked against regexp, empty regexp means node (or attribute) must exist.
+type selector struct {
+	path string
+	attr string
+	re   *regexp.Regexp
+}
+
+func (r *Rule) Compile() error {
+	if r.Match.Callsign != "" {
+		re, err := regexp.Compile(r.Match.Callsign)
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid callsign regexp: %w", r.Name, err)
+		}
+
+		r.callsign = re
+	}
+
+	r.detail = nil
+
+	for k, v := range r.Match.Detail {
+		s := &selector{path: k}
+
+		if i := strings.Index(k, "@"); i >= 0 {
+			s.path, s.attr = k[:i], k[i+1:]
+		}
+
+		if v != "" {
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return fmt.Errorf("rule %s: invalid regexp for %s: %w", r.Name, k, err)
+			}
+
+			s.re = re
+		}
+
+		r.detail = append(r.detail, s)
+	}
+
+	if a := r.Match.Area; a != nil {
+		a.poly = nil
+
+		for _, p := range a.Polygon {
+			if len(p) != 2 {
+				return fmt.Errorf("rule %s: invalid polygon point %v", r.Name, p)
+			}
+
+			a.poly = append(a.poly, model.NewPos(p[0], p[1]))
+		}
+
+		if len(a.poly) == 0 && a.Radius <= 0 {
+			return fmt.Errorf("rule %s: area must have radius or polygon", r.Name)
+		}
+	}
+
+	if r.Actions.AddDetail != "" {
+		if _, err := cot.DetailsFromString(r.Actions.AddDetail); err != nil {
+			return fmt.Errorf("rule %s: invalid add_detail: %w", r.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Rule) Matches(msg *cot.CotMessage) bool {
+	if len(r.Match.Types) > 0 && !cot.MatchAnyPattern(msg.GetType(), r.Match.Types...) {
+		return false
+	}
+
+	if r.Match.Scope != "" && r.Match.Scope != msg.Scope {
+		return false
+	}
+
+	if r.callsign != nil && !r.callsign.MatchString(msg.GetCallsign()) {
+		return false
+	}
+
+	if a := r.Match.Area; a != nil && !a.Contains(msg.GetLat(), msg.GetLon()) {
+		return false
+	}
+
+	for _, s := range r.detail {
+		if !s.matches(msg.GetDetail()) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (a *Area) Contains(lat, lon float64) bool {
+	if len(a.poly) > 0 {
+		return model.PointInPolygon(lat, lon, a.poly)
+	}
+
+	return model.PointInCircle(lat, lon, a.Lat, a.Lon, a.Radius)
+}
+
+func (s *selector) matches(n *cot.Node) bool {
+	for _, nn := range n.GetByPath(s.path) {
+		var val string
+
+		if s.attr != "" {
+			attrs := nn.GetAttrs()
+
+			v, ok := attrs[s.attr]
+			if !ok {
+				continue
+			}
+
+			val = v
+		} else {
+			val = nn.GetText()
+		}
+
+		if s.re == nil || s.re.MatchString(val) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apply changes message in place and returns false if message must be dropped
+func (r *Rule) apply(msg *cot.CotMessage) bool {
+	if r.Actions.Drop {
+		return false
+	}
+
+	changed := false
+
+	if t := r.Actions.SetType; t != "" && msg.GetTakMessage().GetCotEvent() != nil {
+		msg.TakMessage.CotEvent.Type = t
+	}
+
+	if msg.Detail == nil {
+		msg.Detail = cot.NewXMLDetails()
+	}
+
+	if len(r.Actions.StripDetail) > 0 {
+		msg.Detail.RemoveTags(r.Actions.StripDetail...)
+		changed = true
+	}
+
+	if r.Actions.AddDetail != "" {
+		// parse every time to not share nodes between messages
+		if d, err := cot.DetailsFromString(r.Actions.AddDetail); err == nil {
+			msg.Detail.Nodes = append(msg.Detail.Nodes, d.Nodes...)
+			changed = true
+		}
+	}
+
+	if len(r.Actions.DestCallsign) > 0 || len(r.Actions.DestMission) > 0 {
+		msg.Detail.RemoveTags("marti")
+		marti := msg.Detail.AddChild("marti", nil, "")
+
+		for _, c := range r.Actions.DestCallsign {
+			marti.AddChild("dest", map[string]string{"callsign": c}, "")
+		}
+
+		for _, m := range r.Actions.DestMission {
+			marti.AddChild("dest", map[string]string{"mission": m}, "")
+		}
+
+		changed = true
+	}
+
+	if changed {
+		msg.GetUpdatedTakMessage()
+	}
+
+	return true
+}
@@ -0,0 +1,102 @@
+package rules
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/cotproto"
+)
+
+func TestRules(t *testing.T) {
+	rules := []*Rule{
+		{
+			Name:    "drop_test",
+			Match:   Match{Types: []string{"a-h-"}, Callsign: "^test"},
+			Actions: Actions{Drop: true},
+		},
+		{
+			Name:    "rewrite",
+			Match:   Match{Types: []string{"a-u-G"}, Area: &Area{Lat: 60, Lon: 30, Radius: 1000}},
+			Actions: Actions{SetType: "a-h-G", DestCallsign: []string{"cs1"}},
+			Final:   true,
+		},
+		{
+			Name:    "strip",
+			Match:   Match{Detail: map[string]string{"__group@name": "Cyan"}},
+			Actions: Actions{StripDetail: []string{"remarks"}, AddDetail: `<foo bar="1"/>`},
+		},
+	}
+
+	e, err := New(rules, "")
+	require.NoError(t, err)
+
+	assert.False(t, e.Process(newMsg("a-h-G", "test1", 0, 0, "")))
+	assert.True(t, e.Process(newMsg("a-h-G", "aaa", 0, 0, "")))
+
+	msg := newMsg("a-u-G", "aaa", 60.001, 30.001, "")
+	assert.True(t, e.Process(msg))
+	assert.Equal(t, "a-h-G", msg.GetType())
+	assert.Equal(t, []string{"cs1"}, msg.GetDetail().GetDestCallsign())
+	assert.Contains(t, msg.GetTakMessage().GetCotEvent().GetDetail().GetXmlDetail(), "cs1")
+
+	msg = newMsg("a-u-G", "aaa", 61, 30, "")
+	assert.True(t, e.Process(msg))
+	assert.Equal(t, "a-u-G", msg.GetType())
+
+	msg = newMsg("a-f-G", "aaa", 0, 0, `<__group name="Cyan" role="Team Member"/><remarks>text</remarks>`)
+	assert.True(t, e.Process(msg))
+	assert.False(t, msg.GetDetail().Has("remarks"))
+	assert.Equal(t, "1", msg.GetDetail().GetFirst("foo").GetAttr("bar"))
+}
+
+func TestPolygon(t *testing.T) {
+	r := &Rule{Name: "poly", Match: Match{Area: &Area{Polygon: [][]float64{{0, 0}, {0, 10}, {10, 10}, {10, 0}}}}}
+	require.NoError(t, r.Compile())
+
+	assert.True(t, r.Matches(newMsg("a-f-G", "aaa", 5, 5, "")))
+	assert.False(t, r.Matches(newMsg("a-f-G", "aaa", 15, 5, "")))
+
+	r = &Rule{Name: "bad", Match: Match{Area: &Area{}}}
+	require.Error(t, r.Compile())
+}
+
+func TestWebhookLimit(t *testing.T) {
+	var calls atomic.Int32
+
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		<-block
+	}))
+
+	defer srv.Close()
+	defer close(block)
+
+	e, err := New([]*Rule{{Name: "hook", Actions: Actions{Webhook: srv.URL}}}, "")
+	require.NoError(t, err)
+
+	for range maxWebhooks * 2 {
+		assert.True(t, e.Process(newMsg("a-f-G", "aaa", 0, 0, "")))
+	}
+
+	assert.Eventually(t, func() bool { return calls.Load() == maxWebhooks }, time.Second*5, time.Millisecond*10)
+	assert.Len(t, e.hooks, maxWebhooks)
+}
+
+func newMsg(typ, callsign string, lat, lon float64, detail string) *cot.CotMessage {
+	tak := cot.BasicMsg(typ, "uid1", time.Minute)
+	tak.CotEvent.Lat = lat
+	tak.CotEvent.Lon = lon
+	tak.CotEvent.Detail = &cotproto.Detail{XmlDetail: detail, Contact: &cotproto.Contact{Callsign: callsign}}
+
+	d, _ := cot.DetailsFromString(detail)
+
+	return &cot.CotMessage{TakMessage: tak, Detail: d, Scope: "test"}
+}
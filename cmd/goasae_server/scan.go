@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kdudkov/goasae/internal/pm"
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+const SCANNER_MESSAGE_FROM_UID = "SCANNER_UID"
+
+// scanUpload checks file with configured scanner before it is stored. Result is saved in pi,
+// infected file is quarantined and uploader is notified. open must return a new reader on every call
+func (app *App) scanUpload(pi *pm.PackageInfo, open func() (io.ReadCloser, error)) error {
+	if app.scanner == nil {
+		return nil
+	}
+
+	r, err := open()
+	if err != nil {
+		return err
+	}
+
+	res, err := app.scanner.Scan(r)
+	_ = r.Close()
+
+	if err != nil {
+		app.logger.Error("scan error", slog.Any("error", err))
+
+		if app.config.scanFailOpen {
+			return nil
+		}
+
+		if !errors.Is(err, pm.ErrScanFailed) {
+			err = fmt.Errorf("%w: %w", pm.ErrScanFailed, err)
+		}
+
+		return err
+	}
+
+	pi.Scan = res
+
+	if res.Clean {
+		return nil
+	}
+
+	app.logger.Warn(fmt.Sprintf("infected upload %s from %s: %s", pi.Name, pi.SubmissionUser, res.Threat))
+	app.audit.Info("upload_infected", slog.String("name", pi.Name), slog.String("threat", res.Threat),
+		slog.String("user", pi.SubmissionUser), slog.String("creator_uid", pi.CreatorUID))
+
+	if err := app.quarantineUpload(pi, open); err != nil {
+		app.logger.Error("quarantine error", slog.Any("error", err))
+	}
+
+	app.notifyInfected(pi)
+
+	return fmt.Errorf("%w: %s", pm.ErrInfected, res.Threat)
+}
+
+// spool copies r to temp file and returns the file positioned at its start and its size
+func spool(r io.Reader) (*os.File, int64, error) {
+	f, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n, err := io.Copy(f, r)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+
+		return nil, 0, err
+	}
+
+	return f, n, nil
+}
+
+func (app *App) quarantineUpload(pi *pm.PackageInfo, open func() (io.ReadCloser, error)) error {
+	r, err := open()
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	// copy keeps uid of the package being replaced untouched
+	q := *pi
+	q.UID = uuid.NewString()
+
+	return app.quarantine.Put(&q, r)
+}
+
+// notifyInfected sends direct chat message to the uploader if it is online
+func (app *App) notifyInfected(pi *pm.PackageInfo) {
+	if pi.CreatorUID == "" {
+		return
+	}
+
+	dest := app.items.Get(pi.CreatorUID)
+	if dest == nil || dest.GetClass() != model.CONTACT {
+		return
+	}
+
+	chat := &model.ChatMessage{
+		ID:       uuid.NewString(),
+		Time:     time.Now(),
+		Parent:   "RootContactGroup",
+		Chatroom: dest.GetCallsign(),
+		From:     "Scanner",
+		FromUID:  SCANNER_MESSAGE_FROM_UID,
+		ToUID:    dest.GetUID(),
+		Direct:   true,
+		Text:     fmt.Sprintf("file %s is rejected: %s found", pi.Name, pi.Scan.Threat),
+	}
+
+	app.NewCotMessage(cot.LocalCotMessage(model.MakeChatMessage(chat)))
+}
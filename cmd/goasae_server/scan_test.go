@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/internal/pm"
+)
+
+type fakeScanner struct {
+	threat string
+	err    error
+}
+
+func (s *fakeScanner) Scan(r io.Reader) (*pm.ScanResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	_, _ = io.Copy(io.Discard, r)
+
+	return &pm.ScanResult{Time: time.Now(), Scanner: "fake", Clean: s.threat == "", Threat: s.threat}, nil
+}
+
+func TestScanUpload(t *testing.T) {
+	data := []byte("data")
+	open := func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+
+	app := &App{
+		logger:     slog.Default(),
+		audit:      slog.Default(),
+		config:     &AppConfig{},
+		quarantine: pm.NewQuarantine(filepath.Join(t.TempDir(), "quarantine")),
+	}
+
+	pi := &pm.PackageInfo{UID: "uid1", Name: "a.zip"}
+	require.NoError(t, app.scanUpload(pi, open))
+	assert.Nil(t, pi.Scan)
+
+	app.scanner = &fakeScanner{}
+	require.NoError(t, app.scanUpload(pi, open))
+	require.NotNil(t, pi.Scan)
+	assert.True(t, pi.Scan.Clean)
+	assert.Empty(t, app.quarantine.List())
+
+	app.scanner = &fakeScanner{threat: "Eicar-Signature"}
+	err := app.scanUpload(pi, open)
+	require.ErrorIs(t, err, pm.ErrInfected)
+	assert.Equal(t, 422, uploadErrorStatus(err))
+
+	list := app.quarantine.List()
+	require.Len(t, list, 1)
+	assert.Equal(t, "a.zip", list[0].Name)
+	assert.NotEqual(t, "uid1", list[0].UID)
+	assert.Equal(t, "Eicar-Signature", list[0].Scan.Threat)
+
+	app.scanner = &fakeScanner{err: pm.ErrScanFailed}
+	err = app.scanUpload(pi, open)
+	require.ErrorIs(t, err, pm.ErrScanFailed)
+	assert.Equal(t, 503, uploadErrorStatus(err))
+
+	app.config.scanFailOpen = true
+	assert.NoError(t, app.scanUpload(pi, open))
+}
+
+func TestUploadFileScan(t *testing.T) {
+	files := pm.NewPackageManager(t.TempDir())
+	require.NoError(t, files.Start())
+
+	app := &App{
+		logger:         slog.Default(),
+		audit:          slog.Default(),
+		config:         &AppConfig{quota: pm.Quota{User: 6}},
+		users:          fakeUsers{"user1": {Login: "user1", Scope: "s1"}},
+		packageManager: files,
+		scanner:        &fakeScanner{},
+	}
+
+	f := fiber.New()
+	f.Post("/", func(ctx *fiber.Ctx) error {
+		ctx.Locals(UsernameKey, "user1")
+
+		pi, err := app.uploadFile(ctx, ctx.Query("uid"), "file.bin")
+		if err != nil {
+			return ctx.SendStatus(uploadErrorStatus(err))
+		}
+
+		return ctx.SendString(pi.Hash)
+	})
+
+	post := func(uid, body string) *http.Response {
+		res, err := f.Test(httptest.NewRequest(http.MethodPost, "/?uid="+uid, strings.NewReader(body)))
+		require.NoError(t, err)
+
+		return res
+	}
+
+	res := post("uid1", "data")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	pi := files.Get("uid1")
+	require.NotNil(t, pi)
+	assert.Equal(t, 4, pi.Size)
+	require.NotNil(t, pi.Scan)
+	assert.True(t, pi.Scan.Clean)
+
+	// quota is checked before the file is stored
+	res = post("uid2", "more data")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+	assert.Nil(t, files.Get("uid2"))
+
+	list, err := files.ListFiles()
+	require.NoError(t, err)
+	assert.Len(t, list, 1)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/kdudkov/goasae/internal/pm"
+)
+
+// files younger than gcGrace are never collected, they can be in upload right now
+const gcGrace = time.Hour
+
+type RetentionConfig struct {
+	Keyword string `mapstructure:"keyword"`
+	Tool    string `mapstructure:"tool"`
+	MaxAge  string `mapstructure:"max_age"`
+	MaxSize string `mapstructure:"max_size"`
+}
+
+type StorageUsage struct {
+	Users  map[string]int64 `json:"users"`
+	Scopes map[string]int64 `json:"scopes"`
+	Files  int              `json:"files"`
+	Size   int64            `json:"size"`
+}
+
+// newPackageManager makes package manager keeping package infos in infos
+func newPackageManager(config *AppConfig, infos pm.InfoStore) pm.PackageManager {
+	dir := filepath.Join(config.dataDir, "mp")
+
+	var files *pm.PackageManagerFS
+
+	if config.s3 == nil {
+		files = pm.NewPackageManager(dir)
+	} else {
+		storage, err := pm.NewS3Storage(*config.s3)
+		if err != nil {
+			panic(err)
+		}
+
+		files = pm.NewPackageManagerWithStorage(dir, storage)
+	}
+
+	files.SetInfoStore(infos)
+
+	return files
+}
+
+func (c *RetentionConfig) Rule() (*pm.RetentionRule, error) {
+	r := &pm.RetentionRule{Keyword: c.Keyword, Tool: c.Tool}
+
+	if c.MaxAge != "" {
+		d, err := time.ParseDuration(c.MaxAge)
+		if err != nil {
+			return nil, err
+		}
+
+		r.MaxAge = d
+	}
+
+	size, err := pm.ParseSize(c.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	r.MaxSize = size
+
+	return r, nil
+}
+
+func (app *App) collectGarbage(dryRun bool) (*pm.GCReport, error) {
+	refs := app.missions.GetReferencedHashes()
+	keep := func(hash string) bool { return refs[hash] }
+
+	// package index is local to the server, so files of other servers sharing the bucket look unused
+	shared := app.config.s3 != nil
+
+	return pm.Collect(app.packageManager, app.config.retention, keep, gcGrace, shared, dryRun)
+}
+
+func (app *App) storageGC() {
+	if app.config.gcInterval <= 0 {
+		return
+	}
+
+	for range time.Tick(app.config.gcInterval) {
+		r, err := app.collectGarbage(false)
+		if err != nil {
+			app.logger.Error("storage gc error", slog.Any("error", err))
+
+			continue
+		}
+
+		if len(r.Expired) > 0 || len(r.Orphans) > 0 {
+			app.logger.Info(fmt.Sprintf("storage gc: %d packages expired, %d files (%d bytes) removed", len(r.Expired), len(r.Orphans), r.Freed))
+		}
+	}
+}
+
+func (app *App) storageUsage() (*StorageUsage, error) {
+	u := &StorageUsage{Users: make(map[string]int64), Scopes: make(map[string]int64)}
+
+	for _, pi := range app.packageManager.GetList(nil) {
+		u.Users[pi.SubmissionUser] += int64(pi.Size)
+		u.Scopes[pi.Scope] += int64(pi.Size)
+	}
+
+	files, err := app.packageManager.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		u.Files++
+		u.Size += f.Size
+	}
+
+	return u, nil
+}
+
+func (app *App) cleanUploads() {
+	if n := app.uploads.Cleanup(); n > 0 {
+		app.logger.Info(fmt.Sprintf("%d expired uploads removed", n))
+	}
+}
@@ -0,0 +1,151 @@
+package transfers
+
+import (
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/kdudkov/goasae/internal/model"
+)
+
+const maxLimit = 1000
+
+type TransferManager struct {
+	db     *gorm.DB
+	logger *slog.Logger
+}
+
+func New(db *gorm.DB) *TransferManager {
+	return &TransferManager{
+		db:     db,
+		logger: slog.Default().With("logger", "TransferManager"),
+	}
+}
+
+// Add stores transfer with its recipients
+func (m *TransferManager) Add(t *model.Transfer) error {
+	if m == nil || m.db == nil {
+		return nil
+	}
+
+	return m.db.Create(t).Error
+}
+
+func (m *TransferManager) Get(id string) *model.Transfer {
+	if m == nil || m.db == nil {
+		return nil
+	}
+
+	var t *model.Transfer
+
+	if err := m.db.Preload("Recipients").Where("id = ?", id).Take(&t).Error; err != nil {
+		return nil
+	}
+
+	return t
+}
+
+// List returns transfers with recipients, newest first
+func (m *TransferManager) List(limit int) []*model.Transfer {
+	if m == nil || m.db == nil {
+		return nil
+	}
+
+	if limit <= 0 || limit > maxLimit {
+		limit = maxLimit
+	}
+
+	var res []*model.Transfer
+
+	m.db.Preload("Recipients").Order("created desc").Limit(limit).Find(&res)
+
+	return res
+}
+
+func (m *TransferManager) Delete(id string) bool {
+	if m == nil || m.db == nil {
+		return false
+	}
+
+	var n int64
+
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("transfer_id = ?", id).Delete(&model.TransferRecipient{}).Error; err != nil {
+			return err
+		}
+
+		res := tx.Where("id = ?", id).Delete(&model.Transfer{})
+		n = res.RowsAffected
+
+		return res.Error
+	})
+
+	if err != nil {
+		m.logger.Error("delete error", slog.Any("error", err))
+	}
+
+	return n > 0
+}
+
+// SetSent marks transfer as sent to connected contact, login of the contact is used to track downloads
+func (m *TransferManager) SetSent(id, uid, login string, t time.Time) bool {
+	if m == nil || m.db == nil {
+		return false
+	}
+
+	values := map[string]any{"sent": t}
+	if login != "" {
+		values["login"] = login
+	}
+
+	res := m.db.Model(&model.TransferRecipient{}).
+		Where("transfer_id = ? AND uid = ? AND sent IS NULL", id, uid).
+		Updates(values)
+
+	if res.Error != nil {
+		m.logger.Error("update error", slog.Any("error", res.Error))
+
+		return false
+	}
+
+	return res.RowsAffected > 0
+}
+
+// SetAcked marks transfer as received by the contact, acked transfer is sent and downloaded too
+func (m *TransferManager) SetAcked(id, uid string, t time.Time) bool {
+	m.SetSent(id, uid, "", t)
+	m.setTime("downloaded", t, "transfer_id = ? AND uid = ?", id, uid)
+
+	return m.setTime("acked", t, "transfer_id = ? AND uid = ?", id, uid)
+}
+
+// SetDownloaded marks all transfers of file with hash to the user as downloaded
+func (m *TransferManager) SetDownloaded(hash, login string, t time.Time) bool {
+	if m == nil || m.db == nil || login == "" {
+		return false
+	}
+
+	ids := m.db.Model(&model.Transfer{}).Select("id").Where("hash = ?", hash)
+
+	return m.setTime("downloaded", t, "transfer_id IN (?) AND login = ?", ids, login)
+}
+
+func (m *TransferManager) setTime(field string, t time.Time, query string, args ...any) bool {
+	if m == nil || m.db == nil {
+		return false
+	}
+
+	res := m.db.Model(&model.TransferRecipient{}).
+		Where(query, args...).
+		Where(field+" IS NULL").
+		Update(field, t)
+
+	if res.Error != nil {
+		m.logger.Error("update error", slog.Any("error", res.Error))
+
+		return false
+	}
+
+	return res.RowsAffected > 0
+}
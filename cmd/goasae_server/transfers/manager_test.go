@@ -0,0 +1,70 @@
+package transfers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/kdudkov/goasae/internal/database/dbtest"
+	"github.com/kdudkov/goasae/internal/model"
+)
+
+func TestMain(m *testing.M) {
+	dbtest.Main(m)
+}
+
+func TestTransfer(t *testing.T) {
+	dbtest.ForEach(t, func(t *testing.T, db *gorm.DB) {
+		m := New(db)
+		now := time.Now()
+
+		require.NoError(t, m.Add(&model.Transfer{
+			ID:      "t1",
+			Created: now,
+			Hash:    "hash1",
+			Name:    "file.kmz",
+			Recipients: []*model.TransferRecipient{
+				{UID: "uid1", Callsign: "cs1"},
+				{UID: "uid2", Callsign: "cs2"},
+			},
+		}))
+		require.NoError(t, m.Add(&model.Transfer{ID: "t2", Created: now.Add(time.Second), Hash: "hash2"}))
+
+		list := m.List(0)
+		require.Len(t, list, 2)
+		assert.Equal(t, "t2", list[0].ID)
+
+		assert.True(t, m.SetSent("t1", "uid1", "user1", now))
+		assert.False(t, m.SetSent("t1", "uid1", "user1", now))
+
+		// only sent contacts have login
+		assert.False(t, m.SetDownloaded("hash2", "user1", now))
+		assert.True(t, m.SetDownloaded("hash1", "user1", now))
+
+		assert.False(t, m.SetAcked("t1", "uid3", now))
+		assert.True(t, m.SetAcked("t1", "uid2", now))
+
+		tr := m.Get("t1")
+		require.NotNil(t, tr)
+		require.Len(t, tr.Recipients, 2)
+
+		for _, r := range tr.Recipients {
+			assert.NotNil(t, r.Sent)
+			assert.NotNil(t, r.Downloaded)
+
+			if r.UID == "uid1" {
+				assert.Equal(t, "user1", r.Login)
+				assert.Nil(t, r.Acked)
+			} else {
+				assert.NotNil(t, r.Acked)
+			}
+		}
+
+		assert.True(t, m.Delete("t1"))
+		assert.False(t, m.Delete("t1"))
+		assert.Nil(t, m.Get("t1"))
+	})
+}
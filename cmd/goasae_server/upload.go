@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/kdudkov/goasae/internal/pm"
+)
+
+const (
+	headerUploadOffset = "Upload-Offset"
+	headerUploadLength = "Upload-Length"
+)
+
+// resumable upload: POST creates session, HEAD returns received size, PATCH appends data at Upload-Offset.
+// Package is saved when the last byte is received
+func getResumableCreateHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		user := app.users.GetUser(Username(ctx))
+		fname := ctx.Query("name")
+
+		if fname == "" {
+			return ctx.Status(fiber.StatusNotAcceptable).SendString("no name")
+		}
+
+		size, err := strconv.ParseInt(ctx.Query("size"), 10, 64)
+		if err != nil || size < 0 {
+			return ctx.Status(fiber.StatusNotAcceptable).SendString("invalid size")
+		}
+
+		pi := &pm.PackageInfo{
+			UID:                ctx.Query("uid"),
+			SubmissionDateTime: time.Now(),
+			MIMEType:           ctx.Query("mimeType"),
+			Size:               int(size),
+			SubmissionUser:     user.GetLogin(),
+			Hash:               ctx.Query("hash"),
+			CreatorUID:         getStringParamIgnoreCaps(ctx, "creatorUid"),
+			Scope:              user.GetScope(),
+			Name:               fname,
+		}
+
+		if ctx.QueryBool("package") {
+			pi.Keywords = []string{"missionpackage"}
+			pi.Tool = "public"
+		}
+
+		if err := app.config.quota.Check(app.packageManager, pi, size); err != nil {
+			app.logger.Error("error", slog.Any("error", err))
+
+			return ctx.SendStatus(uploadErrorStatus(err))
+		}
+
+		u, err := app.uploads.Create(Username(ctx), size, pi)
+		if err != nil {
+			app.logger.Error("error creating upload", slog.Any("error", err))
+
+			return ctx.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		app.logger.Info(fmt.Sprintf("upload %s of %s (%d bytes) started", u.ID, fname, size))
+
+		ctx.Set(fiber.HeaderLocation, "/Marti/sync/upload/resumable/"+u.ID)
+
+		return ctx.Status(fiber.StatusCreated).JSON(u)
+	}
+}
+
+func getResumableHeadHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		u := app.uploads.Get(ctx.Params("id"), Username(ctx))
+
+		if u == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		ctx.Set(headerUploadOffset, strconv.FormatInt(u.Offset, 10))
+		ctx.Set(headerUploadLength, strconv.FormatInt(u.Size, 10))
+		ctx.Set(fiber.HeaderCacheControl, "no-store")
+
+		return ctx.SendStatus(fiber.StatusOK)
+	}
+}
+
+func getResumablePatchHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		id, username := ctx.Params("id"), Username(ctx)
+
+		offset, err := strconv.ParseInt(ctx.Get(headerUploadOffset), 10, 64)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString("invalid " + headerUploadOffset)
+		}
+
+		n, err := app.uploads.Write(id, username, offset, bytes.NewReader(ctx.Body()))
+
+		switch {
+		case errors.Is(err, pm.ErrUploadNotFound):
+			return ctx.SendStatus(fiber.StatusNotFound)
+		case errors.Is(err, pm.ErrUploadBusy):
+			// offset is not known until other request with this upload is done
+			return uploadBusy(ctx)
+		}
+
+		ctx.Set(headerUploadOffset, strconv.FormatInt(n, 10))
+
+		switch {
+		case errors.Is(err, pm.ErrOffsetMismatch):
+			return ctx.SendStatus(fiber.StatusConflict)
+		case errors.Is(err, pm.ErrUploadTooLarge):
+			return ctx.Status(fiber.StatusRequestEntityTooLarge).SendString(err.Error())
+		case err != nil:
+			app.logger.Error("upload write error", slog.Any("error", err))
+
+			return ctx.SendStatus(fiber.StatusInternalServerError)
+		}
+
+		u := app.uploads.Get(id, username)
+
+		if u == nil || !u.Complete() {
+			return ctx.SendStatus(fiber.StatusNoContent)
+		}
+
+		// other uploads could be finished since the start
+		if err := app.config.quota.Check(app.packageManager, u.Info, u.Size); err != nil {
+			app.uploads.Delete(id, username)
+			app.logger.Error("error", slog.Any("error", err))
+
+			return ctx.SendStatus(uploadErrorStatus(err))
+		}
+
+		if err := app.scanUpload(u.Info, func() (io.ReadCloser, error) { return app.uploads.Open(id, username) }); err != nil {
+			app.uploads.Delete(id, username)
+
+			return ctx.Status(uploadErrorStatus(err)).SendString(err.Error())
+		}
+
+		pi, err := app.uploads.Finish(id, username, app.packageManager)
+		if errors.Is(err, pm.ErrUploadBusy) {
+			return uploadBusy(ctx)
+		}
+
+		if err != nil {
+			app.logger.Error("error saving upload", slog.Any("error", err))
+
+			return ctx.Status(fiber.StatusNotAcceptable).SendString(err.Error())
+		}
+
+		app.logger.Info(fmt.Sprintf("save packege %s %s %s", pi.Name, pi.UID, pi.Hash))
+
+		if pi.HasKeyword("missionpackage") {
+			return ctx.Status(fiber.StatusCreated).SendString(ctx.BaseURL() + packageUrl(pi))
+		}
+
+		return ctx.Status(fiber.StatusCreated).SendString(packageUrl(pi))
+	}
+}
+
+// uploadBusy answers to request for upload that is written or stored by other request, client should retry later
+func uploadBusy(ctx *fiber.Ctx) error {
+	ctx.Set(fiber.HeaderRetryAfter, "1")
+
+	return ctx.SendStatus(fiber.StatusLocked)
+}
+
+func getResumableDeleteHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if !app.uploads.Delete(ctx.Params("id"), Username(ctx)) {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		return ctx.SendStatus(fiber.StatusNoContent)
+	}
+}
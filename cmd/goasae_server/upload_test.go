@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/internal/pm"
+)
+
+func TestResumablePatchBusy(t *testing.T) {
+	app := &App{logger: slog.Default(), uploads: pm.NewUploadManager(t.TempDir(), time.Hour)}
+
+	u, err := app.uploads.Create("user1", 10, &pm.PackageInfo{})
+	require.NoError(t, err)
+
+	f := fiber.New()
+	f.Patch("/:id", func(ctx *fiber.Ctx) error {
+		ctx.Locals(UsernameKey, "user1")
+
+		return ctx.Next()
+	}, getResumablePatchHandler(app))
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		_, _ = app.uploads.Write(u.ID, "user1", 0, pr)
+		close(done)
+	}()
+
+	_, err = pw.Write([]byte("0123"))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPatch, "/"+u.ID, strings.NewReader("0123"))
+	req.Header.Set(headerUploadOffset, "0")
+
+	res, err := f.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusLocked, res.StatusCode)
+	assert.Empty(t, res.Header.Get(headerUploadOffset))
+	assert.Equal(t, "1", res.Header.Get(fiber.HeaderRetryAfter))
+
+	require.NoError(t, pw.Close())
+	<-done
+
+	req = httptest.NewRequest(http.MethodPatch, "/"+u.ID, strings.NewReader("0123"))
+	req.Header.Set(headerUploadOffset, "0")
+
+	res, err = f.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+	assert.Equal(t, "4", res.Header.Get(headerUploadOffset))
+}
@@ -7,8 +7,16 @@ import (
 	"log/slog"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+
+	"github.com/kdudkov/goasae/cmd/goasae_server/chats"
+	"github.com/kdudkov/goasae/cmd/goasae_server/missions"
+	"github.com/kdudkov/goasae/cmd/goasae_server/packages"
+	im "github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/internal/pm"
+	"github.com/kdudkov/goasae/pkg/model"
 )
 
 func logParams(log *slog.Logger, ctx *fiber.Ctx) {
@@ -54,6 +62,127 @@ func getStringParamIgnoreCaps(c *fiber.Ctx, name string) string {
 	return ""
 }
 
+// getChatQuery makes chat history query from chatroom, parent, uid, after, before (RFC3339), limit and offset params
+func getChatQuery(ctx *fiber.Ctx) (*chats.Query, error) {
+	q := &chats.Query{
+		Chatroom: ctx.Query("chatroom"),
+		Parent:   ctx.Query("parent"),
+		UID:      ctx.Query("uid"),
+		Limit:    ctx.QueryInt("limit", 100),
+		Offset:   ctx.QueryInt("offset", 0),
+	}
+
+	for name, t := range map[string]*time.Time{"after": &q.After, "before": &q.Before} {
+		if s := ctx.Query(name); s != "" {
+			tm, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+
+			*t = tm
+		}
+	}
+
+	return q, nil
+}
+
+// getPackageQuery makes package search query from keywords, tool, q (text), name, creatorUid, submissionUser, mimeType,
+// minSize, maxSize, start, end (RFC3339), bbox, sort (name, time, size), order (asc, desc), limit and offset params
+func getPackageQuery(ctx *fiber.Ctx) (*packages.Query, error) {
+	q := &packages.Query{
+		Keyword:        ctx.Query("keywords"),
+		Tool:           ctx.Query("tool"),
+		Text:           ctx.Query("q"),
+		Name:           ctx.Query("name"),
+		CreatorUID:     ctx.Query("creatorUid"),
+		SubmissionUser: ctx.Query("submissionUser"),
+		MIMEType:       ctx.Query("mimeType"),
+		Sort:           ctx.Query("sort"),
+		Limit:          ctx.QueryInt("limit", 0),
+		Offset:         ctx.QueryInt("offset", 0),
+	}
+
+	switch q.Sort {
+	case "", "name", "time", "size":
+	default:
+		return nil, fmt.Errorf("invalid sort %s", q.Sort)
+	}
+
+	switch ctx.Query("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return nil, fmt.Errorf("invalid order %s", ctx.Query("order"))
+	}
+
+	for name, n := range map[string]*int64{"minSize": &q.MinSize, "maxSize": &q.MaxSize} {
+		if s := ctx.Query(name); s != "" {
+			size, err := pm.ParseSize(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+
+			*n = size
+		}
+	}
+
+	for name, t := range map[string]*time.Time{"start": &q.After, "end": &q.Before} {
+		if s := ctx.Query(name); s != "" {
+			tm, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+
+			*t = tm
+		}
+	}
+
+	if s := ctx.Query("bbox"); s != "" {
+		b, err := im.ParseBBox(s)
+		if err != nil {
+			return nil, err
+		}
+
+		q.BBox = &pm.Bounds{MinLat: b.MinLat, MinLon: b.MinLon, MaxLat: b.MaxLat, MaxLon: b.MaxLon}
+	}
+
+	return q, nil
+}
+
+func getChangeQuery(ctx *fiber.Ctx) (*missions.ChangeQuery, error) {
+	q := &missions.ChangeQuery{
+		After:    time.Now().Add(-time.Second * time.Duration(ctx.QueryInt("secago", 31536000))),
+		Since:    uint(ctx.QueryInt("since", 0)),
+		Limit:    ctx.QueryInt("limit", 0),
+		Offset:   ctx.QueryInt("offset", 0),
+		Squashed: ctx.QueryBool("squashed", false),
+	}
+
+	for name, t := range map[string]*time.Time{"start": &q.After, "end": &q.Before} {
+		if s := ctx.Query(name); s != "" {
+			tm, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+
+			*t = tm
+		}
+	}
+
+	return q, nil
+}
+
+func chatsToModel(msgs []*im.ChatMessage) []*model.ChatMessage {
+	res := make([]*model.ChatMessage, len(msgs))
+
+	for i, c := range msgs {
+		res[i] = c.ToModel()
+	}
+
+	return res
+}
+
 // LRSCache last recent store cache 存储满后，将最早之前存储的对象删除
 type LRSCache[T any] struct {
 	maxEntries int
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/kdudkov/goasae/cmd/goasae_server/video"
+	im "github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+// canSeeFeed checks feed scope and read roles, owner can always see the feed
+func canSeeFeed(user *im.User, f *model.Feed2) bool {
+	if f.User != "" && f.User == user.GetLogin() {
+		return true
+	}
+
+	if !user.CanSeeScope(f.Scope) {
+		return false
+	}
+
+	return len(f.Roles) == 0 || (user != nil && slices.Contains(f.Roles, user.Role))
+}
+
+// canEditFeed allows change of feed by its owner or by user of the same scope if feed has no owner
+func canEditFeed(user *im.User, f *model.Feed2) bool {
+	if f.User != "" {
+		return f.User == user.GetLogin()
+	}
+
+	return user.CanSeeScope(f.Scope)
+}
+
+// visibleFeeds returns feeds the user can see with active flag from the last check
+func (app *App) visibleFeeds(user *im.User) []*model.Feed2 {
+	res := make([]*model.Feed2, 0)
+
+	app.feeds.ForEach(func(f *model.Feed2) bool {
+		if canSeeFeed(user, f) {
+			res = append(res, app.prober.Apply(f))
+		}
+
+		return true
+	})
+
+	return res
+}
+
+func (app *App) relayed(f *model.Feed2) bool {
+	return app.relay != nil && f.Relay
+}
+
+// clientFeed returns copy of relayed feed with url of the relay for the user on the host client connected to
+func (app *App) clientFeed(host, login string, f *model.Feed2) *model.Feed2 {
+	if !app.relayed(f) {
+		return f
+	}
+
+	f1 := *f
+	f1.URL = app.relay.URL(host, login, f.UID)
+
+	return &f1
+}
+
+// relayFeed returns feed for relay if the user can see it
+func (app *App) relayFeed(login, uid string) *model.Feed2 {
+	f := app.feeds.Get(uid)
+
+	if f == nil || !canSeeFeed(app.users.GetUser(login), f) {
+		return nil
+	}
+
+	return f
+}
+
+func (app *App) probeFeeds(ctx context.Context) {
+	if app.config.probeInterval <= 0 {
+		return
+	}
+
+	for {
+		feeds := make([]*model.Feed2, 0)
+
+		app.feeds.ForEach(func(f *model.Feed2) bool {
+			feeds = append(feeds, f)
+
+			return true
+		})
+
+		app.prober.Check(ctx, feeds)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(app.config.probeInterval):
+		}
+	}
+}
+
+type feedAdm struct {
+	*model.FeedDTO
+	Status *video.Status `json:"status,omitempty"`
+}
+
+func (app *App) feedToAdm(f *model.Feed2) *feedAdm {
+	return &feedAdm{FeedDTO: app.prober.Apply(f).ToDTO(), Status: app.prober.Status(f.UID)}
+}
+
+func getFeedsHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		res := make([]*feedAdm, 0)
+
+		app.feeds.ForEach(func(f *model.Feed2) bool {
+			res = append(res, app.feedToAdm(f))
+
+			return true
+		})
+
+		sort.Slice(res, func(i, j int) bool {
+			return res[i].Alias < res[j].Alias
+		})
+
+		return ctx.JSON(res)
+	}
+}
+
+func getFeedHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		f := app.feeds.Get(ctx.Params("uid"))
+		if f == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		return ctx.JSON(app.feedToAdm(f))
+	}
+}
+
+// getFeedPostHandler creates or updates feed, url can be given as parts (protocol, address, port, path)
+func getFeedPostHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		d := new(model.FeedDTO)
+
+		if err := json.Unmarshal(ctx.Body(), d); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		f := d.ToFeed2()
+
+		if f.UID == "" {
+			f.UID = uuid.NewString()
+		}
+
+		if err := f.Validate(); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		app.feeds.Store(f)
+		app.audit.Info("feed_store", slog.String("uid", f.UID), slog.String("alias", f.Alias), slog.String("url", f.URL),
+			slog.String("scope", f.Scope), slog.String("by", "admin "+ctx.IP()))
+
+		return ctx.JSON(app.feedToAdm(f))
+	}
+}
+
+func getRelayHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		if app.relay == nil {
+			return ctx.JSON([]any{})
+		}
+
+		return ctx.JSON(app.relay.Stats())
+	}
+}
+
+func getFeedDeleteHandler(app *App) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		uid := ctx.Params("uid")
+
+		if app.feeds.Get(uid) == nil {
+			return ctx.SendStatus(fiber.StatusNotFound)
+		}
+
+		app.feeds.Remove(uid)
+		app.audit.Info("feed_delete", slog.String("uid", uid), slog.String("by", "admin "+ctx.IP()))
+
+		return ctx.SendStatus(fiber.StatusNoContent)
+	}
+}
@@ -0,0 +1,288 @@
+package video
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+const maxParallel = 8
+
+// Status is the result of the last feed check
+type Status struct {
+	Active  bool          `json:"active"`
+	Checked time.Time     `json:"checked"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Prober checks that feed sources are reachable. Feeds not checked yet are considered active
+type Prober struct {
+	logger  *slog.Logger
+	mx      sync.RWMutex
+	timeout time.Duration
+	status  map[string]*Status
+}
+
+func NewProber(timeout time.Duration) *Prober {
+	if timeout <= 0 {
+		timeout = time.Second * 5
+	}
+
+	return &Prober{
+		logger:  slog.Default().With("logger", "video_prober"),
+		timeout: timeout,
+		status:  make(map[string]*Status),
+	}
+}
+
+// Check probes all feeds and forgets status of feeds not in the list
+func (p *Prober) Check(ctx context.Context, feeds []*model.Feed2) {
+	var wg sync.WaitGroup
+
+	sem := make(chan struct{}, maxParallel)
+	res := make(map[string]*Status, len(feeds))
+
+	var mx sync.Mutex
+
+	for _, f := range feeds {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(f *model.Feed2) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			st := p.check(ctx, f.URL)
+
+			mx.Lock()
+			res[f.UID] = st
+			mx.Unlock()
+		}(f)
+	}
+
+	wg.Wait()
+
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	for uid, st := range res {
+		if old, ok := p.status[uid]; !ok || old.Active != st.Active {
+			if st.Active {
+				p.logger.Info(fmt.Sprintf("feed %s is active", uid))
+			} else {
+				p.logger.Warn(fmt.Sprintf("feed %s is inactive: %s", uid, st.Error))
+			}
+		}
+	}
+
+	p.status = res
+}
+
+func (p *Prober) check(ctx context.Context, u string) *Status {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := Probe(ctx, u)
+	st := &Status{Active: err == nil, Checked: time.Now(), Latency: time.Since(start)}
+
+	if err != nil {
+		st.Error = err.Error()
+	}
+
+	return st
+}
+
+func (p *Prober) Status(uid string) *Status {
+	p.mx.RLock()
+	defer p.mx.RUnlock()
+
+	return p.status[uid]
+}
+
+// Apply sets active flag of the feed from the last check
+func (p *Prober) Apply(f *model.Feed2) *model.Feed2 {
+	if p == nil || f == nil {
+		return f
+	}
+
+	if st := p.Status(f.UID); st != nil {
+		f.Active = st.Active
+	}
+
+	return f
+}
+
+// Probe checks that feed source answers with its protocol
+func Probe(ctx context.Context, u string) error {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+
+	switch pu.Scheme {
+	case "http", "https":
+		return probeHTTP(ctx, u)
+	case "rtsp":
+		return probeTCP(ctx, hostPort(pu, "554"), func(c net.Conn) error { return probeRTSP(c, u) })
+	case "rtmp":
+		return probeTCP(ctx, hostPort(pu, "1935"), probeRTMP)
+	case "srt":
+		return probeSRT(ctx, hostPort(pu, "9710"))
+	default:
+		return fmt.Errorf("unsupported protocol %s", pu.Scheme)
+	}
+}
+
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		port = u.Port()
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func probeHTTP(ctx context.Context, u string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	_ = res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("http status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func probeTCP(ctx context.Context, addr string, f func(c net.Conn) error) error {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	return f(conn)
+}
+
+// probeRTSP sends OPTIONS request, any RTSP answer (even 401) means server is alive
+func probeRTSP(c net.Conn, u string) error {
+	if _, err := fmt.Fprintf(c, "OPTIONS %s RTSP/1.0\r\nCSeq: 1\r\nUser-Agent: goasae\r\n\r\n", u); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(string(buf), "RTSP/1.0 ") {
+		return fmt.Errorf("not a rtsp server")
+	}
+
+	return nil
+}
+
+// probeRTMP makes first step of RTMP handshake: sends C0+C1 and waits for S0
+func probeRTMP(c net.Conn) error {
+	c1 := make([]byte, 1+1536)
+	c1[0] = 3
+
+	if _, err := c.Write(c1); err != nil {
+		return err
+	}
+
+	s0 := make([]byte, 1)
+	if _, err := io.ReadFull(c, s0); err != nil {
+		return err
+	}
+
+	if s0[0] != 3 {
+		return fmt.Errorf("invalid rtmp version %d", s0[0])
+	}
+
+	return nil
+}
+
+// probeSRT sends SRT induction handshake and waits for handshake answer
+func probeSRT(ctx context.Context, addr string) error {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	if _, err := conn.Write(srtInduction()); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+
+	if n < 64 || binary.BigEndian.Uint32(buf) != 0x80000000 {
+		return errors.New("not a srt handshake")
+	}
+
+	return nil
+}
+
+func srtInduction() []byte {
+	b := make([]byte, 64)
+
+	// control packet, type handshake
+	binary.BigEndian.PutUint32(b[0:], 0x80000000)
+	// version 4, extension field 2 for induction
+	binary.BigEndian.PutUint32(b[16:], 4)
+	binary.BigEndian.PutUint16(b[22:], 2)
+
+	var r [8]byte
+	_, _ = rand.Read(r[:])
+	binary.BigEndian.PutUint32(b[24:], binary.BigEndian.Uint32(r[:4])&0x7fffffff)
+	// mtu, flow window, handshake type induction, socket id
+	binary.BigEndian.PutUint32(b[28:], 1500)
+	binary.BigEndian.PutUint32(b[32:], 8192)
+	binary.BigEndian.PutUint32(b[36:], 1)
+	binary.BigEndian.PutUint32(b[40:], binary.BigEndian.Uint32(r[4:])&0x7fffffff)
+
+	return b
+}
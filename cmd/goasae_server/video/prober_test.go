@@ -0,0 +1,140 @@
+package video
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+func listenTCP(t *testing.T, serve func(c net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer c.Close()
+				serve(c)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func fakeRTSP(c net.Conn) {
+	if line, _ := bufio.NewReader(c).ReadString('\n'); strings.HasPrefix(line, "OPTIONS ") {
+		_, _ = c.Write([]byte("RTSP/1.0 200 OK\r\nCSeq: 1\r\nPublic: DESCRIBE, SETUP, PLAY\r\n\r\n"))
+	}
+}
+
+func fakeRTMP(c net.Conn) {
+	buf := make([]byte, 1537)
+	if _, err := io.ReadFull(c, buf); err == nil && buf[0] == 3 {
+		_, _ = c.Write(append([]byte{3}, make([]byte, 1536)...))
+	}
+}
+
+func fakeSRT(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			if n == 64 && binary.BigEndian.Uint32(buf) == 0x80000000 && binary.BigEndian.Uint32(buf[36:]) == 1 {
+				ans := make([]byte, 64)
+				copy(ans, buf[:n])
+				binary.BigEndian.PutUint32(ans[16:], 5)
+				_, _ = conn.WriteTo(ans, addr)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/live.m3u8" {
+			_, _ = w.Write([]byte("#EXTM3U\n"))
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	silent := listenTCP(t, func(c net.Conn) { time.Sleep(time.Second) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
+	defer cancel()
+
+	assert.NoError(t, Probe(ctx, srv.URL+"/live.m3u8"))
+	assert.Error(t, Probe(ctx, srv.URL+"/none.m3u8"))
+	assert.NoError(t, Probe(ctx, "rtsp://"+listenTCP(t, fakeRTSP)+"/cam1"))
+	assert.NoError(t, Probe(ctx, "rtmp://"+listenTCP(t, fakeRTMP)+"/live/cam1"))
+	assert.NoError(t, Probe(ctx, "srt://"+fakeSRT(t)+"?streamid=cam1"))
+	assert.Error(t, Probe(ctx, "rtsp://"+silent+"/cam1"))
+	assert.Error(t, Probe(ctx, "udp://239.0.0.1:1234"))
+}
+
+func TestProber(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	closed := l.Addr().String()
+	_ = l.Close()
+
+	p := NewProber(time.Millisecond * 500)
+
+	feeds := []*model.Feed2{
+		{UID: "f1", Active: true, URL: "rtsp://" + listenTCP(t, fakeRTSP) + "/cam1"},
+		{UID: "f2", Active: true, URL: "rtsp://" + closed + "/cam2"},
+		{UID: "f3", Active: true, URL: "rtsp://127.0.0.1/cam3"},
+	}
+
+	p.Check(context.Background(), feeds[:2])
+
+	require.NotNil(t, p.Status("f1"))
+	assert.True(t, p.Status("f1").Active)
+	require.NotNil(t, p.Status("f2"))
+	assert.False(t, p.Status("f2").Active)
+	assert.NotEmpty(t, p.Status("f2").Error)
+
+	assert.True(t, p.Apply(feeds[0]).Active)
+	assert.False(t, p.Apply(feeds[1]).Active)
+	// not checked feed is active
+	assert.True(t, p.Apply(feeds[2]).Active)
+
+	p.Check(context.Background(), feeds[:1])
+	assert.Nil(t, p.Status("f2"))
+}
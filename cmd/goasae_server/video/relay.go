@@ -0,0 +1,602 @@
+package video
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+const (
+	sourceTimeout     = time.Second * 10
+	sourceKeepalive   = time.Second * 20
+	clientTimeout     = time.Minute
+	subscriberBacklog = 512
+)
+
+var (
+	ErrNoFeed    = errors.New("feed is not found or not relayed")
+	ErrForbidden = errors.New("invalid relay token")
+)
+
+type packet struct {
+	channel byte
+	data    []byte
+}
+
+type StreamStats struct {
+	UID     string    `json:"uid"`
+	Started time.Time `json:"started"`
+	Clients int       `json:"clients"`
+	Packets uint64    `json:"packets"`
+	Bytes   uint64    `json:"bytes"`
+	Dropped uint64    `json:"dropped"`
+}
+
+// stream is one pulled source shared by all clients of the feed
+type stream struct {
+	uid     string
+	url     string
+	started time.Time
+	ready   chan struct{}
+	sdp     *sdp
+	err     error
+	cancel  context.CancelFunc
+
+	mx      sync.Mutex
+	refs    int
+	subs    map[chan *packet]struct{}
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func (s *stream) subscribe() chan *packet {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	ch := make(chan *packet, subscriberBacklog)
+
+	if s.subs != nil {
+		s.subs[ch] = struct{}{}
+	} else {
+		// stream is finished
+		close(ch)
+	}
+
+	return ch
+}
+
+func (s *stream) unsubscribe(ch chan *packet) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, ok := s.subs[ch]; ok {
+		delete(s.subs, ch)
+		close(ch)
+	}
+}
+
+// publish sends packet to all clients, packets for slow clients are dropped
+func (s *stream) publish(p *packet) {
+	s.packets.Add(1)
+	s.bytes.Add(uint64(len(p.data)))
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for ch := range s.subs {
+		select {
+		case ch <- p:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+func (s *stream) finish(err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.err = err
+
+	for ch := range s.subs {
+		close(ch)
+	}
+
+	s.subs = nil
+}
+
+// Relay pulls RTSP sources of relayed feeds once and serves them to many clients with RTSP over TCP.
+// Source is connected when the first client comes and is closed after the last one leaves.
+// Relay urls contain token of the user signed with key, lookup returns feed only if the user can see it
+type Relay struct {
+	logger   *slog.Logger
+	addr     string
+	key      []byte
+	lookup   func(login, uid string) *model.Feed2
+	listener net.Listener
+
+	mx      sync.Mutex
+	streams map[string]*stream
+}
+
+func NewRelay(addr string, key []byte, lookup func(login, uid string) *model.Feed2) *Relay {
+	return &Relay{
+		logger:  slog.Default().With("logger", "video_relay"),
+		addr:    addr,
+		key:     key,
+		lookup:  lookup,
+		streams: make(map[string]*stream),
+	}
+}
+
+func (r *Relay) Start() error {
+	l, err := net.Listen("tcp", r.addr)
+	if err != nil {
+		return err
+	}
+
+	r.listener = l
+	r.logger.Info("video relay is listening at " + l.Addr().String())
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					r.logger.Error("accept error", slog.Any("error", err))
+				}
+
+				return
+			}
+
+			go r.serve(conn)
+		}
+	}()
+
+	return nil
+}
+
+func (r *Relay) Stop() {
+	if r.listener != nil {
+		_ = r.listener.Close()
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	for _, s := range r.streams {
+		s.cancel()
+	}
+}
+
+// Addr returns listening address
+func (r *Relay) Addr() string {
+	if r.listener != nil {
+		return r.listener.Addr().String()
+	}
+
+	return r.addr
+}
+
+// URL returns relay url of the feed for the user connecting to host
+func (r *Relay) URL(host, login, uid string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if _, port, err := net.SplitHostPort(r.Addr()); err == nil && port != "554" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	return "rtsp://" + host + "/" + r.token(login, uid) + "/" + url.PathEscape(uid)
+}
+
+// token is the user login and signature of login and feed uid
+func (r *Relay) token(login, uid string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(login)) + "." + base64.RawURLEncoding.EncodeToString(r.sign(login, uid))
+}
+
+func (r *Relay) sign(login, uid string) []byte {
+	m := hmac.New(sha256.New, r.key)
+	m.Write([]byte(login + "\n" + uid))
+
+	return m.Sum(nil)
+}
+
+// checkToken returns login of the user the token for feed uid is issued to
+func (r *Relay) checkToken(token, uid string) (string, bool) {
+	l, s, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", false
+	}
+
+	login, err := base64.RawURLEncoding.DecodeString(l)
+	if err != nil {
+		return "", false
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return "", false
+	}
+
+	return string(login), hmac.Equal(sig, r.sign(string(login), uid))
+}
+
+func (r *Relay) Stats() []*StreamStats {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	res := make([]*StreamStats, 0, len(r.streams))
+
+	for _, s := range r.streams {
+		s.mx.Lock()
+		clients := len(s.subs)
+		s.mx.Unlock()
+
+		res = append(res, &StreamStats{
+			UID:     s.uid,
+			Started: s.started,
+			Clients: clients,
+			Packets: s.packets.Load(),
+			Bytes:   s.bytes.Load(),
+			Dropped: s.dropped.Load(),
+		})
+	}
+
+	return res
+}
+
+// acquire checks the token and returns started stream of the feed, stream must be released by caller
+func (r *Relay) acquire(token, uid string) (*stream, error) {
+	login, ok := r.checkToken(token, uid)
+	if !ok {
+		return nil, ErrForbidden
+	}
+
+	f := r.lookup(login, uid)
+	if f == nil || !f.Relay {
+		return nil, ErrNoFeed
+	}
+
+	r.mx.Lock()
+
+	s, ok := r.streams[uid]
+	if !ok {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		s = &stream{
+			uid:     uid,
+			url:     f.URL,
+			started: time.Now(),
+			ready:   make(chan struct{}),
+			cancel:  cancel,
+			subs:    make(map[chan *packet]struct{}),
+		}
+		r.streams[uid] = s
+
+		go r.pull(ctx, s)
+	}
+
+	s.refs++
+	r.mx.Unlock()
+
+	<-s.ready
+
+	if s.sdp == nil {
+		err := s.err
+		r.release(s)
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (r *Relay) release(s *stream) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	s.refs--
+
+	if s.refs <= 0 {
+		s.cancel()
+
+		if r.streams[s.uid] == s {
+			delete(r.streams, s.uid)
+		}
+	}
+}
+
+// pull reads source until it fails or stream is cancelled
+func (r *Relay) pull(ctx context.Context, s *stream) {
+	err := r.pullSource(ctx, s)
+
+	r.mx.Lock()
+	if r.streams[s.uid] == s {
+		delete(r.streams, s.uid)
+	}
+	r.mx.Unlock()
+
+	if ctx.Err() == nil {
+		r.logger.Warn(fmt.Sprintf("feed %s source is closed", s.uid), slog.Any("error", err))
+	} else {
+		r.logger.Info(fmt.Sprintf("feed %s source is released", s.uid))
+	}
+
+	s.finish(err)
+
+	select {
+	case <-s.ready:
+	default:
+		close(s.ready)
+	}
+}
+
+func (r *Relay) pullSource(ctx context.Context, s *stream) error {
+	dctx, cancel := context.WithTimeout(ctx, sourceTimeout)
+	defer cancel()
+
+	c, err := dialRTSP(dctx, s.url)
+	if err != nil {
+		return err
+	}
+
+	defer c.Close()
+
+	go func() {
+		<-ctx.Done()
+		_ = c.Close()
+	}()
+
+	setDeadline(c.conn, sourceTimeout)
+
+	desc, err := c.start()
+	if err != nil {
+		return err
+	}
+
+	r.logger.Info(fmt.Sprintf("feed %s source is started, %d media", s.uid, len(desc.medias)))
+
+	s.sdp = desc
+	close(s.ready)
+
+	lastKeepalive := time.Now()
+
+	for {
+		setDeadline(c.conn, sourceTimeout)
+
+		ch, data, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+
+		s.publish(&packet{channel: ch, data: data})
+
+		if time.Since(lastKeepalive) > sourceKeepalive {
+			lastKeepalive = time.Now()
+
+			if err := c.keepalive(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// client is a connection to relay server
+type client struct {
+	relay   *Relay
+	conn    net.Conn
+	wmx     sync.Mutex
+	session string
+	stream  *stream
+	// source channel -> client channel
+	channels map[byte]byte
+	sub      chan *packet
+}
+
+func (r *Relay) serve(conn net.Conn) {
+	c := &client{relay: r, conn: conn, session: strings.ReplaceAll(uuid.NewString(), "-", "")[:16], channels: make(map[byte]byte)}
+
+	defer c.close()
+
+	br := bufio.NewReader(conn)
+
+	for {
+		setDeadline(conn, clientTimeout)
+
+		b, err := br.Peek(1)
+		if err != nil {
+			return
+		}
+
+		// RTCP from client
+		if b[0] == '$' {
+			if _, _, err := readInterleaved(br); err != nil {
+				return
+			}
+
+			continue
+		}
+
+		req, err := readRequest(br)
+		if err != nil {
+			r.logger.Debug("rtsp request error", slog.Any("error", err))
+
+			return
+		}
+
+		if !c.handle(req) {
+			return
+		}
+	}
+}
+
+func (c *client) close() {
+	if c.sub != nil {
+		c.stream.unsubscribe(c.sub)
+	}
+
+	if c.stream != nil {
+		c.relay.release(c.stream)
+	}
+
+	_ = c.conn.Close()
+}
+
+func (c *client) reply(req *rtspRequest, status int, reason string, hdr map[string]string, body []byte) bool {
+	if hdr == nil {
+		hdr = make(map[string]string)
+	}
+
+	hdr["CSeq"] = req.header.Get("CSeq")
+	hdr["Server"] = rtspUserAgent
+
+	c.wmx.Lock()
+	defer c.wmx.Unlock()
+
+	return writeResponse(c.conn, status, reason, hdr, body) == nil
+}
+
+// handle processes request, returns false if connection must be closed
+func (c *client) handle(req *rtspRequest) bool {
+	token, uid, track := parseRelayPath(req.uri)
+
+	switch req.method {
+	case "OPTIONS", "GET_PARAMETER":
+		return c.reply(req, 200, "OK", map[string]string{"Public": "OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER"}, nil)
+
+	case "DESCRIBE":
+		if c.stream == nil {
+			s, err := c.relay.acquire(token, uid)
+			if err != nil {
+				c.relay.logger.Warn(fmt.Sprintf("feed %s: %s", uid, err.Error()))
+
+				switch {
+				case errors.Is(err, ErrForbidden):
+					return c.reply(req, 403, "Forbidden", nil, nil)
+				case errors.Is(err, ErrNoFeed):
+					return c.reply(req, 404, "Not Found", nil, nil)
+				}
+
+				return c.reply(req, 503, "Service Unavailable", nil, nil)
+			}
+
+			c.stream = s
+		}
+
+		base := strings.TrimSuffix(req.uri, "/") + "/"
+
+		return c.reply(req, 200, "OK", map[string]string{"Content-Type": "application/sdp", "Content-Base": base}, c.stream.sdp.bytes())
+
+	case "SETUP":
+		if c.stream == nil || c.stream.uid != uid {
+			return c.reply(req, 455, "Method Not Valid in This State", nil, nil)
+		}
+
+		n, err := strconv.Atoi(strings.TrimPrefix(track, "trackID="))
+		if err != nil || n < 0 || n >= len(c.stream.sdp.medias) {
+			return c.reply(req, 404, "Not Found", nil, nil)
+		}
+
+		transport := req.header.Get("Transport")
+		if !strings.Contains(transport, "RTP/AVP/TCP") {
+			return c.reply(req, 461, "Unsupported Transport", nil, nil)
+		}
+
+		a, b, ok := interleaved(transport)
+		if !ok {
+			a, b = n*2, n*2+1
+		}
+
+		c.channels[byte(n*2)] = byte(a)
+		c.channels[byte(n*2+1)] = byte(b)
+
+		return c.reply(req, 200, "OK", map[string]string{
+			"Transport": fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", a, b),
+			"Session":   c.session + ";timeout=60",
+		}, nil)
+
+	case "PLAY":
+		if c.stream == nil || len(c.channels) == 0 {
+			return c.reply(req, 455, "Method Not Valid in This State", nil, nil)
+		}
+
+		if !c.reply(req, 200, "OK", map[string]string{"Session": c.session, "Range": "npt=0.000-"}, nil) {
+			return false
+		}
+
+		if c.sub == nil {
+			c.sub = c.stream.subscribe()
+			go c.forward(c.sub, maps.Clone(c.channels))
+		}
+
+		return true
+
+	case "TEARDOWN":
+		c.reply(req, 200, "OK", map[string]string{"Session": c.session}, nil)
+
+		return false
+
+	default:
+		return c.reply(req, 501, "Not Implemented", nil, nil)
+	}
+}
+
+// forward writes stream packets to client until stream or client is closed
+func (c *client) forward(sub chan *packet, channels map[byte]byte) {
+	for p := range sub {
+		ch, ok := channels[p.channel]
+		if !ok {
+			continue
+		}
+
+		c.wmx.Lock()
+		setDeadline(c.conn, clientTimeout)
+		err := writeInterleaved(c.conn, ch, p.data)
+		c.wmx.Unlock()
+
+		if err != nil {
+			break
+		}
+	}
+
+	// source is finished or client can't receive
+	_ = c.conn.Close()
+}
+
+// parseRelayPath returns token, feed uid and track from rtsp://host/token/uid/trackID=n
+func parseRelayPath(uri string) (string, string, string) {
+	path := uri
+
+	if u, err := url.Parse(uri); err == nil {
+		path = u.EscapedPath()
+	}
+
+	token, rest, _ := strings.Cut(strings.Trim(path, "/"), "/")
+	uid, track, _ := strings.Cut(rest, "/")
+
+	if v, err := url.PathUnescape(uid); err == nil {
+		uid = v
+	}
+
+	return token, uid, track
+}
@@ -0,0 +1,199 @@
+package video
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+const testSDP = "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=test\r\nt=0 0\r\na=control:*\r\n" +
+	"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=control:video\r\n" +
+	"m=audio 0 RTP/AVP 97\r\na=rtpmap:97 MPEG4-GENERIC/8000\r\na=control:rtsp://cam/stream/audio\r\n"
+
+// fakeCamera is a synthetic rtsp source sending numbered rtp packets for every media
+type fakeCamera struct {
+	conns atomic.Int32
+	plays atomic.Int32
+}
+
+func (cam *fakeCamera) serve(c net.Conn) {
+	cam.conns.Add(1)
+
+	br := bufio.NewReader(c)
+	channels := make([]int, 0)
+
+	for {
+		req, err := readRequest(br)
+		if err != nil {
+			return
+		}
+
+		hdr := map[string]string{"CSeq": req.header.Get("CSeq"), "Session": "cam1"}
+
+		switch req.method {
+		case "DESCRIBE":
+			hdr["Content-Base"] = "rtsp://cam/stream/"
+			_ = writeResponse(c, 200, "OK", hdr, []byte(testSDP))
+		case "SETUP":
+			a, _, _ := interleaved(req.header.Get("Transport"))
+			channels = append(channels, a)
+			_ = writeResponse(c, 200, "OK", hdr, nil)
+		case "PLAY":
+			cam.plays.Add(1)
+			_ = writeResponse(c, 200, "OK", hdr, nil)
+
+			go cam.stream(c, channels)
+		default:
+			_ = writeResponse(c, 200, "OK", hdr, nil)
+		}
+	}
+}
+
+func (cam *fakeCamera) stream(c net.Conn, channels []int) {
+	for seq := uint16(0); ; seq++ {
+		for _, ch := range channels {
+			pkt := make([]byte, 12)
+			pkt[0] = 0x80
+			binary.BigEndian.PutUint16(pkt[2:], seq)
+
+			if err := writeInterleaved(c, byte(ch), pkt); err != nil {
+				return
+			}
+		}
+
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestParseSDP(t *testing.T) {
+	s := parseSDP([]byte(testSDP), "rtsp://cam/stream/")
+
+	require.Len(t, s.medias, 2)
+	assert.Equal(t, "rtsp://cam/stream/video", s.medias[0].control)
+	assert.Equal(t, "rtsp://cam/stream/audio", s.medias[1].control)
+
+	b := string(s.bytes())
+	assert.Contains(t, b, "a=control:trackID=0\r\n")
+	assert.Contains(t, b, "a=control:trackID=1\r\n")
+	assert.NotContains(t, b, "a=control:*")
+}
+
+func TestRelayURL(t *testing.T) {
+	r := NewRelay(":8554", []byte("key"), nil)
+	u := r.URL("10.0.0.1:8080", "user1", "cam 1")
+
+	assert.True(t, strings.HasPrefix(u, "rtsp://10.0.0.1:8554/dXNlcjE."))
+	assert.True(t, strings.HasPrefix(NewRelay(":554", []byte("key"), nil).URL("srv", "user1", "cam1"), "rtsp://srv/"))
+
+	token, uid, track := parseRelayPath(u + "/trackID=1")
+	assert.Equal(t, "cam 1", uid)
+	assert.Equal(t, "trackID=1", track)
+
+	login, ok := r.checkToken(token, uid)
+	assert.True(t, ok)
+	assert.Equal(t, "user1", login)
+
+	_, ok = r.checkToken(token, "cam2")
+	assert.False(t, ok)
+
+	_, ok = NewRelay(":8554", []byte("other"), nil).checkToken(token, uid)
+	assert.False(t, ok)
+}
+
+func TestRelay(t *testing.T) {
+	cam := new(fakeCamera)
+	camAddr := listenTCP(t, cam.serve)
+
+	feeds := map[string]*model.Feed2{
+		"cam1":   {UID: "cam1", URL: "rtsp://" + camAddr + "/stream", Relay: true},
+		"direct": {UID: "direct", URL: "rtsp://" + camAddr + "/stream"},
+	}
+
+	r := NewRelay("127.0.0.1:0", []byte("key"), func(login, uid string) *model.Feed2 {
+		if login != "user1" {
+			return nil
+		}
+
+		return feeds[uid]
+	})
+	require.NoError(t, r.Start())
+
+	defer r.Stop()
+
+	clients := make([]*rtspClient, 3)
+
+	for i := range clients {
+		c, err := dialRTSP(context.Background(), r.URL(r.Addr(), "user1", "cam1"))
+		require.NoError(t, err)
+
+		defer c.Close()
+
+		setDeadline(c.conn, time.Second*5)
+
+		s, err := c.start()
+		require.NoError(t, err)
+		assert.Len(t, s.medias, 2)
+
+		clients[i] = c
+	}
+
+	for _, c := range clients {
+		got := make(map[byte]int)
+
+		for len(got) < 2 || got[0] < 5 {
+			ch, data, err := c.readPacket()
+			require.NoError(t, err)
+			assert.Len(t, data, 12)
+
+			got[ch]++
+		}
+
+		assert.Contains(t, got, byte(2))
+	}
+
+	assert.Equal(t, int32(1), cam.conns.Load())
+	assert.Equal(t, int32(1), cam.plays.Load())
+
+	stats := r.Stats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, "cam1", stats[0].UID)
+	assert.Equal(t, 3, stats[0].Clients)
+
+	// source is released after the last client leaves
+	for _, c := range clients {
+		_ = c.Close()
+	}
+
+	assert.Eventually(t, func() bool { return len(r.Stats()) == 0 }, time.Second*5, time.Millisecond*10)
+
+	for _, tc := range []struct {
+		url    string
+		status string
+	}{
+		{r.URL(r.Addr(), "user1", "direct"), "404"},
+		{r.URL(r.Addr(), "user1", "unknown"), "404"},
+		// feed is not visible to the user
+		{r.URL(r.Addr(), "user2", "cam1"), "404"},
+		{"rtsp://" + r.Addr() + "/cam1", "403"},
+		{strings.Replace(r.URL(r.Addr(), "user1", "direct"), "/direct", "/cam1", 1), "403"},
+	} {
+		c, err := dialRTSP(context.Background(), tc.url)
+		require.NoError(t, err)
+
+		_, err = c.describe()
+		require.ErrorIs(t, err, errRtspStatus)
+		assert.Contains(t, err.Error(), tc.status)
+
+		_ = c.Close()
+	}
+}
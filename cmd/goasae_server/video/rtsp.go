@@ -0,0 +1,519 @@
+package video
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rtspProto     = "RTSP/1.0"
+	rtspUserAgent = "goasae"
+	maxPacket     = 65535
+)
+
+var errRtspStatus = errors.New("rtsp error")
+
+type rtspResponse struct {
+	status int
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// rtspClient pulls stream with RTP interleaved in RTSP TCP connection
+type rtspClient struct {
+	mx      sync.Mutex
+	conn    net.Conn
+	r       *bufio.Reader
+	url     *url.URL
+	cseq    int
+	session string
+	auth    func(method, uri string) string
+}
+
+func dialRTSP(ctx context.Context, u string) (*rtspClient, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+
+	if pu.Scheme != "rtsp" {
+		return nil, fmt.Errorf("unsupported protocol %s", pu.Scheme)
+	}
+
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", hostPort(pu, "554"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &rtspClient{conn: conn, r: bufio.NewReader(conn), url: pu}, nil
+}
+
+func (c *rtspClient) Close() error {
+	return c.conn.Close()
+}
+
+// uri returns url without credentials
+func (c *rtspClient) uri() string {
+	u := *c.url
+	u.User = nil
+
+	return u.String()
+}
+
+// do sends request and reads the answer, request is repeated with credentials on 401
+func (c *rtspClient) do(method, uri string, hdr map[string]string) (*rtspResponse, error) {
+	res, err := c.request(method, uri, hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.status == 401 && c.auth == nil && c.url.User != nil {
+		if c.auth = authFunc(c.url.User, res.header.Get("WWW-Authenticate")); c.auth != nil {
+			res, err = c.request(method, uri, hdr)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if res.status != 200 {
+		return res, fmt.Errorf("%w: %s %s: status %d", errRtspStatus, method, uri, res.status)
+	}
+
+	return res, nil
+}
+
+func (c *rtspClient) request(method, uri string, hdr map[string]string) (*rtspResponse, error) {
+	if err := c.send(method, uri, hdr); err != nil {
+		return nil, err
+	}
+
+	for {
+		b, err := c.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+
+		// data packets can come before the answer
+		if b[0] == '$' {
+			if _, _, err := readInterleaved(c.r); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		return readResponse(c.r)
+	}
+}
+
+func (c *rtspClient) send(method, uri string, hdr map[string]string) error {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.cseq++
+
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s %s %s\r\nCSeq: %d\r\nUser-Agent: %s\r\n", method, uri, rtspProto, c.cseq, rtspUserAgent)
+
+	if c.session != "" {
+		fmt.Fprintf(&sb, "Session: %s\r\n", c.session)
+	}
+
+	if c.auth != nil {
+		fmt.Fprintf(&sb, "Authorization: %s\r\n", c.auth(method, uri))
+	}
+
+	for k, v := range hdr {
+		fmt.Fprintf(&sb, "%s: %s\r\n", k, v)
+	}
+
+	sb.WriteString("\r\n")
+
+	_, err := io.WriteString(c.conn, sb.String())
+
+	return err
+}
+
+// describe returns session description and base url for media controls
+func (c *rtspClient) describe() (*sdp, error) {
+	res, err := c.do("DESCRIBE", c.uri(), map[string]string{"Accept": "application/sdp"})
+	if err != nil {
+		return nil, err
+	}
+
+	base := res.header.Get("Content-Base")
+	if base == "" {
+		base = res.header.Get("Content-Location")
+	}
+
+	if base == "" {
+		base = c.uri()
+	}
+
+	return parseSDP(res.body, base), nil
+}
+
+func (c *rtspClient) setup(uri string, channel int) error {
+	res, err := c.do("SETUP", uri, map[string]string{
+		"Transport": fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", channel, channel+1),
+	})
+	if err != nil {
+		return err
+	}
+
+	if s, _, _ := strings.Cut(res.header.Get("Session"), ";"); s != "" {
+		c.session = s
+	}
+
+	return nil
+}
+
+func (c *rtspClient) play() error {
+	_, err := c.do("PLAY", c.uri(), map[string]string{"Range": "npt=0.000-"})
+
+	return err
+}
+
+// start makes DESCRIBE, SETUP for all media and PLAY. Media n is received at channel 2*n
+func (c *rtspClient) start() (*sdp, error) {
+	s, err := c.describe()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.medias) == 0 {
+		return nil, fmt.Errorf("no media in stream")
+	}
+
+	for i, m := range s.medias {
+		if err := c.setup(m.control, i*2); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, c.play()
+}
+
+// keepalive sends OPTIONS to keep session, answer is skipped by readPacket
+func (c *rtspClient) keepalive() error {
+	return c.send("OPTIONS", c.uri(), nil)
+}
+
+// readPacket returns next interleaved packet, RTSP answers are skipped
+func (c *rtspClient) readPacket() (byte, []byte, error) {
+	for {
+		b, err := c.r.Peek(1)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if b[0] == '$' {
+			return readInterleaved(c.r)
+		}
+
+		if _, err := readResponse(c.r); err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+func readInterleaved(r *bufio.Reader) (byte, []byte, error) {
+	var h [4]byte
+
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(h[2:]))
+
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+
+	return h[1], data, nil
+}
+
+func writeInterleaved(w io.Writer, channel byte, data []byte) error {
+	if len(data) > maxPacket {
+		return fmt.Errorf("packet is too large")
+	}
+
+	buf := make([]byte, 4+len(data))
+	buf[0] = '$'
+	buf[1] = channel
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(data)))
+	copy(buf[4:], data)
+
+	_, err := w.Write(buf)
+
+	return err
+}
+
+func readResponse(r *bufio.Reader) (*rtspResponse, error) {
+	tr := textproto.NewReader(r)
+
+	line, err := tr.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	proto, rest, _ := strings.Cut(line, " ")
+	if !strings.HasPrefix(proto, "RTSP/") {
+		return nil, fmt.Errorf("invalid rtsp answer %q", line)
+	}
+
+	code, _, _ := strings.Cut(rest, " ")
+
+	status, err := strconv.Atoi(code)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rtsp answer %q", line)
+	}
+
+	hdr, err := tr.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	res := &rtspResponse{status: status, header: hdr}
+
+	if n, _ := strconv.Atoi(hdr.Get("Content-Length")); n > 0 {
+		res.body = make([]byte, n)
+
+		if _, err := io.ReadFull(r, res.body); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// authFunc makes Authorization header function for Basic or Digest challenge
+func authFunc(user *url.Userinfo, challenge string) func(method, uri string) string {
+	name := user.Username()
+	pass, _ := user.Password()
+
+	scheme, params, _ := strings.Cut(challenge, " ")
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		v := "Basic " + base64.StdEncoding.EncodeToString([]byte(name+":"+pass))
+
+		return func(string, string) string { return v }
+	case "digest":
+		p := parseAuthParams(params)
+		realm, nonce := p["realm"], p["nonce"]
+		ha1 := md5hex(name + ":" + realm + ":" + pass)
+
+		return func(method, uri string) string {
+			resp := md5hex(ha1 + ":" + nonce + ":" + md5hex(method+":"+uri))
+
+			return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`, name, realm, nonce, uri, resp)
+		}
+	default:
+		return nil
+	}
+}
+
+func parseAuthParams(s string) map[string]string {
+	res := make(map[string]string)
+
+	for _, p := range strings.Split(s, ",") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok {
+			res[strings.ToLower(k)] = strings.Trim(v, `"`)
+		}
+	}
+
+	return res
+}
+
+func md5hex(s string) string {
+	h := md5.Sum([]byte(s))
+
+	return hex.EncodeToString(h[:])
+}
+
+type sdpMedia struct {
+	lines   []string
+	control string
+}
+
+// sdp keeps session description split into session part and media sections
+type sdp struct {
+	session []string
+	medias  []*sdpMedia
+}
+
+func parseSDP(b []byte, base string) *sdp {
+	s := new(sdp)
+
+	var m *sdpMedia
+
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "m=") {
+			m = &sdpMedia{control: base}
+			s.medias = append(s.medias, m)
+		}
+
+		if m == nil {
+			if !strings.HasPrefix(line, "a=control:") {
+				s.session = append(s.session, line)
+			}
+
+			continue
+		}
+
+		if c, ok := strings.CutPrefix(line, "a=control:"); ok {
+			m.control = controlURL(base, c)
+
+			continue
+		}
+
+		m.lines = append(m.lines, line)
+	}
+
+	return s
+}
+
+func controlURL(base, control string) string {
+	if control == "*" || control == "" {
+		return base
+	}
+
+	if strings.HasPrefix(control, "rtsp://") {
+		return control
+	}
+
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+
+	return base + control
+}
+
+// bytes returns description with media controls trackID=n
+func (s *sdp) bytes() []byte {
+	var sb strings.Builder
+
+	for _, l := range s.session {
+		sb.WriteString(l + "\r\n")
+	}
+
+	for i, m := range s.medias {
+		for _, l := range m.lines {
+			sb.WriteString(l + "\r\n")
+		}
+
+		fmt.Fprintf(&sb, "a=control:trackID=%d\r\n", i)
+	}
+
+	return []byte(sb.String())
+}
+
+// rtspRequest is a request received by relay server
+type rtspRequest struct {
+	method string
+	uri    string
+	header textproto.MIMEHeader
+}
+
+func readRequest(r *bufio.Reader) (*rtspRequest, error) {
+	tr := textproto.NewReader(r)
+
+	line, err := tr.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Fields(line)
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "RTSP/") {
+		return nil, fmt.Errorf("invalid rtsp request %q", line)
+	}
+
+	hdr, err := tr.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	// body is not used by relay
+	if n, _ := strconv.Atoi(hdr.Get("Content-Length")); n > 0 {
+		if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &rtspRequest{method: parts[0], uri: parts[1], header: hdr}, nil
+}
+
+// interleaved parses interleaved=a-b from Transport header
+func interleaved(transport string) (int, int, bool) {
+	for _, p := range strings.Split(transport, ";") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(p), "interleaved="); ok {
+			a, b, found := strings.Cut(v, "-")
+
+			x, err1 := strconv.Atoi(a)
+			if err1 != nil {
+				return 0, 0, false
+			}
+
+			if !found {
+				return x, x + 1, true
+			}
+
+			y, err2 := strconv.Atoi(b)
+
+			return x, y, err2 == nil
+		}
+	}
+
+	return 0, 0, false
+}
+
+func writeResponse(w io.Writer, status int, reason string, hdr map[string]string, body []byte) error {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s %d %s\r\n", rtspProto, status, reason)
+
+	for k, v := range hdr {
+		fmt.Fprintf(&sb, "%s: %s\r\n", k, v)
+	}
+
+	if len(body) > 0 {
+		fmt.Fprintf(&sb, "Content-Length: %d\r\n", len(body))
+	}
+
+	sb.WriteString("\r\n")
+	sb.Write(body)
+
+	_, err := io.WriteString(w, sb.String())
+
+	return err
+}
+
+func setDeadline(c net.Conn, d time.Duration) {
+	if d > 0 {
+		_ = c.SetDeadline(time.Now().Add(d))
+	}
+}
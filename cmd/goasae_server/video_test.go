@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kdudkov/goasae/cmd/goasae_server/video"
+	im "github.com/kdudkov/goasae/internal/model"
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+func TestFeedACL(t *testing.T) {
+	owner := &im.User{Login: "owner", Scope: "s2"}
+	user := &im.User{Login: "user", Scope: "s1", Role: "Team Lead"}
+	other := &im.User{Login: "other", Scope: "s1", Role: "Medic"}
+	reader := &im.User{Login: "reader", Scope: "s3", ReadScope: []string{"s1"}}
+
+	f := &model.Feed2{UID: "f1", User: "owner", Scope: "s1"}
+
+	assert.True(t, canSeeFeed(owner, f))
+	assert.True(t, canSeeFeed(user, f))
+	assert.True(t, canSeeFeed(reader, f))
+	assert.False(t, canSeeFeed(nil, f))
+
+	f.Roles = []string{"Team Lead", "HQ"}
+
+	assert.True(t, canSeeFeed(owner, f))
+	assert.True(t, canSeeFeed(user, f))
+	assert.False(t, canSeeFeed(other, f))
+	assert.False(t, canSeeFeed(reader, f))
+
+	assert.True(t, canEditFeed(owner, f))
+	assert.False(t, canEditFeed(user, f))
+
+	f.User = ""
+	assert.True(t, canEditFeed(user, f))
+	assert.False(t, canEditFeed(owner, f))
+}
+
+func TestClientFeed(t *testing.T) {
+	f := &model.Feed2{UID: "f1", URL: "rtsp://cam/stream", Relay: true}
+
+	app := &App{}
+	assert.Equal(t, "rtsp://cam/stream", app.clientFeed("srv", "user1", f).URL)
+
+	app.relay = video.NewRelay(":8554", []byte("key"), nil)
+	assert.Equal(t, app.relay.URL("srv", "user1", "f1"), app.clientFeed("srv", "user1", f).URL)
+	assert.NotEqual(t, app.clientFeed("srv", "user2", f).URL, app.clientFeed("srv", "user1", f).URL)
+	assert.Equal(t, "rtsp://cam/stream", f.URL)
+
+	f.Relay = false
+	assert.Equal(t, "rtsp://cam/stream", app.clientFeed("srv", "user1", f).URL)
+}
@@ -87,6 +87,12 @@ func (app *App) Run(cmd string, args []string) {
 			return
 		}
 		app.getFile(args[0], args[1])
+	case "upload", "put":
+		if len(args) != 1 {
+			fmt.Println("need file name")
+			return
+		}
+		app.upload(args[0])
 	default:
 		app.UI()
 	}
@@ -123,6 +129,21 @@ func (app *App) getFile(hash string, name string) {
 	}
 }
 
+func (app *App) upload(name string) {
+	url, err := app.remoteAPI.Upload(context.Background(), name, true, func(done, total int64) {
+		fmt.Printf("\r%s: %d of %d bytes", name, done, total)
+	})
+
+	fmt.Println()
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println(url)
+}
+
 func (app *App) UI() {
 	if m, err := app.remoteAPI.GetMissions(context.Background()); err == nil {
 		for _, mm := range m {
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	uploadChunk   = 8 * 1024 * 1024
+	uploadRetries = 5
+	uploadPath    = "/Marti/sync/upload/resumable"
+	// maxBusyWait limits delay between retries while server is busy with the upload
+	maxBusyWait = time.Second * 30
+)
+
+var (
+	// errUploadRejected is not retried
+	errUploadRejected = errors.New("upload is rejected by server")
+	// errUploadBusy is retried until server is done with other request of the upload
+	errUploadBusy = errors.New("upload is busy")
+)
+
+// Upload sends file with resumable upload. Upload id is kept in file.upload, so interrupted upload
+// is continued by the next call. Returns package url
+func (r *RemoteAPI) Upload(ctx context.Context, name string, pack bool, progress func(done, total int64)) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	hash := hex.EncodeToString(h.Sum(nil))
+	size := st.Size()
+	session := name + ".upload"
+	client := r.uploadClient()
+
+	var id string
+	offset := int64(-1)
+
+	if b, err := os.ReadFile(session); err == nil {
+		id = strings.TrimSpace(string(b))
+
+		if offset, err = r.uploadOffset(ctx, client, id); err != nil {
+			r.logger.Info("can't resume upload " + id + ": " + err.Error())
+			offset = -1
+		}
+	}
+
+	if offset < 0 {
+		if id, err = r.createUpload(ctx, client, filepath.Base(name), hash, size, pack); err != nil {
+			return "", err
+		}
+
+		if err := os.WriteFile(session, []byte(id), 0600); err != nil {
+			return "", err
+		}
+
+		offset = 0
+	}
+
+	buf := make([]byte, uploadChunk)
+	retries, busy := 0, 0
+	// server can save the file after the last chunk even if its answer is lost
+	sentAll := false
+
+	for {
+		if progress != nil {
+			progress(offset, size)
+		}
+
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+
+		if offset+int64(n) >= size {
+			sentAll = true
+		}
+
+		res, err := r.patchUpload(ctx, client, id, offset, buf[:n])
+
+		if err == nil && res.done {
+			_ = os.Remove(session)
+
+			return res.url, nil
+		}
+
+		if err == nil {
+			offset, retries, busy = res.offset, 0, 0
+
+			continue
+		}
+
+		if errors.Is(err, errUploadBusy) {
+			busy++
+			r.logger.Info(fmt.Sprintf("upload is busy, wait %d", busy))
+
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(min(time.Second*time.Duration(busy), maxBusyWait)):
+			}
+
+			o, err1 := r.uploadOffset(ctx, client, id)
+			if err1 == nil {
+				offset = o
+
+				continue
+			}
+
+			err = err1
+		}
+
+		if errors.Is(err, errUploadRejected) {
+			_ = os.Remove(session)
+
+			if sentAll {
+				if u, ok := r.uploaded(ctx, client, hash, pack); ok {
+					return u, nil
+				}
+			}
+
+			return "", err
+		}
+
+		if retries++; retries > uploadRetries || ctx.Err() != nil {
+			return "", err
+		}
+
+		r.logger.Warn(fmt.Sprintf("upload error, retry %d: %s", retries, err.Error()))
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Second * time.Duration(retries)):
+		}
+
+		if o, err := r.uploadOffset(ctx, client, id); err == nil {
+			offset = o
+		}
+	}
+}
+
+// uploadClient has no response timeout, as server saves the file after the last chunk
+func (r *RemoteAPI) uploadClient() *http.Client {
+	if t, ok := r.client.Transport.(*http.Transport); ok {
+		t = t.Clone()
+		t.ResponseHeaderTimeout = 0
+
+		return &http.Client{Transport: t}
+	}
+
+	return r.client
+}
+
+func (r *RemoteAPI) createUpload(ctx context.Context, client *http.Client, name, hash string, size int64, pack bool) (string, error) {
+	q := url.Values{
+		"name":    {name},
+		"hash":    {hash},
+		"size":    {strconv.FormatInt(size, 10)},
+		"package": {strconv.FormatBool(pack)},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.getURL(uploadPath)+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("upload create error: %s", res.Status)
+	}
+
+	var u struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&u); err != nil {
+		return "", err
+	}
+
+	return u.ID, nil
+}
+
+func (r *RemoteAPI) uploadOffset(ctx context.Context, client *http.Client, id string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, r.getURL(uploadPath+"/"+id), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	_ = res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return strconv.ParseInt(res.Header.Get("Upload-Offset"), 10, 64)
+	case http.StatusNotFound:
+		return 0, fmt.Errorf("%w: %s", errUploadRejected, res.Status)
+	default:
+		return 0, fmt.Errorf("upload status error: %s", res.Status)
+	}
+}
+
+// uploaded checks if file with hash is stored by server and returns its url like the last chunk answer does
+func (r *RemoteAPI) uploaded(ctx context.Context, client *http.Client, hash string, pack bool) (string, bool) {
+	path := "/Marti/sync/content?hash=" + hash
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, r.getURL(path), nil)
+	if err != nil {
+		return "", false
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", false
+	}
+
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", false
+	}
+
+	if pack {
+		return r.getURL(path), true
+	}
+
+	return path, true
+}
+
+type patchResult struct {
+	offset int64
+	done   bool
+	url    string
+}
+
+func (r *RemoteAPI) patchUpload(ctx context.Context, client *http.Client, id string, offset int64, data []byte) (*patchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, r.getURL(uploadPath+"/"+id), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	b, _ := io.ReadAll(res.Body)
+
+	switch res.StatusCode {
+	case http.StatusCreated:
+		return &patchResult{done: true, url: string(b)}, nil
+	case http.StatusLocked:
+		return nil, errUploadBusy
+	case http.StatusNoContent, http.StatusConflict:
+		o, err := strconv.ParseInt(res.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return &patchResult{offset: o}, nil
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return nil, fmt.Errorf("upload error: %s", res.Status)
+	default:
+		return nil, fmt.Errorf("%w: %s %s", errUploadRejected, res.Status, strings.TrimSpace(string(b)))
+	}
+}
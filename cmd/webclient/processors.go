@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"os"
 	"strings"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 
@@ -93,6 +94,13 @@ func (app *App) chatProcessor(msg *cot.CotMessage) {
 }
 
 func (app *App) chatReceiptProcessor(msg *cot.CotMessage) {
+	if msg.GetType() != "b-t-f-d" && msg.GetType() != "b-t-f-r" {
+		return
+	}
+
+	if c := app.chatMessages.Receipt(model.ChatReceiptID(msg), msg.GetType() == "b-t-f-r", time.Now()); c != nil {
+		app.chatCb.AddMessage(c)
+	}
 }
 
 func (app *App) saveItemProcessor(msg *cot.CotMessage) {
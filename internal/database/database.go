@@ -0,0 +1,112 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	DialectSqlite   = "sqlite"
+	DialectPostgres = "postgres"
+
+	// DefaultName is the sqlite database file name used when no dsn is configured
+	DefaultName = "db.sqlite"
+)
+
+type Config struct {
+	// DSN is a sqlite file name or "sqlite:" / "file:" url, or a PostgreSQL "postgres://" url or "host=..." string.
+	// Empty means DefaultName in data dir
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// Dialect returns database dialect for dsn
+func Dialect(dsn string) string {
+	s := strings.ToLower(strings.TrimSpace(dsn))
+
+	if strings.HasPrefix(s, "postgres://") || strings.HasPrefix(s, "postgresql://") || strings.HasPrefix(s, "host=") {
+		return DialectPostgres
+	}
+
+	return DialectSqlite
+}
+
+// Open connects to the database and applies pool options, relative sqlite paths are resolved against dataDir
+func Open(cfg Config, dataDir string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+
+	switch Dialect(cfg.DSN) {
+	case DialectPostgres:
+		dialector = postgres.Open(cfg.DSN)
+	default:
+		dialector = sqlite.Open(sqlitePath(cfg.DSN, dataDir))
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+
+	if cfg.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+
+	if cfg.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+
+	return db, nil
+}
+
+func sqlitePath(dsn, dataDir string) string {
+	dsn = strings.TrimPrefix(strings.TrimSpace(dsn), "sqlite:")
+
+	if dsn == "" {
+		p := filepath.Join(dataDir, DefaultName)
+
+		// database from older versions is kept in working dir
+		if !exists(p) && exists(DefaultName) {
+			slog.Default().Warn(fmt.Sprintf("using %s from working dir, move it to %s", DefaultName, dataDir))
+
+			return DefaultName
+		}
+
+		if err := os.MkdirAll(dataDir, 0o777); err != nil {
+			slog.Default().Error("can't create data dir", slog.Any("error", err))
+		}
+
+		return p
+	}
+
+	if dsn == ":memory:" || strings.HasPrefix(dsn, "file:") || filepath.IsAbs(dsn) {
+		return dsn
+	}
+
+	return filepath.Join(dataDir, dsn)
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+
+	return err == nil
+}
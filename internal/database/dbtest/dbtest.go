@@ -0,0 +1,125 @@
+// Package dbtest runs tests against every supported database.
+// SQLite in memory is always used, PostgreSQL is used when GOASAE_TEST_POSTGRES_DSN is set
+// or when tests are built with pgembed tag.
+package dbtest
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/kdudkov/goasae/internal/database"
+)
+
+const EnvPostgresDSN = "GOASAE_TEST_POSTGRES_DSN"
+
+var (
+	once    sync.Once
+	pgDSN   string
+	pgErr   error
+	stopper func()
+)
+
+// Main runs tests and stops embedded database, use it in TestMain
+func Main(m *testing.M) {
+	code := m.Run()
+
+	if stopper != nil {
+		stopper()
+	}
+
+	os.Exit(code)
+}
+
+// ForEach calls fn with migrated empty database for every available dialect
+func ForEach(t *testing.T, fn func(t *testing.T, db *gorm.DB)) {
+	t.Helper()
+
+	t.Run(database.DialectSqlite, func(t *testing.T) {
+		fn(t, Sqlite(t))
+	})
+
+	if dsn := postgresDSN(t); dsn != "" {
+		t.Run(database.DialectPostgres, func(t *testing.T) {
+			fn(t, Postgres(t, dsn))
+		})
+	}
+}
+
+// Sqlite returns migrated in-memory database
+func Sqlite(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := database.Open(database.Config{DSN: ":memory:", MaxOpenConns: 1}, "")
+	require.NoError(t, err)
+	require.NoError(t, database.Migrate(db))
+
+	return db
+}
+
+// Postgres returns migrated database using new schema, schema is dropped after test
+func Postgres(t *testing.T, dsn string) *gorm.DB {
+	t.Helper()
+
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+
+	admin, err := database.Open(database.Config{DSN: dsn, MaxOpenConns: 1}, "")
+	require.NoError(t, err)
+	require.NoError(t, admin.Exec("CREATE SCHEMA "+schema).Error)
+
+	db, err := database.Open(database.Config{DSN: withSearchPath(dsn, schema)}, "")
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+
+		if sqlDB, err := admin.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	require.NoError(t, database.Migrate(db))
+
+	return db
+}
+
+func postgresDSN(t *testing.T) string {
+	if dsn := os.Getenv(EnvPostgresDSN); dsn != "" {
+		return dsn
+	}
+
+	if startEmbedded == nil {
+		return ""
+	}
+
+	once.Do(func() {
+		pgDSN, stopper, pgErr = startEmbedded()
+	})
+
+	require.NoError(t, pgErr, "embedded postgres")
+
+	return pgDSN
+}
+
+func withSearchPath(dsn, schema string) string {
+	if database.Dialect(dsn) == database.DialectPostgres && strings.Contains(dsn, "://") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+
+		return dsn + sep + "search_path=" + schema
+	}
+
+	return fmt.Sprintf("%s search_path=%s", dsn, schema)
+}
@@ -0,0 +1,39 @@
+//go:build pgembed
+
+package dbtest
+
+import (
+	"io"
+	"os"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+)
+
+const embeddedPort = 15432
+
+var startEmbedded = func() (string, func(), error) {
+	dir, err := os.MkdirTemp("", "goasae-pg-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	cfg := embeddedpostgres.DefaultConfig().
+		Port(embeddedPort).
+		RuntimePath(dir).
+		Logger(io.Discard)
+
+	pg := embeddedpostgres.NewDatabase(cfg)
+
+	if err := pg.Start(); err != nil {
+		_ = os.RemoveAll(dir)
+
+		return "", nil, err
+	}
+
+	stop := func() {
+		_ = pg.Stop()
+		_ = os.RemoveAll(dir)
+	}
+
+	return cfg.GetConnectionURL() + "?sslmode=disable", stop, nil
+}
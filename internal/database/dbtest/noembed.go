@@ -0,0 +1,5 @@
+//go:build !pgembed
+
+package dbtest
+
+var startEmbedded func() (string, func(), error)
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/kdudkov/goasae/internal/model"
+)
+
+// Migration is a single schema change, migrations are applied once in order of the list
+type Migration struct {
+	ID      string
+	Migrate func(tx *gorm.DB) error
+}
+
+type SchemaMigration struct {
+	ID        string `gorm:"primaryKey;size:255"`
+	AppliedAt time.Time
+}
+
+// migrations must only be appended, applied ones are never changed
+var migrations = []*Migration{
+	{
+		ID: "0001_baseline",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(
+				&model.Mission{},
+				&model.Subscription{},
+				&model.Invitation{},
+				&model.DataItem{},
+				&model.Change{},
+				&model.ExternalData{},
+				&model.LogEntry{},
+				&model.ChatMessage{},
+				&model.OutboxItem{},
+			)
+		},
+	},
+	{
+		ID: "0002_mission_snapshots",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(
+				&model.Change{},
+				&model.MissionSnapshot{},
+				&model.SnapshotItem{},
+				&model.SnapshotBlob{},
+			)
+		},
+	},
+	{
+		ID: "0003_package_index",
+		Migrate: func(tx *gorm.DB) error {
+			if err := tx.AutoMigrate(&model.PackageRecord{}); err != nil {
+				return err
+			}
+
+			return packageSearch(tx)
+		},
+	},
+	{
+		ID: "0004_transfers",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&model.Transfer{}, &model.TransferRecipient{})
+		},
+	},
+	{
+		ID: "0005_aoi_items",
+		Migrate: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&model.DataItem{})
+		},
+	},
+	{
+		ID:      "0006_mission_passwords",
+		Migrate: hashMissionPasswords,
+	},
+}
+
+// packageSearch makes text index of package records: fts5 trigram table kept by triggers in sqlite
+// and pg_trgm index in PostgreSQL
+func packageSearch(tx *gorm.DB) error {
+	stmts := []string{
+		"CREATE VIRTUAL TABLE package_search USING fts5(text, content = 'package_records', tokenize = 'trigram')",
+		`CREATE TRIGGER package_records_ai AFTER INSERT ON package_records BEGIN
+			INSERT INTO package_search (rowid, text) VALUES (new.rowid, new.text);
+		END`,
+		`CREATE TRIGGER package_records_ad AFTER DELETE ON package_records BEGIN
+			INSERT INTO package_search (package_search, rowid, text) VALUES ('delete', old.rowid, old.text);
+		END`,
+		`CREATE TRIGGER package_records_au AFTER UPDATE ON package_records BEGIN
+			INSERT INTO package_search (package_search, rowid, text) VALUES ('delete', old.rowid, old.text);
+			INSERT INTO package_search (rowid, text) VALUES (new.rowid, new.text);
+		END`,
+	}
+
+	if tx.Dialector.Name() == DialectPostgres {
+		stmts = []string{
+			"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+			"CREATE INDEX idx_package_records_text ON package_records USING gin (text gin_trgm_ops)",
+		}
+	}
+
+	for _, s := range stmts {
+		if err := tx.Exec(s).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hashMissionPasswords replaces plain text passwords of missions made before passwords were hashed
+func hashMissionPasswords(tx *gorm.DB) error {
+	var list []*model.Mission
+
+	if err := tx.Select("id", "password").Where("password <> '' AND password NOT LIKE ?", "$2%").Find(&list).Error; err != nil {
+		return err
+	}
+
+	for _, m := range list {
+		if _, err := m.HashPassword(); err != nil {
+			return err
+		}
+
+		if err := tx.Model(m).Update("password", m.Password).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Migrate applies all pending migrations
+func Migrate(db *gorm.DB) error {
+	return apply(db, migrations)
+}
+
+// Applied returns ids of applied migrations
+func Applied(db *gorm.DB) ([]string, error) {
+	var ids []string
+
+	err := db.Model(&SchemaMigration{}).Order("id").Pluck("id", &ids).Error
+
+	return ids, err
+}
+
+func apply(db *gorm.DB, list []*Migration) error {
+	if db == nil {
+		return fmt.Errorf("no database")
+	}
+
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+
+	applied, err := Applied(db)
+	if err != nil {
+		return err
+	}
+
+	done := make(map[string]bool, len(applied))
+	for _, id := range applied {
+		done[id] = true
+	}
+
+	for _, m := range list {
+		if done[m.ID] {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Migrate(tx); err != nil {
+				return err
+			}
+
+			return tx.Create(&SchemaMigration{ID: m.ID, AppliedAt: time.Now()}).Error
+		})
+
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", m.ID, err)
+		}
+
+		slog.Default().Info("migration " + m.ID + " applied")
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package geofence
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+const (
+	EventEnter = "enter"
+	EventExit  = "exit"
+	EventDwell = "dwell"
+
+	SourceAPI     = "api"
+	SourceDrawing = "drawing"
+)
+
+type Fence struct {
+	ID     string   `json:"id"               yaml:"id"`
+	Name   string   `json:"name"             yaml:"name"`
+	Scope  string   `json:"scope"            yaml:"scope"`
+	Lat    float64  `json:"lat,omitempty"    yaml:"lat,omitempty"`
+	Lon    float64  `json:"lon,omitempty"    yaml:"lon,omitempty"`
+	Radius float64  `json:"radius,omitempty" yaml:"radius,omitempty"`
+	Points []*Point `json:"points,omitempty" yaml:"points,omitempty"`
+	// callsigns to notify, if empty - global list is used
+	Notify []string `json:"notify,omitempty" yaml:"notify,omitempty"`
+	// dwell time in seconds, 0 - no dwell events
+	Dwell  int    `json:"dwell,omitempty" yaml:"dwell,omitempty"`
+	Source string `json:"source"          yaml:"source"`
+
+	poly []*model.Pos
+}
+
+type Point struct {
+	Lat float64 `json:"lat" yaml:"lat"`
+	Lon float64 `json:"lon" yaml:"lon"`
+}
+
+type Event struct {
+	Type      string    `json:"type"`
+	FenceID   string    `json:"fence_id"`
+	FenceName string    `json:"fence_name"`
+	UID       string    `json:"uid"`
+	Callsign  string    `json:"callsign"`
+	Scope     string    `json:"scope"`
+	Time      time.Time `json:"time"`
+	Lat       float64   `json:"lat"`
+	Lon       float64   `json:"lon"`
+	Notify    []string  `json:"-"`
+}
+
+func (f *Fence) IsValid() bool {
+	// id is used as a file name
+	if f == nil || f.ID == "" || strings.ContainsAny(f.ID, `/\`) || strings.Contains(f.ID, "..") {
+		return false
+	}
+
+	return len(f.Points) >= 3 || f.Radius > 0
+}
+
+func (f *Fence) prepare() {
+	f.poly = make([]*model.Pos, len(f.Points))
+
+	for i, p := range f.Points {
+		f.poly[i] = model.NewPos(p.Lat, p.Lon)
+	}
+}
+
+func (f *Fence) Contains(lat, lon float64) bool {
+	if len(f.poly) >= 3 {
+		return model.PointInPolygon(lat, lon, f.poly)
+	}
+
+	return model.PointInCircle(lat, lon, f.Lat, f.Lon, f.Radius)
+}
+
+// IsDrawing checks if message is a drawing that can be used as a fence
+func IsDrawing(msg *cot.CotMessage) bool {
+	return cot.MatchAnyPattern(msg.GetType(), "u-d-f", "u-d-f-m", "u-d-r", "u-d-c-c")
+}
+
+// FromDrawing makes fence from u-d-f/u-d-r polygon or u-d-c-c circle
+func FromDrawing(msg *cot.CotMessage) *Fence {
+	f := &Fence{
+		ID:     msg.GetUID(),
+		Name:   msg.GetCallsign(),
+		Scope:  msg.Scope,
+		Source: SourceDrawing,
+	}
+
+	if msg.GetType() == "u-d-c-c" {
+		el := msg.GetDetail().GetFirst("shape").GetFirst("ellipse")
+		major, _ := strconv.ParseFloat(el.GetAttr("major"), 64)
+		minor, _ := strconv.ParseFloat(el.GetAttr("minor"), 64)
+
+		f.Lat, f.Lon = msg.GetLatLon()
+		f.Radius = max(major, minor)
+	} else {
+		for _, l := range msg.GetDetail().GetAll("link") {
+			if p := parsePoint(l.GetAttr("point")); p != nil {
+				f.Points = append(f.Points, p)
+			}
+		}
+	}
+
+	if !f.IsValid() {
+		return nil
+	}
+
+	return f
+}
+
+func parsePoint(s string) *Point {
+	parts := strings.Split(s, ",")
+	if len(parts) < 2 {
+		return nil
+	}
+
+	lat, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	lon, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+
+	return &Point{Lat: lat, Lon: lon}
+}
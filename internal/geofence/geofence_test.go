@@ -0,0 +1,107 @@
+package geofence
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/cotproto"
+)
+
+func TestCheck(t *testing.T) {
+	m := New("")
+
+	require.NoError(t, m.Put(&Fence{ID: "f1", Name: "circle", Lat: 60, Lon: 30, Radius: 1000, Dwell: 60, Source: SourceAPI}))
+	require.NoError(t, m.Put(&Fence{ID: "f2", Name: "poly", Scope: "s2", Source: SourceDrawing,
+		Points: []*Point{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 1}, {Lat: 1, Lon: 1}, {Lat: 1, Lon: 0}}}))
+	require.Error(t, m.Put(&Fence{ID: "f3"}))
+	require.Error(t, m.Put(&Fence{ID: "../f4", Radius: 100, Source: SourceAPI}))
+	require.Error(t, m.Put(&Fence{ID: `..\f4`, Radius: 100, Source: SourceAPI}))
+
+	now := time.Now()
+
+	assert.Empty(t, m.Check("uid1", "cs1", "s1", 61, 30, now))
+
+	ev := m.Check("uid1", "cs1", "s1", 60.001, 30, now)
+	require.Len(t, ev, 1)
+	assert.Equal(t, EventEnter, ev[0].Type)
+	assert.Equal(t, "f1", ev[0].FenceID)
+
+	assert.Empty(t, m.Check("uid1", "cs1", "s1", 60.002, 30, now.Add(time.Second*30)))
+
+	ev = m.Check("uid1", "cs1", "s1", 60.002, 30, now.Add(time.Second*61))
+	require.Len(t, ev, 1)
+	assert.Equal(t, EventDwell, ev[0].Type)
+
+	assert.Empty(t, m.Check("uid1", "cs1", "s1", 60.002, 30, now.Add(time.Second*120)))
+
+	ev = m.Check("uid1", "cs1", "s1", 62, 30, now.Add(time.Second*130))
+	require.Len(t, ev, 1)
+	assert.Equal(t, EventExit, ev[0].Type)
+
+	// other scope
+	assert.Empty(t, m.Check("uid1", "cs1", "s1", 0.5, 0.5, now))
+	assert.Len(t, m.Check("uid2", "cs2", "s2", 0.5, 0.5, now), 1)
+
+	assert.False(t, m.Remove(SourceAPI, "f2"))
+	assert.True(t, m.Remove(SourceDrawing, "f2"))
+	assert.Empty(t, m.Check("uid2", "cs2", "s2", 5, 5, now))
+}
+
+func TestSources(t *testing.T) {
+	dir := t.TempDir()
+	m := New(dir)
+	require.NoError(t, m.Start())
+
+	require.NoError(t, m.Put(&Fence{ID: "d1", Name: "api fence", Radius: 100, Source: SourceAPI}))
+
+	// drawing with the same uid is other fence and is not saved
+	f := FromDrawing(drawing("u-d-c-c", 60, 30, `<shape><ellipse major="500" minor="300" angle="360"/></shape>`))
+	require.NotNil(t, f)
+	assert.Equal(t, SourceDrawing, f.Source)
+	require.NoError(t, m.Put(f))
+
+	assert.Equal(t, "api fence", m.Get(SourceAPI, "d1").Name)
+	assert.Equal(t, 500., m.Get(SourceDrawing, "d1").Radius)
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	assert.True(t, m.Remove(SourceDrawing, "d1"))
+	assert.NotNil(t, m.Get(SourceAPI, "d1"))
+
+	m2 := New(dir)
+	require.NoError(t, m2.Start())
+	assert.Len(t, m2.GetList(nil), 1)
+	assert.Equal(t, "api fence", m2.Get(SourceAPI, "d1").Name)
+}
+
+func TestFromDrawing(t *testing.T) {
+	msg := drawing("u-d-c-c", 60, 30, `<shape><ellipse major="500" minor="300" angle="360"/></shape>`)
+	f := FromDrawing(msg)
+	require.NotNil(t, f)
+	assert.Equal(t, 500., f.Radius)
+
+	msg = drawing("u-d-f", 0, 0, `<link point="0,0,0"/><link point="0,1,0"/><link point="1,1,0"/><link point="1,0,0"/>`)
+	f = FromDrawing(msg)
+	require.NotNil(t, f)
+	assert.Len(t, f.Points, 4)
+
+	assert.Nil(t, FromDrawing(drawing("u-d-f", 0, 0, `<link point="0,0,0"/>`)))
+}
+
+func drawing(typ string, lat, lon float64, detail string) *cot.CotMessage {
+	tak := cot.BasicMsg(typ, "d1", time.Minute)
+	tak.CotEvent.Lat = lat
+	tak.CotEvent.Lon = lon
+	tak.CotEvent.Detail = &cotproto.Detail{XmlDetail: detail}
+
+	d, _ := cot.DetailsFromString(detail)
+
+	return &cot.CotMessage{TakMessage: tak, Detail: d, Scope: "s1"}
+}
@@ -0,0 +1,239 @@
+package geofence
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type state struct {
+	inside    bool
+	since     time.Time
+	dwellSent bool
+}
+
+// fenceKey keeps fences of different sources apart, so drawing can't replace api fence with the same id
+type fenceKey struct {
+	source string
+	id     string
+}
+
+type Manager struct {
+	logger  *slog.Logger
+	mx      sync.RWMutex
+	baseDir string
+	fences  map[fenceKey]*Fence
+	// fence -> item uid -> state
+	states map[fenceKey]map[string]*state
+}
+
+func New(baseDir string) *Manager {
+	return &Manager{
+		logger:  slog.Default().With("logger", "geofence"),
+		baseDir: baseDir,
+		fences:  make(map[fenceKey]*Fence),
+		states:  make(map[fenceKey]map[string]*state),
+	}
+}
+
+// Start loads fences created with api
+func (m *Manager) Start() error {
+	if err := os.MkdirAll(m.baseDir, 0777); err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(m.baseDir)
+	if err != nil {
+		return err
+	}
+
+	for _, fl := range files {
+		if fl.IsDir() || !strings.HasSuffix(fl.Name(), ".yml") {
+			continue
+		}
+
+		f, err := m.load(fl.Name())
+		if err != nil {
+			m.logger.Error("error loading fence "+fl.Name(), slog.Any("error", err))
+
+			continue
+		}
+
+		// only api fences are saved
+		f.Source = SourceAPI
+
+		if err := m.put(f, false); err != nil {
+			m.logger.Error("invalid fence "+fl.Name(), slog.Any("error", err))
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) Stop() {
+	// noop
+}
+
+func (m *Manager) load(fname string) (*Fence, error) {
+	dat, err := os.ReadFile(filepath.Join(m.baseDir, fname))
+	if err != nil {
+		return nil, err
+	}
+
+	f := new(Fence)
+
+	return f, yaml.Unmarshal(dat, f)
+}
+
+func (m *Manager) save(f *Fence) error {
+	fl, err := os.Create(filepath.Join(m.baseDir, f.ID+".yml"))
+	if err != nil {
+		return err
+	}
+
+	defer fl.Close()
+
+	return yaml.NewEncoder(fl).Encode(f)
+}
+
+// Put adds or replaces fence. Fences created with api are saved to disk.
+func (m *Manager) Put(f *Fence) error {
+	return m.put(f, f != nil && f.Source == SourceAPI)
+}
+
+func (m *Manager) put(f *Fence, save bool) error {
+	if !f.IsValid() {
+		return fmt.Errorf("invalid fence")
+	}
+
+	f.prepare()
+
+	if save && m.baseDir != "" {
+		if err := m.save(f); err != nil {
+			return err
+		}
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.fences[fenceKey{source: f.Source, id: f.ID}] = f
+
+	return nil
+}
+
+func (m *Manager) Get(source, id string) *Fence {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+
+	return m.fences[fenceKey{source: source, id: id}]
+}
+
+func (m *Manager) Remove(source, id string) bool {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	k := fenceKey{source: source, id: id}
+
+	if _, ok := m.fences[k]; !ok {
+		return false
+	}
+
+	delete(m.fences, k)
+	delete(m.states, k)
+
+	if source == SourceAPI && m.baseDir != "" {
+		_ = os.Remove(filepath.Join(m.baseDir, id+".yml"))
+	}
+
+	return true
+}
+
+func (m *Manager) GetList(filter func(f *Fence) bool) []*Fence {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+
+	res := make([]*Fence, 0, len(m.fences))
+
+	for _, f := range m.fences {
+		if filter == nil || filter(f) {
+			res = append(res, f)
+		}
+	}
+
+	return res
+}
+
+// Check updates item position state and returns enter, exit and dwell events
+func (m *Manager) Check(uid, callsign, scope string, lat, lon float64, now time.Time) []*Event {
+	if lat == 0 && lon == 0 {
+		return nil
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	var events []*Event
+
+	for k, f := range m.fences {
+		if f.Scope != "" && f.Scope != scope {
+			continue
+		}
+
+		st := m.states[k][uid]
+		if st == nil {
+			st = new(state)
+
+			if m.states[k] == nil {
+				m.states[k] = make(map[string]*state)
+			}
+
+			m.states[k][uid] = st
+		}
+
+		inside := f.Contains(lat, lon)
+
+		typ := ""
+
+		switch {
+		case inside && !st.inside:
+			typ = EventEnter
+			st.since = now
+			st.dwellSent = false
+		case !inside && st.inside:
+			typ = EventExit
+		case inside && f.Dwell > 0 && !st.dwellSent && now.Sub(st.since) >= time.Duration(f.Dwell)*time.Second:
+			typ = EventDwell
+			st.dwellSent = true
+		}
+
+		st.inside = inside
+
+		if typ != "" {
+			events = append(events, &Event{
+				Type:      typ,
+				FenceID:   f.ID,
+				FenceName: f.Name,
+				UID:       uid,
+				Callsign:  callsign,
+				Scope:     scope,
+				Time:      now,
+				Lat:       lat,
+				Lon:       lon,
+				Notify:    f.Notify,
+			})
+		}
+	}
+
+	return events
+}
+
+func (e *Event) String() string {
+	return fmt.Sprintf("%s (%s) %s fence %s", e.Callsign, e.UID, e.Type, e.FenceName)
+}
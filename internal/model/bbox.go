@@ -0,0 +1,60 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BBox is a mission area of interest
+type BBox struct {
+	MinLat float64
+	MinLon float64
+	MaxLat float64
+	MaxLon float64
+}
+
+// ParseBBox parses "lat1,lon1,lat2,lon2" string, corners can be in any order
+func ParseBBox(s string) (*BBox, error) {
+	parts := strings.Split(s, ",")
+
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid bbox %q, must be lat1,lon1,lat2,lon2", s)
+	}
+
+	var v [4]float64
+
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bbox %q: %w", s, err)
+		}
+
+		v[i] = f
+	}
+
+	b := &BBox{
+		MinLat: min(v[0], v[2]),
+		MinLon: min(v[1], v[3]),
+		MaxLat: max(v[0], v[2]),
+		MaxLon: max(v[1], v[3]),
+	}
+
+	if b.MinLat < -90 || b.MaxLat > 90 || b.MinLon < -180 || b.MaxLon > 180 {
+		return nil, fmt.Errorf("invalid bbox %q, coordinates out of range", s)
+	}
+
+	return b, nil
+}
+
+func (b *BBox) Contains(lat, lon float64) bool {
+	if b == nil {
+		return false
+	}
+
+	return lat >= b.MinLat && lat <= b.MaxLat && lon >= b.MinLon && lon <= b.MaxLon
+}
+
+func (b *BBox) String() string {
+	return fmt.Sprintf("%g,%g,%g,%g", b.MinLat, b.MinLon, b.MaxLat, b.MaxLon)
+}
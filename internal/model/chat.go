@@ -0,0 +1,60 @@
+package model
+
+import (
+	"time"
+
+	"github.com/kdudkov/goasae/pkg/model"
+)
+
+type ChatMessage struct {
+	ID        uint      `gorm:"primarykey"`
+	MessageID string    `gorm:"uniqueIndex"`
+	Scope     string    `gorm:"index"`
+	Time      time.Time `gorm:"index"`
+	Parent    string    `gorm:"index"`
+	Chatroom  string    `gorm:"index"`
+	From      string
+	FromUID   string `gorm:"index"`
+	ToUID     string `gorm:"index"`
+	Direct    bool
+	Text      string
+	Delivered *time.Time
+	Read      *time.Time
+	// MissionID is set for messages in mission chat room
+	MissionID uint `gorm:"index;default:0"`
+}
+
+func ChatFromModel(scope string, c *model.ChatMessage) *ChatMessage {
+	return &ChatMessage{
+		MessageID: c.ID,
+		Scope:     scope,
+		Time:      c.Time,
+		Parent:    c.Parent,
+		Chatroom:  c.Chatroom,
+		From:      c.From,
+		FromUID:   c.FromUID,
+		ToUID:     c.ToUID,
+		Direct:    c.Direct,
+		Text:      c.Text,
+	}
+}
+
+func (c *ChatMessage) ToModel() *model.ChatMessage {
+	if c == nil {
+		return nil
+	}
+
+	return &model.ChatMessage{
+		ID:        c.MessageID,
+		Time:      c.Time,
+		Parent:    c.Parent,
+		Chatroom:  c.Chatroom,
+		From:      c.From,
+		FromUID:   c.FromUID,
+		ToUID:     c.ToUID,
+		Direct:    c.Direct,
+		Text:      c.Text,
+		Delivered: c.Delivered,
+		Read:      c.Read,
+	}
+}
@@ -13,7 +13,14 @@ import (
 const missionNotificationStale = time.Second * 5
 
 func MissionChangeNotificationMsg(missionName string, scope string, c *Change) *cot.CotMessage {
-	msg := cot.BasicMsg("t-x-m-c", uuid.NewString(), missionNotificationStale)
+	typ := "t-x-m-c"
+
+	switch c.Type {
+	case ChangeAddLog, ChangeUpdateLog, ChangeDeleteLog:
+		typ = "t-x-m-c-l"
+	}
+
+	msg := cot.BasicMsg(typ, uuid.NewString(), missionNotificationStale)
 	msg.CotEvent.How = "h-g-i-g-o"
 
 	xd := cot.NewXMLDetails()
@@ -27,6 +34,20 @@ func MissionChangeNotificationMsg(missionName string, scope string, c *Change) *
 	ch.AddChild("missionName", nil, missionName)
 	ch.AddChild("timestamp", nil, strconv.Itoa(int(c.CreateTime.Unix())))
 
+	if c.ContentHash != "" {
+		ch.AddChild("contentResource", nil, "").AddChild("hash", nil, c.ContentHash)
+	}
+
+	if d := c.ExternalData; d != nil {
+		ed := ch.AddChild("externalData", nil, "")
+		ed.AddChild("uid", nil, d.ID)
+		ed.AddChild("name", nil, d.Name)
+		ed.AddChild("tool", nil, d.Tool)
+		ed.AddChild("urlData", nil, d.URLData)
+		ed.AddChild("urlView", nil, d.URLView)
+		ed.AddChild("notes", nil, d.Notes)
+	}
+
 	msg.CotEvent.Detail = &cotproto.Detail{XmlDetail: xd.AsXMLString()}
 
 	return &cot.CotMessage{From: cot.LocalFrom, TakMessage: msg, Detail: xd, Scope: scope}
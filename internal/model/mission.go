@@ -4,6 +4,7 @@ import (
 	"strings"
 	"time"
 
+	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/protobuf/proto"
 	"gorm.io/gorm"
 
@@ -13,6 +14,23 @@ import (
 
 const sep = ","
 
+const (
+	RoleOwner      = "MISSION_OWNER"
+	RoleSubscriber = "MISSION_SUBSCRIBER"
+	RoleReadOnly   = "MISSION_READONLY_SUBSCRIBER"
+
+	PermRead    = "MISSION_READ"
+	PermWrite   = "MISSION_WRITE"
+	PermDelete  = "MISSION_DELETE"
+	PermSetRole = "MISSION_SET_ROLE"
+)
+
+const (
+	ChangeAddLog    = "ADD_LOG_ENTRY"
+	ChangeUpdateLog = "UPDATE_LOG_ENTRY"
+	ChangeDeleteLog = "DELETE_LOG_ENTRY"
+)
+
 type Mission struct {
 	ID             uint   `gorm:"primarykey"`
 	Scope          string `gorm:"index"`
@@ -23,6 +41,7 @@ type Mission struct {
 	LastEdit       time.Time
 	BaseLayer      string
 	Bbox           string
+	AutoInclude    bool
 	ChatRoom       string
 	Classification string
 	Description    string
@@ -34,7 +53,61 @@ type Mission struct {
 	Keywords       string
 	Hashes         string
 	Items          []*DataItem
+	ExternalData   []*ExternalData
 	Token          string
+	ParentID       uint     `gorm:"index"`
+	ParentName     string   `gorm:"-" json:"-"`
+	Children       []string `gorm:"-" json:"-"`
+}
+
+// SetPassword stores bcrypt hash of the password, empty password makes mission not protected
+func (m *Mission) SetPassword(password string) error {
+	if password == "" {
+		m.Password = ""
+
+		return nil
+	}
+
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	m.Password = string(h)
+
+	return nil
+}
+
+// HashPassword replaces plain text password of missions made before passwords were hashed with its hash,
+// returns true if password was changed
+func (m *Mission) HashPassword() (bool, error) {
+	if m.Password == "" || strings.HasPrefix(m.Password, "$2") {
+		return false, nil
+	}
+
+	return true, m.SetPassword(m.Password)
+}
+
+func (m *Mission) CheckPassword(password string) bool {
+	if m.Password == "" {
+		return true
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(m.Password), []byte(password)) == nil
+}
+
+// GetBBox returns parsed mission bbox or nil if it is not set or invalid
+func (m *Mission) GetBBox() *BBox {
+	if m.Bbox == "" {
+		return nil
+	}
+
+	b, err := ParseBBox(m.Bbox)
+	if err != nil {
+		return nil
+	}
+
+	return b
 }
 
 func (m *Mission) GetHashes() []string {
@@ -121,6 +194,9 @@ type DataItem struct {
 	Lon         float64
 	EventData   []byte
 	event       *cotproto.CotEvent `gorm:"-"`
+
+	// AutoIncluded is set for items added by mission bbox, they are removed on leaving it
+	AutoIncluded bool
 }
 
 func (d *DataItem) GetEvent() *cotproto.CotEvent {
@@ -192,7 +268,7 @@ type Change struct {
 	ID          uint      `gorm:"primarykey"`
 	CreateTime  time.Time `gorm:"index"`
 	Type        string
-	MissionID   uint
+	MissionID   uint `gorm:"index"`
 	CreatorUID  string
 	ContentUID  string `gorm:"index"`
 	CotType     string
@@ -201,6 +277,36 @@ type Change struct {
 	Color       string
 	Lat         float64
 	Lon         float64
+	// ContentHash is set for file changes
+	ContentHash string
+	// ExternalData is set for external data changes
+	ExternalData *ExternalData `gorm:"serializer:json"`
+}
+
+// ExternalData is a link to data source outside of the server
+type ExternalData struct {
+	ID         string `gorm:"primarykey"`
+	MissionID  uint   `gorm:"index"`
+	Name       string
+	Tool       string
+	URLData    string
+	URLView    string
+	Notes      string
+	CreatorUID string
+	Created    time.Time
+}
+
+type LogEntry struct {
+	ID            string `gorm:"primarykey"`
+	Scope         string `gorm:"index"`
+	Created       time.Time
+	Dtg           time.Time `gorm:"index"`
+	CreatorUID    string
+	EntryUID      string
+	Content       string
+	Keywords      string
+	ContentHashes string
+	Missions      []*Mission `gorm:"many2many:log_entry_missions"`
 }
 
 func hasItem(items []string, item string) bool {
@@ -24,31 +24,43 @@ func (x *CotTime) UnmarshalText(text []byte) error {
 }
 
 type MissionDTO struct {
-	Name              string            `json:"name"`
-	Scope             string            `json:"scope,omitempty"`
-	CreatorUID        string            `json:"creatorUid"`
-	CreateTime        CotTime           `json:"createTime"`
-	LastEdit          CotTime           `json:"lastEdited"`
-	BaseLayer         string            `json:"baseLayer"`
-	Bbox              string            `json:"bbox"`
-	ChatRoom          string            `json:"chatRoom"`
-	Classification    string            `json:"classification"`
-	Contents          []*ContentItemDTO `json:"contents"`
-	DefaultRole       *MissionRoleDTO   `json:"defaultRole,omitempty"`
-	OwnerRole         *MissionRoleDTO   `json:"ownerRole,omitempty"`
-	Description       string            `json:"description"`
-	Expiration        int               `json:"expiration"`
-	ExternalData      []any             `json:"externalData"`
-	Feeds             []string          `json:"feeds"`
-	Groups            []string          `json:"groups,omitempty"`
-	InviteOnly        bool              `json:"inviteOnly"`
-	Keywords          []string          `json:"keywords"`
-	MapLayers         []string          `json:"mapLayers"`
-	PasswordProtected bool              `json:"passwordProtected"`
-	Path              string            `json:"path"`
-	Tool              string            `json:"tool"`
-	Uids              []*MissionItemDTO `json:"uids"`
-	Token             string            `json:"token"`
+	Name              string             `json:"name"`
+	Scope             string             `json:"scope,omitempty"`
+	CreatorUID        string             `json:"creatorUid"`
+	CreateTime        CotTime            `json:"createTime"`
+	LastEdit          CotTime            `json:"lastEdited"`
+	BaseLayer         string             `json:"baseLayer"`
+	Bbox              string             `json:"bbox"`
+	AutoInclude       bool               `json:"autoInclude,omitempty"`
+	ChatRoom          string             `json:"chatRoom"`
+	Classification    string             `json:"classification"`
+	Contents          []*ContentItemDTO  `json:"contents"`
+	DefaultRole       *MissionRoleDTO    `json:"defaultRole,omitempty"`
+	OwnerRole         *MissionRoleDTO    `json:"ownerRole,omitempty"`
+	Description       string             `json:"description"`
+	Expiration        int                `json:"expiration"`
+	ExternalData      []*ExternalDataDTO `json:"externalData"`
+	Feeds             []string           `json:"feeds"`
+	Groups            []string           `json:"groups,omitempty"`
+	InviteOnly        bool               `json:"inviteOnly"`
+	Keywords          []string           `json:"keywords"`
+	MapLayers         []string           `json:"mapLayers"`
+	PasswordProtected bool               `json:"passwordProtected"`
+	Path              string             `json:"path"`
+	Tool              string             `json:"tool"`
+	Uids              []*MissionItemDTO  `json:"uids"`
+	Token             string             `json:"token"`
+	ParentMission     string             `json:"parentMissionName,omitempty"`
+	ChildMissions     []string           `json:"childMissions,omitempty"`
+}
+
+type ExternalDataDTO struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Tool    string `json:"tool"`
+	URLData string `json:"urlData"`
+	URLView string `json:"urlView"`
+	Notes   string `json:"notes"`
 }
 
 type MissionRoleDTO struct {
@@ -90,13 +102,20 @@ type MissionSubscriptionDTO struct {
 }
 
 type MissionChangeDTO struct {
-	Type        string             `json:"type"`
-	MissionName string             `json:"missionName"`
-	Timestamp   CotTime            `json:"timestamp"`
-	CreatorUID  string             `json:"creatorUid"`
-	ServerTime  CotTime            `json:"serverTime"`
-	ContentUID  string             `json:"contentUid,omitempty"`
-	Details     *MissionDetailsDTO `json:"details,omitempty"`
+	Type         string             `json:"type"`
+	MissionName  string             `json:"missionName"`
+	Timestamp    CotTime            `json:"timestamp"`
+	CreatorUID   string             `json:"creatorUid"`
+	ServerTime   CotTime            `json:"serverTime"`
+	ContentUID   string             `json:"contentUid,omitempty"`
+	Content      *ContentHashDTO    `json:"contentResource,omitempty"`
+	Details      *MissionDetailsDTO `json:"details,omitempty"`
+	ExternalData *ExternalDataDTO   `json:"externalData,omitempty"`
+	Seq          uint               `json:"seq,omitempty"`
+}
+
+type ContentHashDTO struct {
+	Hash string `json:"hash"`
 }
 
 type MissionDetailsDTO struct {
@@ -135,15 +154,16 @@ type MissionInvitationDTO struct {
 	Role        *MissionRoleDTO `json:"role"`
 }
 
-func ToMissionDTO(m *Mission, packages pm.PackageManager, withToken bool) *MissionDTO {
-	return ToMissionDTOFull(m, packages, withToken, false)
+// ToMissionDTO makes mission DTO, token is the subscriber mission token or empty string
+func ToMissionDTO(m *Mission, packages pm.PackageManager, token string) *MissionDTO {
+	return ToMissionDTOFull(m, packages, token, false)
 }
 
 func ToMissionDTOAdm(m *Mission, packages pm.PackageManager) *MissionDTO {
-	return ToMissionDTOFull(m, packages, false, true)
+	return ToMissionDTOFull(m, packages, "", true)
 }
 
-func ToMissionDTOFull(m *Mission, packages pm.PackageManager, withToken bool, withScope bool) *MissionDTO {
+func ToMissionDTOFull(m *Mission, packages pm.PackageManager, token string, withScope bool) *MissionDTO {
 	if m == nil {
 		return nil
 	}
@@ -161,14 +181,15 @@ func ToMissionDTOFull(m *Mission, packages pm.PackageManager, withToken bool, wi
 		LastEdit:          CotTime(m.LastEdit),
 		BaseLayer:         m.BaseLayer,
 		Bbox:              m.Bbox,
+		AutoInclude:       m.AutoInclude,
 		ChatRoom:          m.ChatRoom,
 		Classification:    m.Classification,
 		Contents:          []*ContentItemDTO{},
-		DefaultRole:       GetRole("MISSION_SUBSCRIBER"),
-		OwnerRole:         GetRole("MISSION_OWNER"),
+		DefaultRole:       GetRole(RoleSubscriber),
+		OwnerRole:         GetRole(RoleOwner),
 		Description:       m.Description,
 		Expiration:        -1,
-		ExternalData:      []any{},
+		ExternalData:      make([]*ExternalDataDTO, len(m.ExternalData)),
 		Feeds:             []string{},
 		InviteOnly:        m.InviteOnly,
 		Keywords:          strings.Split(m.Keywords, ","),
@@ -177,11 +198,13 @@ func ToMissionDTOFull(m *Mission, packages pm.PackageManager, withToken bool, wi
 		Path:              m.Path,
 		Tool:              m.Tool,
 		Uids:              uids,
-		Token:             m.Token,
+		Token:             token,
+		ParentMission:     m.ParentName,
+		ChildMissions:     m.Children,
 	}
 
-	if withToken {
-		mDTO.Token = m.Token
+	for i, d := range m.ExternalData {
+		mDTO.ExternalData[i] = ToExternalDataDTO(d)
 	}
 
 	if withScope {
@@ -203,6 +226,21 @@ func ToMissionDTOFull(m *Mission, packages pm.PackageManager, withToken bool, wi
 	return mDTO
 }
 
+func ToExternalDataDTO(d *ExternalData) *ExternalDataDTO {
+	if d == nil {
+		return nil
+	}
+
+	return &ExternalDataDTO{
+		ID:      d.ID,
+		Name:    d.Name,
+		Tool:    d.Tool,
+		URLData: d.URLData,
+		URLView: d.URLView,
+		Notes:   d.Notes,
+	}
+}
+
 func toContentItemDTO(pi *pm.PackageInfo) *ContentItemDTO {
 	return &ContentItemDTO{
 		CreatorUID: pi.CreatorUID,
@@ -245,6 +283,48 @@ func ToMissionSubscriptionsDTO(subscriptions []*Subscription) []*MissionSubscrip
 	return res
 }
 
+func ToLogEntryDTO(e *LogEntry) *MissionLogEntryDTO {
+	if e == nil {
+		return nil
+	}
+
+	names := make([]string, len(e.Missions))
+	for i, m := range e.Missions {
+		names[i] = m.Name
+	}
+
+	return &MissionLogEntryDTO{
+		Content:       e.Content,
+		ContentHashes: splitNotEmpty(e.ContentHashes),
+		Created:       e.Created,
+		CreatorUID:    e.CreatorUID,
+		Dtg:           e.Dtg,
+		ID:            e.ID,
+		Keywords:      splitNotEmpty(e.Keywords),
+		MissionNames:  names,
+		Servertime:    e.Created,
+		EntryUID:      e.EntryUID,
+	}
+}
+
+func ToLogEntriesDTO(entries []*LogEntry) []*MissionLogEntryDTO {
+	res := make([]*MissionLogEntryDTO, len(entries))
+
+	for i, e := range entries {
+		res[i] = ToLogEntryDTO(e)
+	}
+
+	return res
+}
+
+func splitNotEmpty(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+
+	return strings.Split(s, sep)
+}
+
 func ToMissionInvitationDTO(m *Invitation, name string) *MissionInvitationDTO {
 	return &MissionInvitationDTO{
 		MissionName: name,
@@ -264,6 +344,17 @@ func NewChangeDTO(c *Change, name string) *MissionChangeDTO {
 		ServerTime:  CotTime(c.CreateTime),
 		CreatorUID:  c.CreatorUID,
 		ContentUID:  c.ContentUID,
+		Seq:         c.ID,
+	}
+
+	if c.ContentHash != "" {
+		cd.Content = &ContentHashDTO{Hash: c.ContentHash}
+	}
+
+	if c.ExternalData != nil {
+		cd.ExternalData = ToExternalDataDTO(c.ExternalData)
+
+		return cd
 	}
 
 	if c.ContentUID != "" {
@@ -310,13 +401,34 @@ func NewRole(typ string, perms ...string) *MissionRoleDTO {
 
 func GetRole(name string) *MissionRoleDTO {
 	switch name {
-	case "MISSION_OWNER":
+	case RoleOwner:
 		return NewRole(name, "MISSION_MANAGE_FEEDS", "MISSION_SET_PASSWORD",
-			"MISSION_WRITE", "MISSION_MANAGE_LAYERS", "MISSION_UPDATE_GROUPS", "MISSION_READ", "MISSION_DELETE",
-			"MISSION_SET_ROLE")
-	case "MISSION_SUBSCRIBER", "":
-		return NewRole("MISSION_SUBSCRIBER", "MISSION_WRITE", "MISSION_READ")
+			PermWrite, "MISSION_MANAGE_LAYERS", "MISSION_UPDATE_GROUPS", PermRead, PermDelete,
+			PermSetRole)
+	case RoleSubscriber, "":
+		return NewRole(RoleSubscriber, PermWrite, PermRead)
+	case RoleReadOnly:
+		return NewRole(name, PermRead)
 	default:
 		return NewRole(name)
 	}
 }
+
+func IsValidRole(name string) bool {
+	return name == RoleOwner || name == RoleSubscriber || name == RoleReadOnly
+}
+
+// HasPermission checks mission role permission, empty role (not subscribed) has no permissions
+func HasPermission(role string, perm string) bool {
+	if role == "" {
+		return false
+	}
+
+	for _, p := range GetRole(role).Permissions {
+		if p == perm {
+			return true
+		}
+	}
+
+	return false
+}
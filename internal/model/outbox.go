@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+)
+
+// OutboxItem is a direct message queued for offline recipient
+type OutboxItem struct {
+	ID        uint   `gorm:"primarykey"`
+	UID       string `gorm:"index"`
+	Created   time.Time
+	Expires   time.Time `gorm:"index"`
+	From      string
+	Scope     string
+	Type      string
+	EventData []byte
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"github.com/kdudkov/goasae/internal/pm"
+)
+
+// PackageRecord keeps package info, its fields are copied to columns for search
+type PackageRecord struct {
+	UID            string    `gorm:"primaryKey;size:255"`
+	Name           string    `gorm:"index"`
+	Hash           string    `gorm:"index"`
+	Scope          string    `gorm:"index"`
+	CreatorUID     string    `gorm:"index"`
+	SubmissionUser string    `gorm:"index"`
+	Submitted      time.Time `gorm:"index"`
+	MIMEType       string    `gorm:"index"`
+	Tool           string    `gorm:"index"`
+	Size           int64     `gorm:"index"`
+	// Keywords are stored as ,kw1,kw2, so single keyword can be matched with LIKE
+	Keywords string
+	// Text is lowercase name, creator uid and inspected content for substring search
+	Text      string
+	HasBounds bool `gorm:"index"`
+	MinLat    float64
+	MinLon    float64
+	MaxLat    float64
+	MaxLon    float64
+	Info      *pm.PackageInfo `gorm:"serializer:json"`
+}
+
+func NewPackageRecord(pi *pm.PackageInfo) *PackageRecord {
+	r := &PackageRecord{
+		UID:            pi.UID,
+		Name:           pi.Name,
+		Hash:           pi.Hash,
+		Scope:          pi.Scope,
+		CreatorUID:     pi.CreatorUID,
+		SubmissionUser: pi.SubmissionUser,
+		Submitted:      pi.SubmissionDateTime,
+		MIMEType:       pi.MIMEType,
+		Tool:           pi.Tool,
+		Size:           int64(pi.Size),
+		Info:           pi,
+	}
+
+	if len(pi.Keywords) > 0 {
+		r.Keywords = "," + strings.Join(pi.Keywords, ",") + ","
+	}
+
+	text := []string{pi.Name, pi.CreatorUID}
+
+	if m := pi.Metadata; m != nil {
+		text = append(text, m.PackageName, m.PackageUID)
+		text = append(text, m.Contents...)
+
+		for _, item := range m.Items {
+			text = append(text, item.UID, item.Callsign, item.Type)
+		}
+
+		if b := m.Bounds; b != nil {
+			r.HasBounds = true
+			r.MinLat, r.MinLon, r.MaxLat, r.MaxLon = b.MinLat, b.MinLon, b.MaxLat, b.MaxLon
+		}
+	}
+
+	r.Text = strings.ToLower(strings.Join(text, "\n"))
+
+	return r
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// MissionSnapshot is a point-in-time copy of mission data items, hashes and keywords
+type MissionSnapshot struct {
+	ID         uint `gorm:"primarykey"`
+	MissionID  uint `gorm:"index"`
+	Created    time.Time
+	CreatorUID string
+	Auto       bool
+	Comment    string
+	Hashes     string
+	Keywords   string
+	Items      []*SnapshotItem `gorm:"foreignKey:SnapshotID"`
+}
+
+// SnapshotItem points to stored data item state, unchanged items share one blob between snapshots
+type SnapshotItem struct {
+	ID         uint `gorm:"primarykey"`
+	SnapshotID uint `gorm:"index"`
+	UID        string
+	Hash       string `gorm:"index"`
+}
+
+type SnapshotBlob struct {
+	Hash string `gorm:"primarykey"`
+	Data []byte
+}
+
+type SnapshotDTO struct {
+	ID         uint     `json:"id"`
+	Mission    string   `json:"missionName"`
+	Created    CotTime  `json:"created"`
+	CreatorUID string   `json:"creatorUid"`
+	Auto       bool     `json:"auto"`
+	Comment    string   `json:"comment,omitempty"`
+	Items      []string `json:"uids"`
+	Hashes     []string `json:"hashes"`
+	Keywords   []string `json:"keywords"`
+}
+
+func (s *MissionSnapshot) GetHashes() []string {
+	return splitNotEmpty(s.Hashes)
+}
+
+// ItemHashes returns map uid -> blob hash
+func (s *MissionSnapshot) ItemHashes() map[string]string {
+	res := make(map[string]string, len(s.Items))
+
+	for _, i := range s.Items {
+		res[i.UID] = i.Hash
+	}
+
+	return res
+}
+
+// NewSnapshotBlob stores data item without its mission binding
+func NewSnapshotBlob(d *DataItem) (*SnapshotBlob, error) {
+	d.GetEvent()
+
+	if err := d.BeforeCreate(nil); err != nil {
+		return nil, err
+	}
+
+	c := *d
+	c.ID = 0
+	c.MissionID = 0
+
+	b, err := json.Marshal(&c)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.Sum256(b)
+
+	return &SnapshotBlob{Hash: hex.EncodeToString(h[:]), Data: b}, nil
+}
+
+func (b *SnapshotBlob) GetItem() (*DataItem, error) {
+	d := new(DataItem)
+
+	if err := json.Unmarshal(b.Data, d); err != nil {
+		return nil, err
+	}
+
+	d.GetEvent()
+
+	return d, nil
+}
+
+func ToSnapshotDTO(s *MissionSnapshot, missionName string) *SnapshotDTO {
+	dto := &SnapshotDTO{
+		ID:         s.ID,
+		Mission:    missionName,
+		Created:    CotTime(s.Created),
+		CreatorUID: s.CreatorUID,
+		Auto:       s.Auto,
+		Comment:    s.Comment,
+		Items:      make([]string, len(s.Items)),
+		Hashes:     s.GetHashes(),
+		Keywords:   splitNotEmpty(s.Keywords),
+	}
+
+	for i, item := range s.Items {
+		dto.Items[i] = item.UID
+	}
+
+	return dto
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+)
+
+// Transfer is a file pushed to contacts by server with b-f-t-r message
+type Transfer struct {
+	ID         string               `gorm:"primaryKey;size:255" json:"id"`
+	Created    time.Time            `gorm:"index" json:"created"`
+	Hash       string               `gorm:"index" json:"hash"`
+	Name       string               `json:"name"`
+	Size       int64                `json:"size"`
+	CreatedBy  string               `json:"created_by"`
+	Recipients []*TransferRecipient `gorm:"foreignKey:TransferID" json:"recipients"`
+}
+
+// TransferRecipient tracks delivery of the transfer to one contact.
+// Sent is nil while message is queued for offline contact
+type TransferRecipient struct {
+	ID         uint       `gorm:"primarykey" json:"-"`
+	TransferID string     `gorm:"index;size:255" json:"-"`
+	UID        string     `gorm:"index" json:"uid"`
+	Callsign   string     `json:"callsign"`
+	Login      string     `gorm:"index" json:"login,omitempty"`
+	Sent       *time.Time `json:"sent,omitempty"`
+	Downloaded *time.Time `json:"downloaded,omitempty"`
+	Acked      *time.Time `json:"acked,omitempty"`
+}
@@ -27,6 +27,14 @@ func (u *User) GetScope() string {
 	return u.Scope
 }
 
+func (u *User) GetReadScope() []string {
+	if u == nil {
+		return nil
+	}
+
+	return u.ReadScope
+}
+
 func (u *User) CanSeeScope(scope string) bool {
 	// nil user can see empty scope (no auth mode)
 	if u == nil {
@@ -7,86 +7,128 @@ import (
 	"io"
 	"log/slog"
 	"os"
-	"path/filepath"
 	"sync"
+	"time"
 )
 
 var NotFound = fmt.Errorf("blob is not found")
 
+const tmpPrefix = ".upload-"
+
+type BlobInfo struct {
+	Hash     string    `json:"hash"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// BlobStorage keeps files by key, missing files are reported with NotFound error
+type BlobStorage interface {
+	Get(key string) (io.ReadSeekCloser, error)
+	Stat(key string) (*BlobInfo, error)
+	Put(key string, r io.Reader, size int64) error
+	Delete(key string) error
+	List() ([]*BlobInfo, error)
+}
+
+// BlobManager stores files by hash. Reads are not locked, mx serializes storing and deleting of files
 type BlobManager struct {
-	logger  *slog.Logger
-	mx      sync.RWMutex
-	basedir string
+	logger   *slog.Logger
+	mx       sync.Mutex
+	storage  BlobStorage
+	spoolDir string
 }
 
 func NewBlobManages(logger *slog.Logger, basedir string) *BlobManager {
-	_ = os.MkdirAll(basedir, 0777)
+	return NewBlobManagerWithStorage(logger, NewFSStorage(basedir), basedir)
+}
+
+// NewBlobManagerWithStorage makes manager for storage, uploads are kept in spoolDir until their hash is known
+func NewBlobManagerWithStorage(logger *slog.Logger, storage BlobStorage, spoolDir string) *BlobManager {
+	_ = os.MkdirAll(spoolDir, 0777)
 
 	return &BlobManager{
-		logger:  logger,
-		mx:      sync.RWMutex{},
-		basedir: basedir,
+		logger:   logger,
+		storage:  storage,
+		spoolDir: spoolDir,
 	}
 }
 
-func (m *BlobManager) name(hash string) string {
-	return filepath.Join(m.basedir, hash)
-}
-
 func (m *BlobManager) GetFile(hash string) (io.ReadSeekCloser, error) {
-	m.mx.RLock()
-	defer m.mx.RUnlock()
-
-	if hash == "" || !fileExists(m.name(hash)) {
+	if hash == "" {
 		return nil, NotFound
 	}
 
-	return os.Open(m.name(hash))
+	return m.storage.Get(hash)
 }
 
-func (m *BlobManager) GetFileStat(hash string) (os.FileInfo, error) {
-	m.mx.RLock()
-	defer m.mx.RUnlock()
-
+func (m *BlobManager) GetFileStat(hash string) (*BlobInfo, error) {
 	if hash == "" {
 		return nil, fmt.Errorf("no hash")
 	}
 
-	return os.Stat(m.name(hash))
+	return m.storage.Stat(hash)
 }
 
+// PutFile stores file from r. File is copied to spool file without lock, lock is held while it is stored only
 func (m *BlobManager) PutFile(hash string, r io.Reader) (string, int64, error) {
-	m.mx.Lock()
-	defer m.mx.Unlock()
-
-	if hash != "" && fileExists(m.name(hash)) {
+	if hash != "" && m.exists(hash) {
 		return hash, 0, nil
 	}
 
-	f, err := os.OpenFile(m.name(hash), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
-
+	// hash is known only after the file is written
+	f, err := os.CreateTemp(m.spoolDir, tmpPrefix+"*")
 	if err != nil {
 		return "", 0, err
 	}
 
+	defer os.Remove(f.Name())
 	defer f.Close()
 
 	h := sha256.New()
 
-	rr := io.TeeReader(r, h)
-
-	var n int64
-
-	if n, err = io.Copy(f, rr); err != nil {
+	n, err := io.Copy(f, io.TeeReader(r, h))
+	if err != nil {
 		return "", 0, err
 	}
 
 	hash1 := hex.EncodeToString(h.Sum(nil))
 
 	if hash != "" && hash != hash1 {
-		os.Remove(f.Name())
 		return "", 0, fmt.Errorf("invalid hash")
 	}
 
-	return hash1, n, err
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if m.exists(hash1) {
+		return hash1, n, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+
+	return hash1, n, m.storage.Put(hash1, f, n)
+}
+
+func (m *BlobManager) DeleteFile(hash string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if hash == "" {
+		return NotFound
+	}
+
+	return m.storage.Delete(hash)
+}
+
+// List returns all stored blobs
+func (m *BlobManager) List() ([]*BlobInfo, error) {
+	return m.storage.List()
+}
+
+func (m *BlobManager) exists(hash string) bool {
+	_, err := m.storage.Stat(hash)
+
+	return err == nil
 }
@@ -0,0 +1,181 @@
+package pm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	tagGPSIFD        = 0x8825
+	tagGPSLatRef     = 1
+	tagGPSLat        = 2
+	tagGPSLonRef     = 3
+	tagGPSLon        = 4
+	exifTypeRational = 5
+)
+
+// exifLocation reads GPS position from EXIF of JPEG file
+func exifLocation(r io.Reader) (float64, float64, bool) {
+	var hdr [2]byte
+
+	if _, err := io.ReadFull(r, hdr[:]); err != nil || hdr != [2]byte{0xff, 0xd8} {
+		return 0, 0, false
+	}
+
+	for {
+		var seg [4]byte
+
+		if _, err := io.ReadFull(r, seg[:]); err != nil || seg[0] != 0xff {
+			return 0, 0, false
+		}
+
+		// start of scan, no metadata after it
+		if seg[1] == 0xda || seg[1] == 0xd9 {
+			return 0, 0, false
+		}
+
+		size := int64(binary.BigEndian.Uint16(seg[2:])) - 2
+		if size < 0 {
+			return 0, 0, false
+		}
+
+		if seg[1] != 0xe1 {
+			if _, err := io.CopyN(io.Discard, r, size); err != nil {
+				return 0, 0, false
+			}
+
+			continue
+		}
+
+		data := make([]byte, size)
+
+		if _, err := io.ReadFull(r, data); err != nil {
+			return 0, 0, false
+		}
+
+		if tiff, ok := bytes.CutPrefix(data, []byte("Exif\x00\x00")); ok {
+			return tiffGPS(tiff)
+		}
+	}
+}
+
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func tiffGPS(b []byte) (float64, float64, bool) {
+	if len(b) < 8 {
+		return 0, 0, false
+	}
+
+	var order binary.ByteOrder
+
+	switch string(b[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, 0, false
+	}
+
+	gpsOffset := uint32(0)
+
+	for _, e := range readIFD(b, order, order.Uint32(b[4:])) {
+		if e.tag == tagGPSIFD {
+			gpsOffset = order.Uint32(e.value)
+		}
+	}
+
+	if gpsOffset == 0 {
+		return 0, 0, false
+	}
+
+	var lat, lon float64
+	var latRef, lonRef byte
+	var hasLat, hasLon bool
+
+	for _, e := range readIFD(b, order, gpsOffset) {
+		switch e.tag {
+		case tagGPSLatRef:
+			latRef = e.value[0]
+		case tagGPSLonRef:
+			lonRef = e.value[0]
+		case tagGPSLat:
+			lat, hasLat = degrees(b, order, e)
+		case tagGPSLon:
+			lon, hasLon = degrees(b, order, e)
+		}
+	}
+
+	if !hasLat || !hasLon {
+		return 0, 0, false
+	}
+
+	if latRef == 'S' {
+		lat = -lat
+	}
+
+	if lonRef == 'W' {
+		lon = -lon
+	}
+
+	return lat, lon, true
+}
+
+// readIFD returns entries of directory at offset, value holds inline data or offset
+func readIFD(b []byte, order binary.ByteOrder, offset uint32) []ifdEntry {
+	if int64(offset)+2 > int64(len(b)) {
+		return nil
+	}
+
+	n := int(order.Uint16(b[offset:]))
+	res := make([]ifdEntry, 0, n)
+
+	for i := 0; i < n; i++ {
+		p := int(offset) + 2 + i*12
+		if p+12 > len(b) {
+			break
+		}
+
+		res = append(res, ifdEntry{
+			tag:   order.Uint16(b[p:]),
+			typ:   order.Uint16(b[p+2:]),
+			count: order.Uint32(b[p+4:]),
+			value: b[p+8 : p+12],
+		})
+	}
+
+	return res
+}
+
+// degrees converts degrees, minutes and seconds rationals to decimal degrees
+func degrees(b []byte, order binary.ByteOrder, e ifdEntry) (float64, bool) {
+	if e.typ != exifTypeRational || e.count != 3 {
+		return 0, false
+	}
+
+	off := int64(order.Uint32(e.value))
+	if off+24 > int64(len(b)) {
+		return 0, false
+	}
+
+	var v [3]float64
+
+	for i := range v {
+		num := order.Uint32(b[off+int64(i)*8:])
+		den := order.Uint32(b[off+int64(i)*8+4:])
+
+		if den == 0 {
+			return 0, false
+		}
+
+		v[i] = float64(num) / float64(den)
+	}
+
+	return v[0] + v[1]/60 + v[2]/3600, true
+}
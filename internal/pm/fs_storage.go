@@ -0,0 +1,110 @@
+package pm
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FSStorage keeps blobs as files in one directory
+type FSStorage struct {
+	basedir string
+}
+
+func NewFSStorage(basedir string) *FSStorage {
+	_ = os.MkdirAll(basedir, 0777)
+
+	return &FSStorage{basedir: basedir}
+}
+
+func (s *FSStorage) name(key string) string {
+	return filepath.Join(s.basedir, key)
+}
+
+func (s *FSStorage) Get(key string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(s.name(key))
+	if os.IsNotExist(err) {
+		return nil, NotFound
+	}
+
+	return f, err
+}
+
+func (s *FSStorage) Stat(key string) (*BlobInfo, error) {
+	fi, err := os.Stat(s.name(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, NotFound
+		}
+
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		return nil, NotFound
+	}
+
+	return &BlobInfo{Hash: key, Size: fi.Size(), Modified: fi.ModTime()}, nil
+}
+
+func (s *FSStorage) Put(key string, r io.Reader, _ int64) error {
+	// spooled upload in the same dir is just renamed
+	if f, ok := r.(*os.File); ok && filepath.Dir(f.Name()) == filepath.Clean(s.basedir) {
+		if err := os.Rename(f.Name(), s.name(key)); err == nil {
+			return nil
+		}
+	}
+
+	f, err := os.CreateTemp(s.basedir, tmpPrefix+"*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, r)
+	_ = f.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.name(key))
+}
+
+func (s *FSStorage) Delete(key string) error {
+	if err := os.Remove(s.name(key)); err != nil {
+		if os.IsNotExist(err) {
+			return NotFound
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *FSStorage) List() ([]*BlobInfo, error) {
+	files, err := os.ReadDir(s.basedir)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*BlobInfo, 0, len(files))
+
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), tmpPrefix) {
+			continue
+		}
+
+		fi, err := f.Info()
+		if err != nil {
+			continue
+		}
+
+		res = append(res, &BlobInfo{Hash: f.Name(), Size: fi.Size(), Modified: fi.ModTime()})
+	}
+
+	return res, nil
+}
@@ -0,0 +1,70 @@
+package pm
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// InfoStore keeps package infos of PackageManagerFS
+type InfoStore interface {
+	Put(pi *PackageInfo) error
+	Delete(uid string) error
+	Get(uid string) *PackageInfo
+	List(filter func(pi *PackageInfo) bool) []*PackageInfo
+}
+
+// ymlStore keeps package infos in memory and in yml files, files are loaded by PackageManagerFS.Start
+type ymlStore struct {
+	dir    string
+	data   sync.Map
+	noSave bool
+}
+
+func (s *ymlStore) Put(pi *PackageInfo) error {
+	s.data.Store(pi.UID, pi)
+
+	if s.noSave {
+		return nil
+	}
+
+	return saveInfo(s.dir, pi)
+}
+
+func (s *ymlStore) Delete(uid string) error {
+	s.data.Delete(uid)
+
+	if s.noSave {
+		return nil
+	}
+
+	if err := os.Remove(filepath.Join(s.dir, uid+".yml")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *ymlStore) Get(uid string) *PackageInfo {
+	if i, ok := s.data.Load(uid); ok {
+		return i.(*PackageInfo)
+	}
+
+	return nil
+}
+
+func (s *ymlStore) List(filter func(pi *PackageInfo) bool) []*PackageInfo {
+	var res []*PackageInfo
+
+	s.data.Range(func(_, value any) bool {
+		if pi, ok := value.(*PackageInfo); ok {
+			if filter == nil || filter(pi) {
+				res = append(res, pi)
+			}
+		}
+
+		return true
+	})
+
+	return res
+}
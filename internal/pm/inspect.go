@@ -0,0 +1,408 @@
+package pm
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	maxInspectEntries = 1000
+	maxInspectXML     = 64 * 1024 * 1024
+	manifestName      = "MANIFEST/manifest.xml"
+)
+
+// maxInspectTotal limits decompressed size of all inspected zip entries, inspection stops when it is reached
+var maxInspectTotal int64 = 256 * 1024 * 1024
+
+var errInspectLimit = errors.New("inspect limit is reached")
+
+// Metadata is extracted from package content on upload
+type Metadata struct {
+	PackageUID  string     `json:"packageUid,omitempty"  yaml:"package_uid,omitempty"`
+	PackageName string     `json:"packageName,omitempty" yaml:"package_name,omitempty"`
+	Contents    []string   `json:"contents,omitempty"    yaml:"contents,omitempty"`
+	Items       []*CotItem `json:"items,omitempty"       yaml:"items,omitempty"`
+	Bounds      *Bounds    `json:"bounds,omitempty"      yaml:"bounds,omitempty"`
+}
+
+// CotItem is a CoT event found in mission package
+type CotItem struct {
+	UID      string  `json:"uid"                yaml:"uid"`
+	Type     string  `json:"type"               yaml:"type"`
+	Callsign string  `json:"callsign,omitempty" yaml:"callsign,omitempty"`
+	Lat      float64 `json:"lat"                yaml:"lat"`
+	Lon      float64 `json:"lon"                yaml:"lon"`
+}
+
+// Bounds is a bounding box of package geo content, single point for images
+type Bounds struct {
+	MinLat float64 `json:"minLat" yaml:"min_lat"`
+	MinLon float64 `json:"minLon" yaml:"min_lon"`
+	MaxLat float64 `json:"maxLat" yaml:"max_lat"`
+	MaxLon float64 `json:"maxLon" yaml:"max_lon"`
+}
+
+// Extend returns bounds including the point, b can be nil
+func (b *Bounds) Extend(lat, lon float64) *Bounds {
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return b
+	}
+
+	if b == nil {
+		return &Bounds{MinLat: lat, MinLon: lon, MaxLat: lat, MaxLon: lon}
+	}
+
+	b.MinLat, b.MaxLat = min(b.MinLat, lat), max(b.MaxLat, lat)
+	b.MinLon, b.MaxLon = min(b.MinLon, lon), max(b.MaxLon, lon)
+
+	return b
+}
+
+func (b *Bounds) Intersects(o *Bounds) bool {
+	if b == nil || o == nil {
+		return false
+	}
+
+	return b.MinLat <= o.MaxLat && o.MinLat <= b.MaxLat && b.MinLon <= o.MaxLon && o.MinLon <= b.MaxLon
+}
+
+func (m *Metadata) empty() bool {
+	return m.PackageUID == "" && m.PackageName == "" && len(m.Contents) == 0 && len(m.Items) == 0 && m.Bounds == nil
+}
+
+// inspectable returns true for files that can have metadata, so other files are not read again after upload
+func inspectable(name, mimeType string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".zip", ".dpk", ".kmz", ".kml", ".gpx", ".jpg", ".jpeg":
+		return true
+	}
+
+	for _, s := range []string{"zip", "kml", "gpx", "jpeg"} {
+		if strings.Contains(mimeType, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Inspect extracts metadata from mission packages, KML/KMZ, GPX and JPEG files. Returns nil for other files
+func Inspect(r io.ReadSeeker, size int64, name, mimeType string) (*Metadata, error) {
+	var magic [4]byte
+
+	n, _ := io.ReadFull(r, magic[:])
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	m := new(Metadata)
+	var err error
+
+	switch ext := strings.ToLower(path.Ext(name)); {
+	case bytes.Equal(magic[:n], []byte("PK\x03\x04")):
+		err = inspectZip(m, readerAt(r), size)
+	case ext == ".kml" || strings.Contains(mimeType, "kml"):
+		m.Bounds, err = kmlBounds(io.LimitReader(r, maxInspectXML), nil)
+	case ext == ".gpx" || strings.Contains(mimeType, "gpx"):
+		m.Bounds, err = gpxBounds(io.LimitReader(r, maxInspectXML), nil)
+	case n >= 2 && magic[0] == 0xff && magic[1] == 0xd8:
+		if lat, lon, ok := exifLocation(r); ok {
+			m.Bounds = m.Bounds.Extend(lat, lon)
+		}
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if m.empty() {
+		return nil, nil
+	}
+
+	return m, nil
+}
+
+type manifest struct {
+	Configuration struct {
+		Parameters []manifestParam `xml:"Parameter"`
+	} `xml:"Configuration"`
+	Contents []struct {
+		ZipEntry string `xml:"zipEntry,attr"`
+		Ignore   bool   `xml:"ignore,attr"`
+	} `xml:"Contents>Content"`
+}
+
+type manifestParam struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type cotEvent struct {
+	UID   string `xml:"uid,attr"`
+	Type  string `xml:"type,attr"`
+	Point struct {
+		Lat float64 `xml:"lat,attr"`
+		Lon float64 `xml:"lon,attr"`
+	} `xml:"point"`
+	Contact struct {
+		Callsign string `xml:"callsign,attr"`
+	} `xml:"detail>contact"`
+}
+
+func inspectZip(m *Metadata, r io.ReaderAt, size int64) error {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	left := maxInspectTotal
+
+	for i, f := range z.File {
+		if i >= maxInspectEntries || left <= 0 {
+			break
+		}
+
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		var err error
+
+		switch strings.ToLower(path.Ext(f.Name)) {
+		case ".xml":
+			if f.Name == manifestName {
+				err = readZipEntry(f, &left, func(r io.Reader) error { return parseManifest(m, r) })
+			}
+		case ".cot":
+			err = readZipEntry(f, &left, func(r io.Reader) error { return parseCot(m, r) })
+		case ".kml":
+			err = readZipEntry(f, &left, func(r io.Reader) (err error) {
+				m.Bounds, err = kmlBounds(r, m.Bounds)
+
+				return
+			})
+		case ".gpx":
+			err = readZipEntry(f, &left, func(r io.Reader) (err error) {
+				m.Bounds, err = gpxBounds(r, m.Bounds)
+
+				return
+			})
+		}
+
+		// broken content entry is skipped, but package with broken manifest is invalid
+		if err != nil && f.Name == manifestName {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readZipEntry reads decompressed entry with fn, read bytes are taken from left
+func readZipEntry(f *zip.File, left *int64, fn func(r io.Reader) error) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+
+	defer rc.Close()
+
+	return fn(&budgetReader{r: io.LimitReader(rc, maxInspectXML), left: left})
+}
+
+// budgetReader fails with errInspectLimit when left bytes are read
+type budgetReader struct {
+	r    io.Reader
+	left *int64
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	if *b.left <= 0 {
+		return 0, errInspectLimit
+	}
+
+	if int64(len(p)) > *b.left {
+		p = p[:*b.left]
+	}
+
+	n, err := b.r.Read(p)
+	*b.left -= int64(n)
+
+	return n, err
+}
+
+func parseManifest(m *Metadata, r io.Reader) error {
+	var mf manifest
+
+	if err := xml.NewDecoder(r).Decode(&mf); err != nil {
+		return err
+	}
+
+	for _, p := range mf.Configuration.Parameters {
+		switch p.Name {
+		case "uid":
+			m.PackageUID = p.Value
+		case "name":
+			m.PackageName = p.Value
+		}
+	}
+
+	for _, c := range mf.Contents {
+		if !c.Ignore && c.ZipEntry != "" {
+			m.Contents = append(m.Contents, c.ZipEntry)
+		}
+	}
+
+	return nil
+}
+
+func parseCot(m *Metadata, r io.Reader) error {
+	var ev cotEvent
+
+	if err := xml.NewDecoder(r).Decode(&ev); err != nil {
+		return err
+	}
+
+	if ev.UID == "" {
+		return nil
+	}
+
+	m.Items = append(m.Items, &CotItem{
+		UID:      ev.UID,
+		Type:     ev.Type,
+		Callsign: ev.Contact.Callsign,
+		Lat:      ev.Point.Lat,
+		Lon:      ev.Point.Lon,
+	})
+
+	if ev.Point.Lat != 0 || ev.Point.Lon != 0 {
+		m.Bounds = m.Bounds.Extend(ev.Point.Lat, ev.Point.Lon)
+	}
+
+	return nil
+}
+
+// kmlBounds extends b with all points of coordinates elements, "lon,lat[,alt]" tuples separated by spaces
+func kmlBounds(r io.Reader, b *Bounds) (*Bounds, error) {
+	dec := xml.NewDecoder(r)
+	inCoords := false
+
+	for {
+		t, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return b, nil
+		}
+
+		if err != nil {
+			return b, err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			inCoords = t.Name.Local == "coordinates"
+		case xml.EndElement:
+			inCoords = false
+		case xml.CharData:
+			if !inCoords {
+				continue
+			}
+
+			for _, tuple := range strings.Fields(string(t)) {
+				parts := strings.Split(tuple, ",")
+				if len(parts) < 2 {
+					continue
+				}
+
+				lon, err1 := strconv.ParseFloat(parts[0], 64)
+				lat, err2 := strconv.ParseFloat(parts[1], 64)
+
+				if err1 == nil && err2 == nil {
+					b = b.Extend(lat, lon)
+				}
+			}
+		}
+	}
+}
+
+// gpxBounds extends b with waypoints, route and track points
+func gpxBounds(r io.Reader, b *Bounds) (*Bounds, error) {
+	dec := xml.NewDecoder(r)
+
+	for {
+		t, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return b, nil
+		}
+
+		if err != nil {
+			return b, err
+		}
+
+		se, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch se.Name.Local {
+		case "wpt", "rtept", "trkpt":
+		default:
+			continue
+		}
+
+		var lat, lon float64
+		var err1, err2 error = errors.New("no lat"), errors.New("no lon")
+
+		for _, a := range se.Attr {
+			switch a.Name.Local {
+			case "lat":
+				lat, err1 = strconv.ParseFloat(a.Value, 64)
+			case "lon":
+				lon, err2 = strconv.ParseFloat(a.Value, 64)
+			}
+		}
+
+		if err1 == nil && err2 == nil {
+			b = b.Extend(lat, lon)
+		}
+	}
+}
+
+// readerAt makes io.ReaderAt for zip from seekable blob, os.File is used as is
+func readerAt(r io.ReadSeeker) io.ReaderAt {
+	if ra, ok := r.(io.ReaderAt); ok {
+		return ra
+	}
+
+	return &seekReaderAt{r: r}
+}
+
+type seekReaderAt struct {
+	mx sync.Mutex
+	r  io.ReadSeeker
+}
+
+func (s *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, err := s.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(s.r, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+
+	return n, err
+}
@@ -0,0 +1,171 @@
+package pm
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testManifest = `<MissionPackageManifest version="2">
+<Configuration><Parameter name="uid" value="pkg-1"/><Parameter name="name" value="Recon"/></Configuration>
+<Contents><Content ignore="false" zipEntry="a/point.cot"/><Content ignore="false" zipEntry="b/route.kml"/></Contents>
+</MissionPackageManifest>`
+
+const testCot = `<event version="2.0" uid="point-1" type="a-h-G" how="h-g-i-g-o">
+<point lat="60.1" lon="30.2" hae="0" ce="9999999" le="9999999"/><detail><contact callsign="Target"/></detail></event>`
+
+const testKml = `<kml xmlns="http://www.opengis.net/kml/2.2"><Document><Placemark><LineString>
+<coordinates>30.0,59.5,0 31.0,60.5,0</coordinates></LineString></Placemark></Document></kml>`
+
+const testGpx = `<gpx version="1.1"><wpt lat="10.5" lon="20.5"/><trk><trkseg>
+<trkpt lat="11" lon="21"></trkpt><trkpt lat="9" lon="22"></trkpt></trkseg></trk></gpx>`
+
+func makeZip(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+
+	zw := zip.NewWriter(&buf)
+
+	for name, data := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(data))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+// makeJpeg returns minimal jpeg header with EXIF GPS position in big endian TIFF
+func makeJpeg(lat, lon [3]uint32, latRef, lonRef byte) []byte {
+	be := binary.BigEndian
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+
+	// IFD0 with GPS IFD pointer at 26
+	tiff = be.AppendUint16(tiff, 1)
+	tiff = append(tiff, 0x88, 0x25, 0, 4, 0, 0, 0, 1, 0, 0, 0, 26)
+	tiff = be.AppendUint32(tiff, 0)
+
+	// GPS IFD with 4 entries, rationals start at 26+2+4*12+4 = 80
+	tiff = be.AppendUint16(tiff, 4)
+	tiff = append(tiff, 0, 1, 0, 2, 0, 0, 0, 2, latRef, 0, 0, 0)
+	tiff = append(tiff, 0, 2, 0, 5, 0, 0, 0, 3, 0, 0, 0, 80)
+	tiff = append(tiff, 0, 3, 0, 2, 0, 0, 0, 2, lonRef, 0, 0, 0)
+	tiff = append(tiff, 0, 4, 0, 5, 0, 0, 0, 3, 0, 0, 0, 104)
+	tiff = be.AppendUint32(tiff, 0)
+
+	for _, v := range append(lat[:], lon[:]...) {
+		tiff = be.AppendUint32(tiff, v)
+		tiff = be.AppendUint32(tiff, 1)
+	}
+
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+
+	res := []byte{0xff, 0xd8, 0xff, 0xe0, 0, 4, 0, 0, 0xff, 0xe1}
+	res = be.AppendUint16(res, uint16(len(app1)+2))
+	res = append(res, app1...)
+
+	return append(res, 0xff, 0xda, 0, 2)
+}
+
+func TestInspectMissionPackage(t *testing.T) {
+	data := makeZip(t, map[string]string{
+		"MANIFEST/manifest.xml": testManifest,
+		"a/point.cot":           testCot,
+		"b/route.kml":           testKml,
+	})
+
+	m, err := Inspect(bytes.NewReader(data), int64(len(data)), "recon.zip", "application/zip")
+	require.NoError(t, err)
+	require.NotNil(t, m)
+
+	assert.Equal(t, "pkg-1", m.PackageUID)
+	assert.Equal(t, "Recon", m.PackageName)
+	assert.Equal(t, []string{"a/point.cot", "b/route.kml"}, m.Contents)
+	require.Len(t, m.Items, 1)
+	assert.Equal(t, &CotItem{UID: "point-1", Type: "a-h-G", Callsign: "Target", Lat: 60.1, Lon: 30.2}, m.Items[0])
+	assert.Equal(t, &Bounds{MinLat: 59.5, MinLon: 30, MaxLat: 60.5, MaxLon: 31}, m.Bounds)
+
+	// reader without ReadAt, like s3 object
+	m, err = Inspect(struct{ *bytes.Reader }{bytes.NewReader(data)}, int64(len(data)), "recon.zip", "")
+	require.NoError(t, err)
+	assert.Equal(t, "pkg-1", m.PackageUID)
+}
+
+func TestInspectLimit(t *testing.T) {
+	defer func(n int64) { maxInspectTotal = n }(maxInspectTotal)
+
+	maxInspectTotal = 4096
+
+	var buf bytes.Buffer
+
+	zw := zip.NewWriter(&buf)
+
+	// well compressed entry is larger than the whole limit when decompressed
+	for _, f := range []struct{ name, data string }{{"a/big.kml", strings.Repeat(" ", 100000) + testKml}, {"b/point.cot", testCot}} {
+		w, err := zw.Create(f.name)
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(f.data))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, zw.Close())
+
+	m, err := Inspect(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "big.zip", "application/zip")
+	require.NoError(t, err)
+	assert.Nil(t, m)
+
+	maxInspectTotal = 1024 * 1024
+
+	m, err = Inspect(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "big.zip", "application/zip")
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	assert.Len(t, m.Items, 1)
+	assert.NotNil(t, m.Bounds)
+}
+
+func TestInspectGeo(t *testing.T) {
+	kmz := makeZip(t, map[string]string{"doc.kml": testKml})
+
+	m, err := Inspect(bytes.NewReader(kmz), int64(len(kmz)), "route.kmz", "")
+	require.NoError(t, err)
+	assert.Equal(t, &Bounds{MinLat: 59.5, MinLon: 30, MaxLat: 60.5, MaxLon: 31}, m.Bounds)
+
+	m, err = Inspect(bytes.NewReader([]byte(testGpx)), int64(len(testGpx)), "track.gpx", "")
+	require.NoError(t, err)
+	assert.Equal(t, &Bounds{MinLat: 9, MinLon: 20.5, MaxLat: 11, MaxLon: 22}, m.Bounds)
+
+	img := makeJpeg([3]uint32{59, 30, 36}, [3]uint32{30, 15, 0}, 'N', 'W')
+
+	m, err = Inspect(bytes.NewReader(img), int64(len(img)), "photo.jpg", "image/jpeg")
+	require.NoError(t, err)
+	require.NotNil(t, m)
+	assert.InDelta(t, 59.51, m.Bounds.MinLat, 1e-9)
+	assert.InDelta(t, -30.25, m.Bounds.MinLon, 1e-9)
+
+	m, err = Inspect(bytes.NewReader([]byte("text")), 4, "readme.txt", "text/plain")
+	require.NoError(t, err)
+	assert.Nil(t, m)
+}
+
+func TestSaveInspected(t *testing.T) {
+	pm := NewPackageManager(t.TempDir())
+	require.NoError(t, pm.Start())
+
+	data := makeZip(t, map[string]string{"MANIFEST/manifest.xml": testManifest, "a/point.cot": testCot})
+	pi := save(t, pm, "p1.zip", "user1", "s1", "missionpackage", data, 0)
+
+	pi = pm.Get(pi.UID)
+	require.NotNil(t, pi.Metadata)
+
+	assert.True(t, pi.Metadata.Bounds.Intersects(&Bounds{MinLat: 60, MinLon: 30, MaxLat: 61, MaxLon: 31}))
+	assert.False(t, pi.Metadata.Bounds.Intersects(&Bounds{MinLat: 50, MinLon: 30, MaxLat: 51, MaxLon: 31}))
+}
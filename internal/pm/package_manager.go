@@ -7,7 +7,6 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 
 	"gopkg.in/yaml.v3"
 
@@ -17,8 +16,7 @@ import (
 type PackageManagerFS struct {
 	logger  *slog.Logger
 	baseDir string
-	data    sync.Map
-	noSave  bool
+	infos   InfoStore
 	files   *BlobManager
 }
 
@@ -26,11 +24,28 @@ func NewPackageManager(basedir string) *PackageManagerFS {
 	return &PackageManagerFS{
 		logger:  slog.Default().With("logger", "package_manager"),
 		baseDir: basedir,
-		data:    sync.Map{},
+		infos:   &ymlStore{dir: basedir},
 		files:   NewBlobManages(slog.Default().With("logger", "file_manager"), filepath.Join(basedir, "blob")),
 	}
 }
 
+// NewPackageManagerWithStorage keeps package info in basedir and files in storage
+func NewPackageManagerWithStorage(basedir string, storage BlobStorage) *PackageManagerFS {
+	return &PackageManagerFS{
+		logger:  slog.Default().With("logger", "package_manager"),
+		baseDir: basedir,
+		infos:   &ymlStore{dir: basedir},
+		files:   NewBlobManagerWithStorage(slog.Default().With("logger", "file_manager"), storage, filepath.Join(basedir, "tmp")),
+	}
+}
+
+// SetInfoStore replaces yml files in basedir with other package info store, it must be called before Start
+func (pm *PackageManagerFS) SetInfoStore(s InfoStore) {
+	pm.infos = s
+}
+
+// Start loads package infos from yml files. With other info store yml files are moved to it once
+// and renamed to .yml.imported
 func (pm *PackageManagerFS) Start() error {
 	if err := os.MkdirAll(pm.baseDir, 0777); err != nil {
 		return err
@@ -41,6 +56,8 @@ func (pm *PackageManagerFS) Start() error {
 		return err
 	}
 
+	ys, local := pm.infos.(*ymlStore)
+
 	for _, f := range files {
 		if f.IsDir() {
 			continue
@@ -52,10 +69,25 @@ func (pm *PackageManagerFS) Start() error {
 
 		uid := f.Name()[:len(f.Name())-4]
 
-		if pi, err := loadInfo(pm.baseDir, f.Name()); err == nil {
-			pm.data.Store(uid, pi)
-		} else {
+		pi, err := loadInfo(pm.baseDir, f.Name())
+		if err != nil {
 			pm.logger.Error("error loading info for "+uid, slog.Any("error", err))
+
+			continue
+		}
+
+		if local {
+			ys.data.Store(uid, pi)
+
+			continue
+		}
+
+		if err := pm.infos.Put(pi); err != nil {
+			return fmt.Errorf("import of %s: %w", uid, err)
+		}
+
+		if err := os.Rename(filepath.Join(pm.baseDir, f.Name()), filepath.Join(pm.baseDir, f.Name()+".imported")); err != nil {
+			return err
 		}
 	}
 
@@ -67,23 +99,20 @@ func (pm *PackageManagerFS) Stop() {
 }
 
 func (pm *PackageManagerFS) Store(pi *PackageInfo) {
-	pm.data.Store(pi.UID, pi)
-
-	if pm.noSave {
-		return
-	}
-
-	if err := saveInfo(pm.baseDir, pi); err != nil {
+	if err := pm.infos.Put(pi); err != nil {
 		pm.logger.Error("store error", slog.Any("error", err))
 	}
 }
 
-func (pm *PackageManagerFS) Get(uid string) *PackageInfo {
-	if i, ok := pm.data.Load(uid); ok {
-		return i.(*PackageInfo)
+// Delete removes package info, file is kept until garbage collection
+func (pm *PackageManagerFS) Delete(uid string) {
+	if err := pm.infos.Delete(uid); err != nil {
+		pm.logger.Error("delete error", slog.Any("error", err))
 	}
+}
 
-	return nil
+func (pm *PackageManagerFS) Get(uid string) *PackageInfo {
+	return pm.infos.Get(uid)
 }
 
 func (pm *PackageManagerFS) GetByHash(hash string) []*PackageInfo {
@@ -93,37 +122,15 @@ func (pm *PackageManagerFS) GetByHash(hash string) []*PackageInfo {
 }
 
 func (pm *PackageManagerFS) GetList(filter func(pi *PackageInfo) bool) []*PackageInfo {
-	var res []*PackageInfo
-
-	pm.data.Range(func(_, value any) bool {
-		if pi, ok := value.(*PackageInfo); ok {
-			if filter == nil || filter(pi) {
-				res = append(res, pi)
-			}
-		}
-
-		return true
-	})
-
-	return res
+	return pm.infos.List(filter)
 }
 
 func (pm *PackageManagerFS) GetFirst(filter func(pi *PackageInfo) bool) *PackageInfo {
-	var pi *PackageInfo
-
-	pm.data.Range(func(_, value any) bool {
-		p := value.(*PackageInfo)
-
-		if filter == nil || filter(p) {
-			pi = p
-
-			return false
-		}
-
-		return true
-	})
+	if list := pm.infos.List(filter); len(list) > 0 {
+		return list[0]
+	}
 
-	return pi
+	return nil
 }
 
 func saveInfo(baseDir string, finfo *PackageInfo) error {
@@ -174,7 +181,7 @@ func (pm *PackageManagerFS) GetFileSize(hash string) (int64, error) {
 		return 0, err
 	}
 
-	return st.Size(), nil
+	return st.Size, nil
 }
 
 func (pm *PackageManagerFS) SaveFile(pi *PackageInfo, r io.Reader) error {
@@ -189,6 +196,8 @@ func (pm *PackageManagerFS) SaveFile(pi *PackageInfo, r io.Reader) error {
 		pi.Size = int(size)
 	}
 
+	pi.Metadata = pm.inspect(pi)
+
 	if pi.UID == "" {
 		pi.UID = uuid.NewString()
 	}
@@ -198,6 +207,44 @@ func (pm *PackageManagerFS) SaveFile(pi *PackageInfo, r io.Reader) error {
 	return nil
 }
 
+// inspect returns metadata of package file, inspection errors are only logged
+func (pm *PackageManagerFS) inspect(pi *PackageInfo) *Metadata {
+	if !inspectable(pi.Name, pi.MIMEType) {
+		return nil
+	}
+
+	st, err := pm.files.GetFileStat(pi.Hash)
+	if err != nil {
+		pm.logger.Error("inspect error", slog.Any("error", err))
+
+		return nil
+	}
+
+	f, err := pm.files.GetFile(pi.Hash)
+	if err != nil {
+		pm.logger.Error("inspect error", slog.Any("error", err))
+
+		return nil
+	}
+
+	defer f.Close()
+
+	md, err := Inspect(f, st.Size, pi.Name, pi.MIMEType)
+	if err != nil {
+		pm.logger.Warn("can't inspect "+pi.Name, slog.Any("error", err))
+	}
+
+	return md
+}
+
+func (pm *PackageManagerFS) ListFiles() ([]*BlobInfo, error) {
+	return pm.files.List()
+}
+
+func (pm *PackageManagerFS) DeleteFile(hash string) error {
+	return pm.files.DeleteFile(hash)
+}
+
 func (pm *PackageManagerFS) saveFile(uid, fname string, b []byte) error {
 	dir := filepath.Join(pm.baseDir, uid)
 	if !fileExists(dir) {
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"testing"
 	"time"
@@ -14,7 +16,7 @@ import (
 
 func TestGetByHash(t *testing.T) {
 	pm := NewPackageManager(os.TempDir())
-	pm.noSave = true
+	pm.infos = &ymlStore{noSave: true}
 
 	data := []byte{1, 2, 3, 4, 5}
 
@@ -54,3 +56,36 @@ func Hash(b []byte) string {
 	h.Write(b)
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
+
+func TestPutFileUnlocked(t *testing.T) {
+	m := NewBlobManages(slog.Default(), t.TempDir())
+
+	h1, _, err := m.PutFile("", bytes.NewReader([]byte{1}))
+	require.NoError(t, err)
+
+	r, w := io.Pipe()
+	done := make(chan error)
+
+	go func() {
+		_, _, err := m.PutFile("", r)
+		done <- err
+	}()
+
+	_, err = w.Write([]byte{2})
+	require.NoError(t, err)
+
+	// other files can be read and stored while upload is in progress
+	f, err := m.GetFile(h1)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, _, err = m.PutFile("", bytes.NewReader([]byte{3}))
+	require.NoError(t, err)
+
+	require.NoError(t, w.Close())
+	require.NoError(t, <-done)
+
+	list, err := m.List()
+	require.NoError(t, err)
+	assert.Len(t, list, 3)
+}
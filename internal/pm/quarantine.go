@@ -0,0 +1,94 @@
+package pm
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Quarantine keeps infected files out of package storage, each file is kept with its package info
+type Quarantine struct {
+	mx  sync.Mutex
+	dir string
+}
+
+func NewQuarantine(dir string) *Quarantine {
+	return &Quarantine{dir: dir}
+}
+
+func (q *Quarantine) Put(pi *PackageInfo, r io.Reader) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if err := os.MkdirAll(q.dir, 0700); err != nil {
+		return err
+	}
+
+	if pi.UID == "" {
+		pi.UID = uuid.NewString()
+	}
+
+	f, err := os.OpenFile(filepath.Join(q.dir, pi.UID+".bin"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(f, r)
+	_ = f.Close()
+
+	if err != nil {
+		return err
+	}
+
+	pi.Size = int(n)
+
+	return saveInfo(q.dir, pi)
+}
+
+// List returns quarantined packages, newest first
+func (q *Quarantine) List() []*PackageInfo {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	files, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil
+	}
+
+	res := make([]*PackageInfo, 0)
+
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".yml") {
+			continue
+		}
+
+		if pi, err := loadInfo(q.dir, f.Name()); err == nil {
+			res = append(res, pi)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].SubmissionDateTime.After(res[j].SubmissionDateTime)
+	})
+
+	return res
+}
+
+func (q *Quarantine) Delete(uid string) bool {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	if uid == "" || strings.ContainsAny(uid, `/\`) {
+		return false
+	}
+
+	err := os.Remove(filepath.Join(q.dir, uid+".yml"))
+	_ = os.Remove(filepath.Join(q.dir, uid+".bin"))
+
+	return err == nil
+}
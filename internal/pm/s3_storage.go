@@ -0,0 +1,379 @@
+package pm
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	emptySHA256     = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	amzDateFormat   = "20060102T150405Z"
+)
+
+type S3Config struct {
+	// Endpoint is the server url like https://s3.amazonaws.com or http://localhost:9000
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// PathStyle uses endpoint/bucket/key urls instead of bucket.endpoint/key, needed for MinIO
+	PathStyle bool
+}
+
+// S3Storage keeps blobs in S3-compatible object storage
+type S3Storage struct {
+	cfg    S3Config
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 endpoint and bucket are required")
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	if _, err := url.Parse(cfg.Endpoint); err != nil {
+		return nil, err
+	}
+
+	return &S3Storage{cfg: cfg, client: &http.Client{}, now: time.Now}, nil
+}
+
+func (s *S3Storage) Get(key string) (io.ReadSeekCloser, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Object{s: s, key: key, size: info.Size}, nil
+}
+
+func (s *S3Storage) Stat(key string) (*BlobInfo, error) {
+	res, err := s.do(http.MethodHead, s.cfg.Prefix+key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = res.Body.Close()
+
+	info := &BlobInfo{Hash: key, Size: res.ContentLength}
+	info.Modified, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+
+	return info, nil
+}
+
+// Put streams r to the object, size must be known
+func (s *S3Storage) Put(key string, r io.Reader, size int64) error {
+	res, err := s.do(http.MethodPut, s.cfg.Prefix+key, nil, nil, r, size)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+func (s *S3Storage) Delete(key string) error {
+	if _, err := s.Stat(key); err != nil {
+		return err
+	}
+
+	res, err := s.do(http.MethodDelete, s.cfg.Prefix+key, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3Storage) List() ([]*BlobInfo, error) {
+	var res []*BlobInfo
+
+	q := url.Values{"list-type": {"2"}}
+
+	if s.cfg.Prefix != "" {
+		q.Set("prefix", s.cfg.Prefix)
+	}
+
+	for {
+		r, err := s.do(http.MethodGet, "", q, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		var l listResult
+
+		err = xml.NewDecoder(r.Body).Decode(&l)
+		_ = r.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range l.Contents {
+			key := strings.TrimPrefix(c.Key, s.cfg.Prefix)
+
+			if key == "" || strings.Contains(key, "/") {
+				continue
+			}
+
+			res = append(res, &BlobInfo{Hash: key, Size: c.Size, Modified: c.LastModified})
+		}
+
+		if !l.IsTruncated || l.NextContinuationToken == "" {
+			return res, nil
+		}
+
+		q.Set("continuation-token", l.NextContinuationToken)
+	}
+}
+
+func (s *S3Storage) url(key string) *url.URL {
+	u, _ := url.Parse(s.cfg.Endpoint)
+
+	if s.cfg.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+
+	return u
+}
+
+func (s *S3Storage) do(method, key string, q url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u := s.url(key)
+	u.RawQuery = canonicalQuery(q)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	payload := emptySHA256
+
+	if body != nil {
+		req.ContentLength = size
+		payload = unsignedPayload
+	}
+
+	s.sign(req, payload)
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		_ = res.Body.Close()
+
+		return nil, NotFound
+	case res.StatusCode >= 300:
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		_ = res.Body.Close()
+
+		return nil, fmt.Errorf("s3 %s %s: %s %s", method, key, res.Status, strings.TrimSpace(string(b)))
+	}
+
+	return res, nil
+}
+
+// sign adds AWS signature version 4 headers
+func (s *S3Storage) sign(req *http.Request, payload string) {
+	now := s.now().UTC()
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+
+	if req.Header.Get("Range") != "" {
+		headers = append(headers, "range")
+	}
+
+	sort.Strings(headers)
+
+	var canonicalHeaders strings.Builder
+
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+
+	signed := strings.Join(headers, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signed,
+		payload,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format(amzDateFormat) + "\n" + scope + "\n" + sha256hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+// s3Object reads object with range requests, so seek does not download skipped data
+type s3Object struct {
+	s      *S3Storage
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		h := http.Header{"Range": {"bytes=" + strconv.FormatInt(o.offset, 10) + "-"}}
+
+		res, err := o.s.do(http.MethodGet, o.s.cfg.Prefix+o.key, nil, h, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		o.body = res.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = o.offset + offset
+	case io.SeekEnd:
+		pos = o.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence")
+	}
+
+	if pos < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+
+	if pos != o.offset {
+		_ = o.Close()
+		o.offset = pos
+	}
+
+	return pos, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+
+	err := o.body.Close()
+	o.body = nil
+
+	return err
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+
+	for k := range q {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// uriEncode encodes string as required by signature v4, slash is kept in paths
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func sha256hex(b []byte) string {
+	h := sha256.Sum256(b)
+
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
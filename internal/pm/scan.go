@@ -0,0 +1,125 @@
+package pm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const clamdChunk = 64 * 1024
+
+var (
+	ErrInfected   = errors.New("file is infected")
+	ErrScanFailed = errors.New("file scan failed")
+)
+
+// Scanner checks file content for malware
+type Scanner interface {
+	Scan(r io.Reader) (*ScanResult, error)
+}
+
+type ScanResult struct {
+	Time    time.Time `json:"time" yaml:"time"`
+	Scanner string    `json:"scanner" yaml:"scanner"`
+	Clean   bool      `json:"clean" yaml:"clean"`
+	Threat  string    `json:"threat,omitempty" yaml:"threat,omitempty"`
+}
+
+// ClamdScanner sends files to clamd with INSTREAM command
+type ClamdScanner struct {
+	network string
+	addr    string
+	timeout time.Duration
+}
+
+// NewClamdScanner makes scanner for address like unix:/run/clamav/clamd.ctl, tcp:127.0.0.1:3310 or 127.0.0.1:3310
+func NewClamdScanner(addr string, timeout time.Duration) *ClamdScanner {
+	network := "tcp"
+
+	if n, a, ok := strings.Cut(addr, ":"); ok && (n == "unix" || n == "tcp") {
+		network, addr = n, a
+	}
+
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+
+	return &ClamdScanner{network: network, addr: addr, timeout: timeout}
+}
+
+func (s *ClamdScanner) Scan(r io.Reader) (*ScanResult, error) {
+	conn, err := net.DialTimeout(s.network, s.addr, s.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrScanFailed, err)
+	}
+
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(s.timeout))
+
+	if err := clamdStream(conn, r); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrScanFailed, err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %w", ErrScanFailed, err)
+	}
+
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// clamdStream sends data as length prefixed chunks, zero length chunk ends the stream
+func clamdStream(w io.Writer, r io.Reader) error {
+	if _, err := w.Write([]byte("zINSTREAM\x00")); err != nil {
+		return err
+	}
+
+	buf := make([]byte, clamdChunk+4)
+
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+
+			if _, err := w.Write(buf[:n+4]); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write([]byte{0, 0, 0, 0})
+
+	return err
+}
+
+// parseClamdReply parses "stream: OK" or "stream: Eicar-Signature FOUND"
+func parseClamdReply(reply string) (*ScanResult, error) {
+	res := &ScanResult{Time: time.Now(), Scanner: "clamd"}
+	_, status, _ := strings.Cut(reply, ": ")
+
+	switch {
+	case status == "OK":
+		res.Clean = true
+	case strings.HasSuffix(status, " FOUND"):
+		res.Threat = strings.TrimSuffix(status, " FOUND")
+	default:
+		return nil, fmt.Errorf("%w: clamd reply %q", ErrScanFailed, reply)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,107 @@
+package pm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClamd reads INSTREAM data and replies FOUND when data contains "EICAR"
+func fakeClamd(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			cmd := make([]byte, len("zINSTREAM\x00"))
+			_, _ = io.ReadFull(conn, cmd)
+
+			var data bytes.Buffer
+
+			for {
+				var size uint32
+				if binary.Read(conn, binary.BigEndian, &size) != nil || size == 0 {
+					break
+				}
+
+				_, _ = io.CopyN(&data, conn, int64(size))
+			}
+
+			if strings.Contains(data.String(), "EICAR") {
+				_, _ = conn.Write([]byte("stream: Eicar-Signature FOUND\x00"))
+			} else {
+				_, _ = conn.Write([]byte("stream: OK\x00"))
+			}
+
+			_ = conn.Close()
+		}
+	}()
+
+	return "tcp:" + l.Addr().String()
+}
+
+func TestClamdScanner(t *testing.T) {
+	s := NewClamdScanner(fakeClamd(t), time.Second)
+
+	res, err := s.Scan(bytes.NewReader(bytes.Repeat([]byte("a"), clamdChunk*2+10)))
+	require.NoError(t, err)
+	assert.True(t, res.Clean)
+	assert.Equal(t, "clamd", res.Scanner)
+
+	res, err = s.Scan(strings.NewReader("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"))
+	require.NoError(t, err)
+	assert.False(t, res.Clean)
+	assert.Equal(t, "Eicar-Signature", res.Threat)
+}
+
+func TestClamdScannerFailed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	_, err = NewClamdScanner(addr, time.Second).Scan(strings.NewReader("data"))
+	assert.ErrorIs(t, err, ErrScanFailed)
+
+	_, err = parseClamdReply("INSTREAM size limit exceeded. ERROR")
+	assert.ErrorIs(t, err, ErrScanFailed)
+}
+
+func TestQuarantine(t *testing.T) {
+	q := NewQuarantine(t.TempDir())
+
+	assert.Empty(t, q.List())
+
+	pi1 := &PackageInfo{UID: "uid1", Name: "a.zip", SubmissionDateTime: time.Now().Add(-time.Minute)}
+	pi2 := &PackageInfo{UID: "uid2", Name: "b.zip", SubmissionDateTime: time.Now(),
+		Scan: &ScanResult{Scanner: "clamd", Threat: "Eicar-Signature"}}
+
+	require.NoError(t, q.Put(pi1, strings.NewReader("data1")))
+	require.NoError(t, q.Put(pi2, strings.NewReader("data22")))
+
+	list := q.List()
+	require.Len(t, list, 2)
+	assert.Equal(t, "uid2", list[0].UID)
+	assert.Equal(t, 6, list[0].Size)
+	assert.Equal(t, "Eicar-Signature", list[0].Scan.Threat)
+
+	assert.False(t, q.Delete("../uid1"))
+	assert.True(t, q.Delete("uid1"))
+	assert.False(t, q.Delete("uid1"))
+	assert.Len(t, q.List(), 1)
+}
@@ -0,0 +1,194 @@
+package pm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// Quota limits total size of packages per submission user and per scope, 0 means no limit
+type Quota struct {
+	User  int64
+	Scope int64
+}
+
+// Check returns ErrQuotaExceeded if storing size bytes for pi exceeds user or scope quota.
+// Package with the same uid is not counted as it will be replaced
+func (q Quota) Check(pm PackageManager, pi *PackageInfo, size int64) error {
+	if q.User <= 0 && q.Scope <= 0 {
+		return nil
+	}
+
+	var user, scope int64
+
+	for _, p := range pm.GetList(nil) {
+		if p.UID == pi.UID {
+			continue
+		}
+
+		if p.Scope == pi.Scope {
+			scope += int64(p.Size)
+
+			if p.SubmissionUser == pi.SubmissionUser {
+				user += int64(p.Size)
+			}
+		}
+	}
+
+	if q.User > 0 && pi.SubmissionUser != "" && user+size > q.User {
+		return fmt.Errorf("%w: user %s", ErrQuotaExceeded, pi.SubmissionUser)
+	}
+
+	if q.Scope > 0 && scope+size > q.Scope {
+		return fmt.Errorf("%w: scope %s", ErrQuotaExceeded, pi.Scope)
+	}
+
+	return nil
+}
+
+// RetentionRule removes matching packages older than MaxAge and oldest ones when their total size is over MaxSize
+type RetentionRule struct {
+	Keyword string
+	Tool    string
+	MaxAge  time.Duration
+	MaxSize int64
+}
+
+func (r *RetentionRule) Match(pi *PackageInfo) bool {
+	return pi.HasKeyword(r.Keyword) && (r.Tool == "" || pi.Tool == r.Tool)
+}
+
+// Expired returns packages to remove by retention rules, packages with keep(hash) == true are never removed
+func Expired(list []*PackageInfo, rules []*RetentionRule, now time.Time, keep func(hash string) bool) []*PackageInfo {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].SubmissionDateTime.After(list[j].SubmissionDateTime)
+	})
+
+	expired := make(map[string]*PackageInfo)
+
+	for _, r := range rules {
+		var total int64
+
+		for _, pi := range list {
+			if !r.Match(pi) || keep(pi.Hash) {
+				continue
+			}
+
+			total += int64(pi.Size)
+
+			if (r.MaxAge > 0 && now.Sub(pi.SubmissionDateTime) > r.MaxAge) || (r.MaxSize > 0 && total > r.MaxSize) {
+				expired[pi.UID] = pi
+			}
+		}
+	}
+
+	res := make([]*PackageInfo, 0, len(expired))
+
+	for _, pi := range list {
+		if _, ok := expired[pi.UID]; ok {
+			res = append(res, pi)
+		}
+	}
+
+	return res
+}
+
+type GCReport struct {
+	DryRun  bool           `json:"dryRun"`
+	Expired []*PackageInfo `json:"expired"`
+	Orphans []*BlobInfo    `json:"orphans"`
+	Freed   int64          `json:"freed"`
+}
+
+// Collect removes packages expired by retention rules and files not used by any package.
+// Packages and files with keep(hash) == true and files younger than grace are kept. With skipOrphans unused files
+// are not collected at all (storage is shared with other servers). Nothing is removed in dry run
+func Collect(pm PackageManager, rules []*RetentionRule, keep func(hash string) bool, grace time.Duration, skipOrphans, dryRun bool) (*GCReport, error) {
+	now := time.Now()
+
+	files, err := pm.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &GCReport{
+		DryRun:  dryRun,
+		Expired: Expired(pm.GetList(nil), rules, now, keep),
+		Orphans: []*BlobInfo{},
+	}
+
+	removed := make(map[string]bool, len(report.Expired))
+	for _, pi := range report.Expired {
+		removed[pi.UID] = true
+	}
+
+	used := make(map[string]bool)
+
+	for _, pi := range pm.GetList(nil) {
+		if !removed[pi.UID] {
+			used[pi.Hash] = true
+		}
+	}
+
+	if !skipOrphans {
+		for _, f := range files {
+			if used[f.Hash] || keep(f.Hash) || now.Sub(f.Modified) < grace {
+				continue
+			}
+
+			report.Orphans = append(report.Orphans, f)
+			report.Freed += f.Size
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	for _, pi := range report.Expired {
+		pm.Delete(pi.UID)
+	}
+
+	for _, f := range report.Orphans {
+		if err := pm.DeleteFile(f.Hash); err != nil && !errors.Is(err, NotFound) {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// ParseSize parses size like 1024, 100KB, 10MB or 1GB
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+
+	if s == "" {
+		return 0, nil
+	}
+
+	mult := int64(1)
+
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.mult
+
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %s", s)
+	}
+
+	return n * mult, nil
+}
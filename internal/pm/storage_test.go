@@ -0,0 +1,115 @@
+package pm
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func save(t *testing.T, pm *PackageManagerFS, uid, user, scope, kw string, data []byte, age time.Duration) *PackageInfo {
+	pi := &PackageInfo{
+		UID:                uid,
+		SubmissionDateTime: time.Now().Add(-age),
+		SubmissionUser:     user,
+		Scope:              scope,
+		Name:               uid,
+	}
+
+	if kw != "" {
+		pi.Keywords = []string{kw}
+	}
+
+	require.NoError(t, pm.SaveFile(pi, bytes.NewReader(data)))
+
+	return pi
+}
+
+func TestQuota(t *testing.T) {
+	pm := NewPackageManager(t.TempDir())
+	require.NoError(t, pm.Start())
+
+	save(t, pm, "p1", "user1", "s1", "", make([]byte, 60), 0)
+	save(t, pm, "p2", "user2", "s1", "", make([]byte, 30), 0)
+
+	q := Quota{User: 100, Scope: 120}
+
+	assert.NoError(t, q.Check(pm, &PackageInfo{SubmissionUser: "user1", Scope: "s1"}, 20))
+	assert.ErrorIs(t, q.Check(pm, &PackageInfo{SubmissionUser: "user1", Scope: "s1"}, 50), ErrQuotaExceeded)
+	assert.ErrorIs(t, q.Check(pm, &PackageInfo{SubmissionUser: "user3", Scope: "s1"}, 40), ErrQuotaExceeded)
+	assert.NoError(t, q.Check(pm, &PackageInfo{SubmissionUser: "user3", Scope: "s2"}, 100))
+
+	// replacement of the same package
+	assert.NoError(t, q.Check(pm, &PackageInfo{UID: "p1", SubmissionUser: "user1", Scope: "s1"}, 90))
+}
+
+func TestCollect(t *testing.T) {
+	pm := NewPackageManager(t.TempDir())
+	require.NoError(t, pm.Start())
+
+	save(t, pm, "old", "user1", "s1", "missionpackage", []byte{1}, time.Hour*48)
+	save(t, pm, "new", "user1", "s1", "missionpackage", []byte{2}, 0)
+	save(t, pm, "other", "user1", "s1", "", []byte{3}, time.Hour*48)
+	save(t, pm, "mission", "user1", "s1", "missionpackage", []byte{4}, time.Hour*48)
+	orphan := save(t, pm, "orphan", "user1", "s1", "", []byte{5}, 0)
+	pm.Delete(orphan.UID)
+
+	missionHash := pm.Get("mission").Hash
+	keep := func(hash string) bool { return hash == missionHash }
+	rules := []*RetentionRule{{Keyword: "missionpackage", MaxAge: time.Hour * 24}}
+
+	r, err := Collect(pm, rules, keep, 0, false, true)
+	require.NoError(t, err)
+	require.Len(t, r.Expired, 1)
+	assert.Equal(t, "old", r.Expired[0].UID)
+	assert.Len(t, r.Orphans, 2)
+	assert.Equal(t, int64(2), r.Freed)
+
+	files, _ := pm.ListFiles()
+	assert.Len(t, files, 5)
+	assert.NotNil(t, pm.Get("old"))
+
+	r, err = Collect(pm, rules, keep, 0, false, false)
+	require.NoError(t, err)
+	assert.Len(t, r.Orphans, 2)
+	assert.Nil(t, pm.Get("old"))
+	assert.NotNil(t, pm.Get("mission"))
+
+	files, _ = pm.ListFiles()
+	assert.Len(t, files, 3)
+
+	// grace period keeps new files
+	save(t, pm, "orphan2", "user1", "s1", "", []byte{6}, 0)
+	pm.Delete("orphan2")
+
+	r, err = Collect(pm, nil, keep, time.Hour, false, true)
+	require.NoError(t, err)
+	assert.Empty(t, r.Orphans)
+}
+
+func TestExpiredBySize(t *testing.T) {
+	now := time.Now()
+	list := []*PackageInfo{
+		{UID: "1", Size: 10, Tool: "public", SubmissionDateTime: now.Add(-time.Minute * 3)},
+		{UID: "2", Size: 10, Tool: "public", SubmissionDateTime: now.Add(-time.Minute * 2)},
+		{UID: "3", Size: 10, Tool: "public", SubmissionDateTime: now.Add(-time.Minute)},
+		{UID: "4", Size: 10, Tool: "private", SubmissionDateTime: now.Add(-time.Minute * 4)},
+	}
+
+	res := Expired(list, []*RetentionRule{{Tool: "public", MaxSize: 20}}, now, func(string) bool { return false })
+	require.Len(t, res, 1)
+	assert.Equal(t, "1", res[0].UID)
+}
+
+func TestParseSize(t *testing.T) {
+	for s, n := range map[string]int64{"": 0, "100": 100, "10B": 10, "2kb": 2048, "1 MB": 1 << 20, "3GB": 3 << 30} {
+		v, err := ParseSize(s)
+		require.NoError(t, err)
+		assert.Equal(t, n, v, s)
+	}
+
+	_, err := ParseSize("ten MB")
+	assert.Error(t, err)
+}
@@ -0,0 +1,360 @@
+package pm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUploadNotFound = errors.New("upload is not found")
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrUploadTooLarge = errors.New("data is larger than upload size")
+	ErrUploadBusy     = errors.New("upload is in progress")
+)
+
+// Upload is a resumable upload session, data is appended to part file until Size bytes are received
+type Upload struct {
+	ID      string       `yaml:"id"      json:"id"`
+	Owner   string       `yaml:"owner"   json:"-"`
+	Size    int64        `yaml:"size"    json:"size"`
+	Offset  int64        `yaml:"offset"  json:"offset"`
+	Created time.Time    `yaml:"created" json:"created"`
+	Updated time.Time    `yaml:"updated" json:"updated"`
+	Info    *PackageInfo `yaml:"info"    json:"-"`
+}
+
+func (u *Upload) Complete() bool {
+	return u.Offset >= u.Size
+}
+
+// UploadManager keeps upload sessions in dir, sessions not updated for ttl are removed by Cleanup.
+// Session is moved from uploads to busy while its data is written or stored, mx is not held during file operations
+type UploadManager struct {
+	logger  *slog.Logger
+	mx      sync.Mutex
+	dir     string
+	ttl     time.Duration
+	uploads map[string]*Upload
+	busy    map[string]*Upload
+}
+
+func NewUploadManager(dir string, ttl time.Duration) *UploadManager {
+	return &UploadManager{
+		logger:  slog.Default().With("logger", "upload_manager"),
+		dir:     dir,
+		ttl:     ttl,
+		uploads: make(map[string]*Upload),
+		busy:    make(map[string]*Upload),
+	}
+}
+
+// Start loads sessions saved before restart
+func (m *UploadManager) Start() error {
+	if err := os.MkdirAll(m.dir, 0777); err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(m.dir)
+	if err != nil {
+		return err
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".yml") {
+			continue
+		}
+
+		u := new(Upload)
+
+		if err := loadYaml(filepath.Join(m.dir, f.Name()), u); err != nil {
+			m.logger.Error("error loading upload "+f.Name(), slog.Any("error", err))
+
+			continue
+		}
+
+		// part file is the source of truth, it can be longer if save of info failed
+		if st, err := os.Stat(m.partName(u.ID)); err == nil {
+			u.Offset = min(st.Size(), u.Size)
+		}
+
+		m.uploads[u.ID] = u
+	}
+
+	return nil
+}
+
+// Create starts new upload of size bytes for package pi
+func (m *UploadManager) Create(owner string, size int64, pi *PackageInfo) (*Upload, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("invalid size")
+	}
+
+	now := time.Now()
+
+	u := &Upload{
+		ID:      uuid.NewString(),
+		Owner:   owner,
+		Size:    size,
+		Created: now,
+		Updated: now,
+		Info:    pi,
+	}
+
+	if err := os.MkdirAll(m.dir, 0777); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(m.partName(u.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	_ = f.Close()
+
+	if err := m.save(u); err != nil {
+		return nil, err
+	}
+
+	m.mx.Lock()
+	m.uploads[u.ID] = u
+	m.mx.Unlock()
+
+	return u, nil
+}
+
+// Get returns upload of the owner
+func (m *UploadManager) Get(id, owner string) *Upload {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if u := m.lookup(id, owner); u != nil {
+		c := *u
+
+		return &c
+	}
+
+	return nil
+}
+
+// Write appends data at offset, offset must be equal to received size. Returns new offset
+func (m *UploadManager) Write(id, owner string, offset int64, r io.Reader) (int64, error) {
+	u, err := m.take(id, owner)
+	if err != nil {
+		return 0, err
+	}
+
+	defer m.put(u)
+
+	if offset != u.Offset {
+		return u.Offset, ErrOffsetMismatch
+	}
+
+	f, err := os.OpenFile(m.partName(id), os.O_WRONLY, 0600)
+	if err != nil {
+		return u.Offset, err
+	}
+
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return u.Offset, err
+	}
+
+	// one extra byte to detect too large data
+	n, err := io.Copy(f, io.LimitReader(r, u.Size-offset+1))
+
+	if offset+n > u.Size {
+		n = u.Size - offset
+		_ = f.Truncate(u.Size)
+		err = ErrUploadTooLarge
+	}
+
+	// partially written chunk is kept, client continues from new offset
+	u.Offset += n
+	u.Updated = time.Now()
+
+	if err1 := m.save(u); err1 != nil && err == nil {
+		err = err1
+	}
+
+	return u.Offset, err
+}
+
+// Finish stores received data as package file, hash is checked when it's set in package info
+func (m *UploadManager) Finish(id, owner string, files PackageManager) (*PackageInfo, error) {
+	u, err := m.take(id, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	if !u.Complete() {
+		m.put(u)
+
+		return nil, fmt.Errorf("upload is not complete: %d of %d bytes", u.Offset, u.Size)
+	}
+
+	f, err := os.Open(m.partName(id))
+	if err != nil {
+		m.put(u)
+
+		return nil, err
+	}
+
+	pi := u.Info
+	err = files.SaveFile(pi, f)
+	_ = f.Close()
+
+	// data with wrong hash can't be fixed by resume
+	m.mx.Lock()
+	delete(m.busy, id)
+	m.mx.Unlock()
+
+	m.remove(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return pi, nil
+}
+
+// Open returns reader of received data
+func (m *UploadManager) Open(id, owner string) (io.ReadCloser, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if m.lookup(id, owner) == nil {
+		return nil, ErrUploadNotFound
+	}
+
+	return os.Open(m.partName(id))
+}
+
+// Delete removes upload of the owner, upload in progress is not removed
+func (m *UploadManager) Delete(id, owner string) bool {
+	m.mx.Lock()
+
+	if u, ok := m.uploads[id]; !ok || u.Owner != owner {
+		m.mx.Unlock()
+
+		return false
+	}
+
+	delete(m.uploads, id)
+	m.mx.Unlock()
+
+	m.remove(id)
+
+	return true
+}
+
+// Cleanup removes uploads not updated for ttl
+func (m *UploadManager) Cleanup() int {
+	if m.ttl <= 0 {
+		return 0
+	}
+
+	m.mx.Lock()
+
+	expired := make([]string, 0)
+
+	for id, u := range m.uploads {
+		if time.Since(u.Updated) > m.ttl {
+			delete(m.uploads, id)
+			expired = append(expired, id)
+		}
+	}
+
+	m.mx.Unlock()
+
+	for _, id := range expired {
+		m.remove(id)
+	}
+
+	return len(expired)
+}
+
+// lookup returns upload of the owner, idle or in progress. mx must be held
+func (m *UploadManager) lookup(id, owner string) *Upload {
+	for _, l := range []map[string]*Upload{m.uploads, m.busy} {
+		if u, ok := l[id]; ok && u.Owner == owner {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// take moves idle upload of the owner to busy and returns its copy for change, it must be returned by put
+func (m *UploadManager) take(id, owner string) (*Upload, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if u, ok := m.busy[id]; ok && u.Owner == owner {
+		return nil, ErrUploadBusy
+	}
+
+	u, ok := m.uploads[id]
+	if !ok || u.Owner != owner {
+		return nil, ErrUploadNotFound
+	}
+
+	delete(m.uploads, id)
+	m.busy[id] = u
+
+	c := *u
+
+	return &c, nil
+}
+
+func (m *UploadManager) put(u *Upload) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	delete(m.busy, u.ID)
+	m.uploads[u.ID] = u
+}
+
+func (m *UploadManager) remove(id string) {
+	_ = os.Remove(m.partName(id))
+	_ = os.Remove(filepath.Join(m.dir, id+".yml"))
+}
+
+func (m *UploadManager) partName(id string) string {
+	return filepath.Join(m.dir, id+".part")
+}
+
+func (m *UploadManager) save(u *Upload) error {
+	f, err := os.Create(filepath.Join(m.dir, u.ID+".yml"))
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return yaml.NewEncoder(f).Encode(u)
+}
+
+func loadYaml(name string, v any) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return yaml.NewDecoder(f).Decode(v)
+}
@@ -0,0 +1,126 @@
+package pm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpload(t *testing.T) {
+	dir := t.TempDir()
+	pm := NewPackageManager(filepath.Join(dir, "mp"))
+	require.NoError(t, pm.Start())
+
+	data := []byte("0123456789abcdef")
+	h := sha256.Sum256(data)
+	hash := hex.EncodeToString(h[:])
+
+	um := NewUploadManager(filepath.Join(dir, "uploads"), time.Hour)
+	require.NoError(t, um.Start())
+
+	u, err := um.Create("user1", int64(len(data)), &PackageInfo{Name: "test.zip", Hash: hash})
+	require.NoError(t, err)
+
+	assert.Nil(t, um.Get(u.ID, "user2"))
+
+	n, err := um.Write(u.ID, "user1", 0, bytes.NewReader(data[:10]))
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), n)
+
+	n, err = um.Write(u.ID, "user1", 5, bytes.NewReader(data[5:]))
+	assert.ErrorIs(t, err, ErrOffsetMismatch)
+	assert.Equal(t, int64(10), n)
+
+	_, err = um.Finish(u.ID, "user1", pm)
+	assert.Error(t, err)
+
+	// sessions survive restart
+	um = NewUploadManager(filepath.Join(dir, "uploads"), time.Hour)
+	require.NoError(t, um.Start())
+	assert.Equal(t, int64(10), um.Get(u.ID, "user1").Offset)
+
+	n, err = um.Write(u.ID, "user1", 10, bytes.NewReader(append(data[10:], 'x')))
+	assert.ErrorIs(t, err, ErrUploadTooLarge)
+	assert.Equal(t, int64(len(data)), n)
+
+	pi, err := um.Finish(u.ID, "user1", pm)
+	require.NoError(t, err)
+	assert.Equal(t, hash, pi.Hash)
+	assert.Equal(t, len(data), pi.Size)
+	assert.Nil(t, um.Get(u.ID, "user1"))
+	assert.NotNil(t, pm.GetFirst(func(p *PackageInfo) bool { return p.Hash == hash }))
+}
+
+func TestUploadHash(t *testing.T) {
+	dir := t.TempDir()
+	pm := NewPackageManager(filepath.Join(dir, "mp"))
+	require.NoError(t, pm.Start())
+
+	um := NewUploadManager(filepath.Join(dir, "uploads"), time.Hour)
+
+	u, err := um.Create("user1", 3, &PackageInfo{Name: "test.zip", Hash: "0000"})
+	require.NoError(t, err)
+
+	_, err = um.Write(u.ID, "user1", 0, bytes.NewReader([]byte{1, 2, 3}))
+	require.NoError(t, err)
+
+	_, err = um.Finish(u.ID, "user1", pm)
+	assert.Error(t, err)
+	assert.Nil(t, um.Get(u.ID, "user1"))
+	assert.Empty(t, pm.GetList(nil))
+}
+
+func TestUploadCleanup(t *testing.T) {
+	um := NewUploadManager(t.TempDir(), time.Hour)
+
+	old, err := um.Create("user1", 10, &PackageInfo{})
+	require.NoError(t, err)
+
+	fresh, err := um.Create("user1", 10, &PackageInfo{})
+	require.NoError(t, err)
+
+	um.uploads[old.ID].Updated = time.Now().Add(-time.Hour * 2)
+
+	assert.Equal(t, 1, um.Cleanup())
+	assert.Nil(t, um.Get(old.ID, "user1"))
+	assert.NotNil(t, um.Get(fresh.ID, "user1"))
+}
+
+func TestUploadBusy(t *testing.T) {
+	um := NewUploadManager(t.TempDir(), time.Hour)
+
+	u, err := um.Create("user1", 10, &PackageInfo{})
+	require.NoError(t, err)
+
+	pr, pw := io.Pipe()
+	done := make(chan int64)
+
+	go func() {
+		n, _ := um.Write(u.ID, "user1", 0, pr)
+		done <- n
+	}()
+
+	// write is in progress after the first chunk is read
+	_, err = pw.Write([]byte("0123"))
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(0), um.Get(u.ID, "user1").Offset)
+
+	_, err = um.Write(u.ID, "user1", 0, bytes.NewReader([]byte("0123")))
+	assert.ErrorIs(t, err, ErrUploadBusy)
+
+	_, err = um.Finish(u.ID, "user1", nil)
+	assert.ErrorIs(t, err, ErrUploadBusy)
+	assert.False(t, um.Delete(u.ID, "user1"))
+
+	require.NoError(t, pw.Close())
+	assert.Equal(t, int64(4), <-done)
+	assert.Equal(t, int64(4), um.Get(u.ID, "user1").Offset)
+}
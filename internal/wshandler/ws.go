@@ -6,6 +6,7 @@ import (
 	"sync/atomic"
 
 	"github.com/gofiber/contrib/websocket"
+	"github.com/kdudkov/goasae/internal/geofence"
 	"github.com/kdudkov/goasae/pkg/model"
 )
 
@@ -14,6 +15,7 @@ type WebMessage struct {
 	Unit        *model.WebUnit     `json:"unit,omitempty"`
 	UID         string             `json:"uid,omitempty"`
 	ChatMessage *model.ChatMessage `json:"chat_msg,omitempty"`
+	Geofence    *geofence.Event    `json:"geofence,omitempty"`
 }
 
 type JSONWsHandler struct {
@@ -112,6 +114,19 @@ func (w *JSONWsHandler) NewChatMessage(msg *model.ChatMessage) bool {
 	return true
 }
 
+func (w *JSONWsHandler) SendGeofenceEvent(ev *geofence.Event) bool {
+	if w == nil || !w.IsActive() {
+		return false
+	}
+
+	select {
+	case w.ch <- &WebMessage{Typ: "geofence", Geofence: ev}:
+	default:
+	}
+
+	return true
+}
+
 func (w *JSONWsHandler) closehandler(code int, text string) error {
 	w.log.Info(fmt.Sprintf("closed with code %d, msg %s", code, text))
 	w.stop()
@@ -91,6 +91,10 @@ func (n *Node) GetDestCallsign() []string {
 	return n.getDestFor("callsign")
 }
 
+func (n *Node) GetDestUID() []string {
+	return n.getDestFor("uid")
+}
+
 func (n *Node) GetDestMission() []string {
 	return n.getDestFor("mission")
 }
@@ -157,6 +161,39 @@ func (n *Node) GetFirst(name string) *Node {
 	return nil
 }
 
+// GetByPath returns all nodes matching slash separated path like "__chat/chatgrp"
+func (n *Node) GetByPath(path string) []*Node {
+	if n == nil {
+		return nil
+	}
+
+	res := []*Node{n}
+
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" {
+			continue
+		}
+
+		next := make([]*Node, 0)
+
+		for _, nn := range res {
+			if name == "*" {
+				next = append(next, nn.Nodes...)
+			} else {
+				next = append(next, nn.GetAll(name)...)
+			}
+		}
+
+		if len(next) == 0 {
+			return nil
+		}
+
+		res = next
+	}
+
+	return res
+}
+
 func (n *Node) Has(name string) bool {
 	return n.GetFirst(name) != nil
 }
@@ -19,3 +19,13 @@ func Test1(t *testing.T) {
 	assert.Len(t, details.GetAll("link"), 12)
 	assert.Len(t, details.GetAll("link_attr"), 1)
 }
+
+func TestGetByPath(t *testing.T) {
+	details, err := DetailsFromString(`<__chat id="All Chat Rooms"><chatgrp uid0="u1"/><chatgrp uid0="u2"/></__chat><marti><dest callsign="c1"/></marti>`)
+	require.NoError(t, err)
+
+	assert.Len(t, details.GetByPath("__chat/chatgrp"), 2)
+	assert.Len(t, details.GetByPath("*/dest"), 1)
+	assert.Equal(t, "c1", details.GetByPath("marti/dest")[0].GetAttr("callsign"))
+	assert.Nil(t, details.GetByPath("marti/link"))
+}
@@ -25,15 +25,17 @@ type Chat struct {
 }
 
 type ChatMessage struct {
-	ID       string    `json:"message_id"`
-	Time     time.Time `json:"time"`
-	Parent   string    `json:"parent"`
-	Chatroom string    `json:"chatroom"`
-	From     string    `json:"from"`
-	FromUID  string    `json:"from_uid"`
-	ToUID    string    `json:"to_uid"`
-	Direct   bool      `json:"direct"`
-	Text     string    `json:"text"`
+	ID        string     `json:"message_id"`
+	Time      time.Time  `json:"time"`
+	Parent    string     `json:"parent"`
+	Chatroom  string     `json:"chatroom"`
+	From      string     `json:"from"`
+	FromUID   string     `json:"from_uid"`
+	ToUID     string     `json:"to_uid"`
+	Direct    bool       `json:"direct"`
+	Text      string     `json:"text"`
+	Delivered *time.Time `json:"delivered,omitempty"`
+	Read      *time.Time `json:"read,omitempty"`
 }
 
 func NewChatMessages(myUID string) *ChatMessages {
@@ -81,6 +83,28 @@ func (m *ChatMessages) Add(msg *ChatMessage) {
 	}
 }
 
+// Receipt marks message as delivered or read, returns nil if message is not found
+func (m *ChatMessages) Receipt(id string, read bool, t time.Time) *ChatMessage {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	for _, c := range m.Chats {
+		if msg := c.getMsg(id); msg != nil {
+			if msg.Delivered == nil {
+				msg.Delivered = &t
+			}
+
+			if read && msg.Read == nil {
+				msg.Read = &t
+			}
+
+			return msg
+		}
+	}
+
+	return nil
+}
+
 func (m *ChatMessage) String() string {
 	return fmt.Sprintf("Chat %s (%s) -> %s (%s) \"%s\"", m.From, m.FromUID, m.Chatroom, m.ToUID, m.Text)
 }
@@ -123,6 +147,17 @@ func MsgToChat(m *cot.CotMessage) *ChatMessage {
 	return c
 }
 
+// ChatReceiptID returns id of the message that b-t-f-d or b-t-f-r receipt is for
+func ChatReceiptID(m *cot.CotMessage) string {
+	if r := m.GetDetail().GetFirst("__chatreceipt"); r != nil {
+		if id := r.GetAttr("messageId"); id != "" {
+			return id
+		}
+	}
+
+	return m.GetUID()
+}
+
 func MakeChatMessage(c *ChatMessage) *cotproto.TakMessage {
 	t := time.Now().UTC().Format(time.RFC3339)
 	msgUID := fmt.Sprintf("GeoChat.%s.%s.%s", c.FromUID, c.ToUID, c.ID)
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/cotproto"
+)
+
+// FileShare is a file transfer offer (b-f-t-r), client downloads file from URL and acks with b-f-t-a
+type FileShare struct {
+	ID             string
+	Filename       string
+	Name           string
+	URL            string
+	Size           int64
+	Hash           string
+	SenderUID      string
+	SenderCallsign string
+	Dest           []string
+	DestUIDs       []string
+}
+
+func MakeFileShare(f *FileShare) *cotproto.TakMessage {
+	msg := cot.BasicMsg("b-f-t-r", f.ID, time.Hour*24)
+	msg.CotEvent.How = "h-e"
+
+	xd := cot.NewXMLDetails()
+	xd.AddChild("fileshare", map[string]string{
+		"filename":       f.Filename,
+		"name":           f.Name,
+		"senderUrl":      f.URL,
+		"sizeInBytes":    strconv.FormatInt(f.Size, 10),
+		"sha256":         f.Hash,
+		"senderUid":      f.SenderUID,
+		"senderCallsign": f.SenderCallsign,
+	}, "")
+	xd.AddChild("ackrequest", map[string]string{"uid": f.ID, "ackrequested": "true", "tag": f.Name}, "")
+
+	if len(f.Dest) > 0 || len(f.DestUIDs) > 0 {
+		marti := xd.AddChild("marti", nil, "")
+
+		for _, cs := range f.Dest {
+			marti.AddChild("dest", map[string]string{"callsign": cs}, "")
+		}
+
+		for _, uid := range f.DestUIDs {
+			marti.AddChild("dest", map[string]string{"uid": uid}, "")
+		}
+	}
+
+	msg.CotEvent.Detail = &cotproto.Detail{XmlDetail: xd.AsXMLString()}
+
+	return msg
+}
+
+// FileShareAck returns transfer id and uid of the contact from file transfer receipt (b-f-t-a)
+func FileShareAck(m *cot.CotMessage) (string, string) {
+	id := m.GetDetail().GetFirst("ackresponse").GetAttr("uid")
+	if id == "" {
+		id = m.GetDetail().GetFirst("ackrequest").GetAttr("uid")
+	}
+
+	uid, _ := m.GetParent()
+
+	return id, uid
+}
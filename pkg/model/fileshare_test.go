@@ -0,0 +1,49 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kdudkov/goasae/pkg/cot"
+	"github.com/kdudkov/goasae/pkg/cotproto"
+)
+
+func TestMakeFileShare(t *testing.T) {
+	msg := cot.LocalCotMessage(MakeFileShare(&FileShare{
+		ID:       "id1",
+		Filename: "file.kmz",
+		Name:     "file",
+		URL:      "https://localhost:8443/Marti/sync/content?hash=abc",
+		Size:     100,
+		Hash:     "abc",
+		Dest:     []string{"cs1", "cs2"},
+		DestUIDs: []string{"uid1"},
+	}))
+
+	assert.Equal(t, "b-f-t-r", msg.GetType())
+	assert.Equal(t, "id1", msg.GetUID())
+
+	fs := msg.GetDetail().GetFirst("fileshare")
+	require.NotNil(t, fs)
+	assert.Equal(t, "file.kmz", fs.GetAttr("filename"))
+	assert.Equal(t, "100", fs.GetAttr("sizeInBytes"))
+	assert.Equal(t, "abc", fs.GetAttr("sha256"))
+	assert.Equal(t, "id1", msg.GetDetail().GetFirst("ackrequest").GetAttr("uid"))
+	assert.Equal(t, []string{"cs1", "cs2"}, msg.GetDetail().GetDestCallsign())
+	assert.Equal(t, []string{"uid1"}, msg.GetDetail().GetDestUID())
+}
+
+func TestFileShareAck(t *testing.T) {
+	m := cot.BasicMsg("b-f-t-a", "ack1", time.Minute)
+	xd, _ := cot.DetailsFromString("<fileshare filename=\"file.kmz\" sha256=\"abc\"/>" +
+		"<ackresponse uid=\"id1\" ackrequested=\"true\" tag=\"file\" success=\"true\"/>" +
+		"<link uid=\"uid1\" type=\"a-f-G-U-C\" relation=\"p-p\"/>")
+	m.CotEvent.Detail = &cotproto.Detail{XmlDetail: xd.AsXMLString()}
+
+	id, uid := FileShareAck(&cot.CotMessage{TakMessage: m, Detail: xd})
+	assert.Equal(t, "id1", id)
+	assert.Equal(t, "uid1", uid)
+}
@@ -30,6 +30,33 @@ func DistBea(lat1, lon1, lat2, lon2 float64) (float64, float64) {
 	return dist, bea
 }
 
+// PointInPolygon checks if point is inside polygon using ray casting
+func PointInPolygon(lat, lon float64, polygon []*Pos) bool {
+	if len(polygon) < 3 {
+		return false
+	}
+
+	inside := false
+
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+
+		if (a.Lat > lat) != (b.Lat > lat) &&
+			lon < (b.Lon-a.Lon)*(lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// PointInCircle checks if point is within radius (meters) of center
+func PointInCircle(lat, lon, centerLat, centerLon, radius float64) bool {
+	d, _ := DistBea(centerLat, centerLon, lat, lon)
+
+	return d <= radius
+}
+
 type Pos struct {
 	Time  time.Time
 	Lat   float64
@@ -3,12 +3,15 @@ package model
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
 	url2 "net/url"
 	"strconv"
 	"strings"
 )
 
+var ErrInvalidFeed = errors.New("invalid feed")
+
 var defports = map[string]int{
 	"http":  80,
 	"https": 443,
@@ -67,6 +70,31 @@ type Feed2 struct {
 	Range     string  `json:"range,omitempty"   yaml:"range,omitempty"`
 	User      string  `json:"-"                 yaml:"user"`
 	Scope     string  `yaml:"scope"`
+	// Roles limits feed to users with one of the roles, empty means any role
+	Roles []string `json:"-" yaml:"roles,omitempty"`
+	// Relay makes clients get the feed from server relay instead of the source
+	Relay bool `json:"-" yaml:"relay,omitempty"`
+}
+
+// FeedDTO is a feed for admin api, url is also split into parts
+type FeedDTO struct {
+	UID       string   `json:"uid"`
+	Active    bool     `json:"active"`
+	Alias     string   `json:"alias"`
+	URL       string   `json:"url"`
+	Protocol  string   `json:"protocol"`
+	Address   string   `json:"address"`
+	Port      int      `json:"port"`
+	Path      string   `json:"path"`
+	Latitude  float64  `json:"lat,omitempty"`
+	Longitude float64  `json:"lon,omitempty"`
+	Fov       string   `json:"fov,omitempty"`
+	Heading   string   `json:"heading,omitempty"`
+	Range     string   `json:"range,omitempty"`
+	User      string   `json:"user"`
+	Scope     string   `json:"scope"`
+	Roles     []string `json:"roles"`
+	Relay     bool     `json:"relay"`
 }
 
 func (f *Feed2) ToFeed() *Feed {
@@ -78,7 +106,7 @@ func (f *Feed2) ToFeed() *Feed {
 
 	return &Feed{
 		UID:                 f.UID,
-		Active:              true,
+		Active:              f.Active,
 		Alias:               f.Alias,
 		Typ:                 "",
 		Address:             addr,
@@ -116,6 +144,96 @@ func (f *Feed2) WithScope(scope string) *Feed2 {
 	return f
 }
 
+// Validate checks feed url and makes it canonical
+func (f *Feed2) Validate() error {
+	if f == nil {
+		return ErrInvalidFeed
+	}
+
+	if strings.ContainsAny(f.UID, `/\`) {
+		return fmt.Errorf("%w: invalid uid", ErrInvalidFeed)
+	}
+
+	proto, addr, port, path := parseURL(f.URL)
+
+	if _, ok := defports[proto]; !ok {
+		return fmt.Errorf("%w: unsupported protocol %q", ErrInvalidFeed, proto)
+	}
+
+	if addr == "" {
+		return fmt.Errorf("%w: no host in url", ErrInvalidFeed)
+	}
+
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("%w: invalid port", ErrInvalidFeed)
+	}
+
+	// rtmp and srt ingest is not implemented in relay yet
+	if f.Relay && proto != "rtsp" {
+		return fmt.Errorf("%w: relay supports rtsp sources only", ErrInvalidFeed)
+	}
+
+	f.URL = toURL(proto, addr, port, path)
+
+	return nil
+}
+
+func (f *Feed2) ToDTO() *FeedDTO {
+	if f == nil {
+		return nil
+	}
+
+	proto, addr, port, path := parseURL(f.URL)
+
+	return &FeedDTO{
+		UID:       f.UID,
+		Active:    f.Active,
+		Alias:     f.Alias,
+		URL:       f.URL,
+		Protocol:  proto,
+		Address:   addr,
+		Port:      port,
+		Path:      path,
+		Latitude:  f.Latitude,
+		Longitude: f.Longitude,
+		Fov:       f.Fov,
+		Heading:   f.Heading,
+		Range:     f.Range,
+		User:      f.User,
+		Scope:     f.Scope,
+		Roles:     f.Roles,
+		Relay:     f.Relay,
+	}
+}
+
+// ToFeed2 makes feed from dto, url is made from parts when it's empty
+func (d *FeedDTO) ToFeed2() *Feed2 {
+	if d == nil {
+		return nil
+	}
+
+	url := d.URL
+	if url == "" && d.Protocol != "" {
+		url = toURL(d.Protocol, d.Address, d.Port, d.Path)
+	}
+
+	return &Feed2{
+		UID:       d.UID,
+		Active:    true,
+		Alias:     d.Alias,
+		URL:       url,
+		Latitude:  d.Latitude,
+		Longitude: d.Longitude,
+		Fov:       d.Fov,
+		Heading:   d.Heading,
+		Range:     d.Range,
+		User:      d.User,
+		Scope:     d.Scope,
+		Roles:     d.Roles,
+		Relay:     d.Relay,
+	}
+}
+
 func (f *Feed) ToFeed2() *Feed2 {
 	if f == nil {
 		return nil
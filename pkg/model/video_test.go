@@ -37,3 +37,26 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestFeedValidate(t *testing.T) {
+	f := &Feed2{UID: "f1", URL: "rtsp://10.0.0.1:554/cam1"}
+	assert.NoError(t, f.Validate())
+	assert.Equal(t, "rtsp://10.0.0.1/cam1", f.URL)
+
+	for _, u := range []string{"", "udp://239.0.0.1:1234", "rtsp:///cam1", "rtmp://host:99999/live", "not a url"} {
+		assert.ErrorIs(t, (&Feed2{UID: "f1", URL: u}).Validate(), ErrInvalidFeed, u)
+	}
+
+	assert.ErrorIs(t, (&Feed2{UID: "../f1", URL: "rtsp://host/cam"}).Validate(), ErrInvalidFeed)
+	assert.NoError(t, (&Feed2{UID: "f1", URL: "rtsp://host/cam", Relay: true}).Validate())
+	assert.ErrorIs(t, (&Feed2{UID: "f1", URL: "rtmp://host/live", Relay: true}).Validate(), ErrInvalidFeed)
+
+	d := (&Feed2{UID: "f1", URL: "srt://host:9000?streamid=cam1"}).ToDTO()
+	assert.Equal(t, "srt", d.Protocol)
+	assert.Equal(t, "host", d.Address)
+	assert.Equal(t, 9000, d.Port)
+
+	d.URL = ""
+	d.Port = 9710
+	assert.Equal(t, "srt://host?streamid=cam1", d.ToFeed2().URL)
+}